@@ -0,0 +1,185 @@
+package quic
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// altSvcProtocolID is the protocol QUIC is advertised as. The framing of this
+// package doesn't match any released gQUIC version, so browsers must not try
+// it as "quic". They ignore protocols they don't know.
+const altSvcProtocolID = "x-d4l3k-quic"
+
+// defaultAltSvcMaxAge is how long clients may remember that QUIC is
+// available.
+const defaultAltSvcMaxAge = 30 * 24 * time.Hour
+
+// AltSvcHandler returns a handler that advertises QUIC on port to clients
+// with Alt-Svc and Alternate-Protocol headers, before calling h.
+func AltSvcHandler(port int, maxAge time.Duration, h http.Handler) http.Handler {
+	altSvc := fmt.Sprintf(`%s=":%d"; ma=%d`, altSvcProtocolID, port, int(maxAge/time.Second))
+	alternateProtocol := fmt.Sprintf("%d:%s", port, altSvcProtocolID)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Alt-Svc", altSvc)
+		w.Header().Set("Alternate-Protocol", alternateProtocol)
+		h.ServeHTTP(w, r)
+	})
+}
+
+// DualServer serves one http.Handler over TLS with HTTP/1.1 and HTTP/2 on
+// TCP, and over QUIC on UDP, on the same port. Responses advertise the QUIC
+// server so clients switch to it.
+type DualServer struct {
+	// Addr is the address to listen on, ":https" if empty.
+	Addr string
+	// Handler handles requests, http.DefaultServeMux if nil.
+	Handler http.Handler
+	// TLSConfig holds the certificates of both servers.
+	TLSConfig *tls.Config
+	// QuicConfig configures the QUIC sessions, it may be nil.
+	QuicConfig *Config
+	// MaxAge is how long clients may remember that QUIC is available.
+	// Defaults to 30 days.
+	MaxAge time.Duration
+
+	mu         sync.Mutex
+	tcpServer  *http.Server
+	quicServer *Server
+	closed     bool
+}
+
+// ListenAndServeDual serves handler over TLS and QUIC on addr, using the
+// certificate and key in certFile and keyFile.
+func ListenAndServeDual(addr, certFile, keyFile string, handler http.Handler) error {
+	server := &DualServer{Addr: addr, Handler: handler}
+	return server.ListenAndServeTLS(certFile, keyFile)
+}
+
+// ListenAndServeTLS listens on s.Addr over TCP and UDP and serves requests
+// until either server fails or s is shut down, in which case it returns
+// http.ErrServerClosed. The certificate and key in certFile and keyFile are
+// added to s.TLSConfig if given.
+func (s *DualServer) ListenAndServeTLS(certFile, keyFile string) error {
+	tlsConfig := &tls.Config{}
+	if s.TLSConfig != nil {
+		tlsConfig = s.TLSConfig.Clone()
+	}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return err
+		}
+		tlsConfig.Certificates = append(tlsConfig.Certificates, cert)
+	}
+	addr := s.Addr
+	if addr == "" {
+		addr = ":https"
+	}
+
+	tcpListener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	// Listen on the port the TCP listener got, in case addr asked for any.
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		tcpListener.Close()
+		return err
+	}
+	port := tcpListener.Addr().(*net.TCPAddr).Port
+	conn, err := net.ListenPacket("udp", net.JoinHostPort(host, strconv.Itoa(port)))
+	if err != nil {
+		tcpListener.Close()
+		return err
+	}
+
+	handler := s.Handler
+	if handler == nil {
+		handler = http.DefaultServeMux
+	}
+	maxAge := s.MaxAge
+	if maxAge <= 0 {
+		maxAge = defaultAltSvcMaxAge
+	}
+	handler = AltSvcHandler(port, maxAge, handler)
+	tcpServer := &http.Server{Handler: handler, TLSConfig: tlsConfig}
+	quicServer := &Server{Handler: handler, TLSConfig: tlsConfig, QuicConfig: s.QuicConfig}
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		tcpListener.Close()
+		conn.Close()
+		return http.ErrServerClosed
+	}
+	s.tcpServer = tcpServer
+	s.quicServer = quicServer
+	s.mu.Unlock()
+
+	l, err := NewListener(conn, quicServer.config())
+	if err != nil {
+		tcpListener.Close()
+		conn.Close()
+		return err
+	}
+	errs := make(chan error, 2)
+	go func() {
+		errs <- tcpServer.ServeTLS(tcpListener, "", "")
+	}()
+	go func() {
+		errs <- quicServer.Serve(l)
+	}()
+	err = <-errs
+	s.mu.Lock()
+	closed := s.closed
+	s.mu.Unlock()
+	if !closed {
+		// One server failed, take the other down with it.
+		tcpServer.Close()
+		quicServer.Close()
+	}
+	<-errs
+	return err
+}
+
+// Shutdown gracefully shuts down both servers, see http.Server.Shutdown and
+// Listener.Shutdown.
+func (s *DualServer) Shutdown(ctx context.Context) error {
+	tcpServer, quicServer := s.close()
+	if tcpServer == nil {
+		return nil
+	}
+	errs := make(chan error, 1)
+	go func() {
+		errs <- quicServer.Shutdown(ctx)
+	}()
+	err := tcpServer.Shutdown(ctx)
+	if quicErr := <-errs; err == nil {
+		err = quicErr
+	}
+	return err
+}
+
+// Close closes both servers immediately.
+func (s *DualServer) Close() error {
+	tcpServer, quicServer := s.close()
+	if tcpServer == nil {
+		return nil
+	}
+	quicServer.Close()
+	return tcpServer.Close()
+}
+
+// close marks the server closed and returns the servers it started, if any.
+func (s *DualServer) close() (*http.Server, *Server) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return s.tcpServer, s.quicServer
+}
@@ -0,0 +1,22 @@
+package quic
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAltSvcHandler(t *testing.T) {
+	h := AltSvcHandler(4433, time.Hour, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+	// Browsers must not mistake the server for gQUIC.
+	if got, want := w.Header().Get("Alt-Svc"), `x-d4l3k-quic=":4433"; ma=3600`; got != want {
+		t.Errorf("Alt-Svc = %q, want %q", got, want)
+	}
+	if got, want := w.Header().Get("Alternate-Protocol"), "4433:x-d4l3k-quic"; got != want {
+		t.Errorf("Alternate-Protocol = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,445 @@
+package quic
+
+import "time"
+
+// BBR constants
+const (
+	// bbrHighGain is the pacing and congestion window gain in startup, which
+	// doubles the sending rate every round trip.
+	bbrHighGain = 2.885
+	// bbrDrainGain drains the queue built up during startup in one round trip.
+	bbrDrainGain = 1 / bbrHighGain
+	// bbrCwndGain leaves room for delayed and aggregated ACKs.
+	bbrCwndGain = 2.0
+	// bbrBandwidthWindow is the number of round trips the max bandwidth
+	// filter remembers samples for.
+	bbrBandwidthWindow = 10
+	// bbrMinRTTWindow is how long a min RTT sample is valid before the RTT is
+	// probed again.
+	bbrMinRTTWindow = 10 * time.Second
+	// bbrProbeRTTDuration is how long the probe RTT phase lasts.
+	bbrProbeRTTDuration = 200 * time.Millisecond
+	// bbrFullBandwidthThreshold is the bandwidth growth per round below which
+	// startup considers the pipe full.
+	bbrFullBandwidthThreshold = 1.25
+	// bbrFullBandwidthRounds is the number of rounds without growth before
+	// leaving startup.
+	bbrFullBandwidthRounds = 3
+	// bbrMinCongestionWindow is the smallest congestion window in packets.
+	bbrMinCongestionWindow = 4
+)
+
+// bbrPacingGainCycle is the pacing gain used in each round trip of the probe
+// bandwidth phase: probe for more bandwidth, drain the queue it created, then
+// cruise.
+var bbrPacingGainCycle = []float64{1.25, 0.75, 1, 1, 1, 1, 1, 1}
+
+// bbrMode is the state of the BBR state machine.
+type bbrMode int
+
+// BBR modes
+const (
+	bbrStartup bbrMode = iota
+	bbrDrain
+	bbrProbeBandwidth
+	bbrProbeRTT
+)
+
+// bbrRecoveryState is how the congestion window reacts to loss. BBR doesn't
+// slow down on loss, but while recovering it keeps the data in flight from
+// growing, so retransmissions replace lost packets instead of adding to a
+// queue that may be the cause of the loss.
+type bbrRecoveryState int
+
+// BBR recovery states
+const (
+	// bbrNotInRecovery is the normal state.
+	bbrNotInRecovery bbrRecoveryState = iota
+	// bbrConservation sends one packet per packet acknowledged, for the
+	// first round trip of recovery.
+	bbrConservation
+	// bbrGrowth is the rest of recovery, where the window grows with each
+	// ACK as in slow start.
+	bbrGrowth
+)
+
+// bbrPacketState is the delivery state at the time a packet was sent, used
+// to compute a bandwidth sample when it is acknowledged.
+type bbrPacketState struct {
+	sentTime      time.Time
+	delivered     uint64
+	deliveredTime time.Time
+	firstSentTime time.Time
+}
+
+// bbrSender implements CongestionControl using BBR. Rather than reacting to
+// loss it models the path's bottleneck bandwidth and round trip propagation
+// time and paces at the bandwidth, which keeps throughput up on lossy links.
+type bbrSender struct {
+	rtt  *RTTStats
+	mode bbrMode
+
+	packets map[uint64]*bbrPacketState
+
+	delivered     uint64
+	deliveredTime time.Time
+	firstSentTime time.Time
+
+	roundCount         uint64
+	nextRoundDelivered uint64
+	maxBandwidth       windowedMaxFilter
+
+	minRTT          time.Duration
+	minRTTTimestamp time.Time
+
+	pacingGain float64
+	cwndGain   float64
+	cwnd       uint64
+
+	cycleIndex int
+	cycleStart time.Time
+
+	fullBandwidth      Bandwidth
+	fullBandwidthCount int
+	atFullBandwidth    bool
+
+	probeRTTDoneTime time.Time
+
+	largestSent uint64
+	// recoveryWindow caps the congestion window while recovering from
+	// loss, until a packet sent after endRecoveryAt is acknowledged.
+	recoveryState  bbrRecoveryState
+	recoveryWindow uint64
+	endRecoveryAt  uint64
+}
+
+// NewBBR returns a BBR congestion controller.
+func NewBBR(rtt *RTTStats) CongestionControl {
+	return &bbrSender{
+		rtt:          rtt,
+		mode:         bbrStartup,
+		packets:      map[uint64]*bbrPacketState{},
+		maxBandwidth: windowedMaxFilter{window: bbrBandwidthWindow},
+		pacingGain:   bbrHighGain,
+		cwndGain:     bbrHighGain,
+		cwnd:         initialCongestionWindow * maxSegmentSize,
+	}
+}
+
+// bdp returns the estimated bandwidth delay product in bytes scaled by gain.
+func (b *bbrSender) bdp(gain float64) uint64 {
+	bandwidth := b.maxBandwidth.Best()
+	if bandwidth == 0 || b.minRTT == 0 {
+		return initialCongestionWindow * maxSegmentSize
+	}
+	return uint64(gain * float64(bandwidth/BytesPerSecond) * b.minRTT.Seconds())
+}
+
+func (b *bbrSender) OnPacketSent(sentTime time.Time, bytesInFlight, sequenceNumber, bytes uint64, retransmittable bool) {
+	if !retransmittable {
+		return
+	}
+	b.largestSent = sequenceNumber
+	if bytesInFlight == 0 {
+		// Nothing in flight, so don't let the idle period count against the
+		// bandwidth samples. Packets we still track were retransmitted
+		// without being reported lost.
+		b.firstSentTime = sentTime
+		b.deliveredTime = sentTime
+		b.packets = map[uint64]*bbrPacketState{}
+	}
+	b.packets[sequenceNumber] = &bbrPacketState{
+		sentTime:      sentTime,
+		delivered:     b.delivered,
+		deliveredTime: b.deliveredTime,
+		firstSentTime: b.firstSentTime,
+	}
+}
+
+func (b *bbrSender) OnPacketAcked(ackTime time.Time, sequenceNumber, ackedBytes, bytesInFlight uint64) {
+	state, ok := b.packets[sequenceNumber]
+	if !ok {
+		return
+	}
+	delete(b.packets, sequenceNumber)
+
+	b.delivered += ackedBytes
+	b.deliveredTime = ackTime
+	b.firstSentTime = state.sentTime
+
+	roundStart := false
+	if state.delivered >= b.nextRoundDelivered {
+		b.nextRoundDelivered = b.delivered
+		b.roundCount++
+		roundStart = true
+	}
+
+	// The delivery rate is limited by whichever of the send and ack rates is
+	// slower, so ACK compression doesn't inflate it.
+	interval := state.sentTime.Sub(state.firstSentTime)
+	if ackElapsed := ackTime.Sub(state.deliveredTime); ackElapsed > interval {
+		interval = ackElapsed
+	}
+	if interval > 0 {
+		b.maxBandwidth.Update(BandwidthFromDelta(b.delivered-state.delivered, interval), b.roundCount)
+	}
+
+	minRTTExpired := !b.minRTTTimestamp.IsZero() && ackTime.Sub(b.minRTTTimestamp) > bbrMinRTTWindow
+	if sample := b.rtt.LatestRTT(); sample > 0 && (b.minRTT == 0 || sample <= b.minRTT || minRTTExpired) {
+		b.minRTT = sample
+		b.minRTTTimestamp = ackTime
+	}
+
+	if roundStart {
+		b.checkFullBandwidth()
+	}
+	b.updateMode(ackTime, bytesInFlight, minRTTExpired)
+	b.updateRecovery(sequenceNumber, ackedBytes, bytesInFlight, roundStart)
+	b.updateCongestionWindow(ackedBytes)
+}
+
+// updateRecovery leaves recovery once a packet sent after the loss is
+// acknowledged, and otherwise lets the recovery window follow the ACKs.
+func (b *bbrSender) updateRecovery(sequenceNumber, ackedBytes, bytesInFlight uint64, roundStart bool) {
+	switch b.recoveryState {
+	case bbrNotInRecovery:
+		return
+	case bbrConservation:
+		if roundStart {
+			b.recoveryState = bbrGrowth
+		}
+	}
+	if sequenceNumber > b.endRecoveryAt {
+		b.recoveryState = bbrNotInRecovery
+		return
+	}
+	if b.recoveryState == bbrGrowth {
+		b.recoveryWindow += ackedBytes
+	}
+	if b.recoveryWindow < bytesInFlight+ackedBytes {
+		b.recoveryWindow = bytesInFlight + ackedBytes
+	}
+	if b.recoveryWindow < bbrMinCongestionWindow*maxSegmentSize {
+		b.recoveryWindow = bbrMinCongestionWindow * maxSegmentSize
+	}
+}
+
+// checkFullBandwidth leaves startup once the bandwidth stops growing.
+func (b *bbrSender) checkFullBandwidth() {
+	if b.atFullBandwidth {
+		return
+	}
+	bandwidth := b.maxBandwidth.Best()
+	if float64(bandwidth) >= float64(b.fullBandwidth)*bbrFullBandwidthThreshold {
+		b.fullBandwidth = bandwidth
+		b.fullBandwidthCount = 0
+		return
+	}
+	b.fullBandwidthCount++
+	if b.fullBandwidthCount >= bbrFullBandwidthRounds {
+		b.atFullBandwidth = true
+	}
+}
+
+// updateMode advances the state machine.
+func (b *bbrSender) updateMode(now time.Time, bytesInFlight uint64, minRTTExpired bool) {
+	switch b.mode {
+	case bbrStartup:
+		if b.atFullBandwidth {
+			b.mode = bbrDrain
+			b.pacingGain = bbrDrainGain
+			b.cwndGain = bbrHighGain
+		}
+	case bbrDrain:
+		if bytesInFlight <= b.bdp(1) {
+			b.enterProbeBandwidth(now)
+		}
+	case bbrProbeBandwidth:
+		b.updateGainCycle(now, bytesInFlight)
+	case bbrProbeRTT:
+		if b.probeRTTDoneTime.IsZero() {
+			if bytesInFlight <= bbrMinCongestionWindow*maxSegmentSize {
+				b.probeRTTDoneTime = now.Add(bbrProbeRTTDuration)
+			}
+		} else if now.After(b.probeRTTDoneTime) {
+			b.minRTTTimestamp = now
+			if b.atFullBandwidth {
+				b.enterProbeBandwidth(now)
+			} else {
+				b.mode = bbrStartup
+				b.pacingGain = bbrHighGain
+				b.cwndGain = bbrHighGain
+			}
+		}
+	}
+
+	if minRTTExpired && b.mode != bbrProbeRTT {
+		b.mode = bbrProbeRTT
+		b.pacingGain = 1
+		b.probeRTTDoneTime = time.Time{}
+	}
+}
+
+// enterProbeBandwidth starts cycling through the pacing gains.
+func (b *bbrSender) enterProbeBandwidth(now time.Time) {
+	b.mode = bbrProbeBandwidth
+	b.cwndGain = bbrCwndGain
+	// Don't start with the drain phase of the cycle.
+	b.cycleIndex = len(bbrPacingGainCycle) - 1
+	b.advanceGainCycle(now)
+}
+
+// updateGainCycle moves to the next pacing gain after a min RTT, extending
+// the probing phase until it has built a queue and the draining phase until
+// the queue is gone.
+func (b *bbrSender) updateGainCycle(now time.Time, bytesInFlight uint64) {
+	advance := now.Sub(b.cycleStart) > b.minRTT
+	if b.pacingGain > 1 && bytesInFlight < b.bdp(b.pacingGain) {
+		advance = false
+	}
+	if b.pacingGain < 1 && bytesInFlight <= b.bdp(1) {
+		advance = true
+	}
+	if advance {
+		b.advanceGainCycle(now)
+	}
+}
+
+func (b *bbrSender) advanceGainCycle(now time.Time) {
+	b.cycleIndex = (b.cycleIndex + 1) % len(bbrPacingGainCycle)
+	b.cycleStart = now
+	b.pacingGain = bbrPacingGainCycle[b.cycleIndex]
+}
+
+// updateCongestionWindow grows the congestion window towards its target.
+func (b *bbrSender) updateCongestionWindow(ackedBytes uint64) {
+	if b.mode == bbrProbeRTT {
+		b.cwnd = bbrMinCongestionWindow * maxSegmentSize
+		return
+	}
+	target := b.bdp(b.cwndGain)
+	if b.atFullBandwidth {
+		if b.cwnd+ackedBytes < target {
+			target = b.cwnd + ackedBytes
+		}
+		b.cwnd = target
+	} else if b.cwnd < target || b.delivered < initialCongestionWindow*maxSegmentSize {
+		b.cwnd += ackedBytes
+	}
+	if b.cwnd < bbrMinCongestionWindow*maxSegmentSize {
+		b.cwnd = bbrMinCongestionWindow * maxSegmentSize
+	}
+	if b.cwnd > maxCongestionWindow*maxSegmentSize {
+		b.cwnd = maxCongestionWindow * maxSegmentSize
+	}
+}
+
+// OnPacketLost starts recovery. The bandwidth model is left alone, BBR
+// doesn't treat loss as a congestion signal.
+func (b *bbrSender) OnPacketLost(sequenceNumber, lostBytes, bytesInFlight uint64) {
+	delete(b.packets, sequenceNumber)
+	if b.recoveryState == bbrNotInRecovery {
+		b.recoveryState = bbrConservation
+		b.recoveryWindow = bytesInFlight + lostBytes
+		// Stay in conservation for a whole round.
+		b.nextRoundDelivered = b.delivered
+	}
+	// Losses of packets sent during recovery extend it.
+	b.endRecoveryAt = b.largestSent
+	if b.recoveryWindow > lostBytes+bbrMinCongestionWindow*maxSegmentSize {
+		b.recoveryWindow -= lostBytes
+	} else {
+		b.recoveryWindow = bbrMinCongestionWindow * maxSegmentSize
+	}
+}
+
+// OnRetransmissionTimeout forgets the packets in flight, which have all been
+// retransmitted, and collapses the congestion window.
+func (b *bbrSender) OnRetransmissionTimeout(packetsRetransmitted bool) {
+	if packetsRetransmitted {
+		b.packets = map[uint64]*bbrPacketState{}
+		b.cwnd = bbrMinCongestionWindow * maxSegmentSize
+		b.recoveryState = bbrNotInRecovery
+	}
+}
+
+// congestionWindow returns the congestion window, capped by the recovery
+// window while recovering from loss.
+func (b *bbrSender) congestionWindow() uint64 {
+	if b.recoveryState != bbrNotInRecovery && b.recoveryWindow < b.cwnd {
+		return b.recoveryWindow
+	}
+	return b.cwnd
+}
+
+func (b *bbrSender) CanSend(bytesInFlight uint64) bool {
+	return bytesInFlight < b.congestionWindow()
+}
+
+func (b *bbrSender) BandwidthEstimate() Bandwidth {
+	return b.maxBandwidth.Best()
+}
+
+// PacingRate returns the rate packets should be paced at.
+func (b *bbrSender) PacingRate() Bandwidth {
+	bandwidth := b.maxBandwidth.Best()
+	if bandwidth == 0 {
+		bandwidth = BandwidthFromDelta(b.cwnd, b.rtt.SmoothedRTT())
+	}
+	return Bandwidth(b.pacingGain * float64(bandwidth))
+}
+
+// windowedMaxFilter tracks the maximum of a series of bandwidth samples over
+// a window of round trips, keeping the best three samples so that the max
+// can decay once the best sample ages out.
+type windowedMaxFilter struct {
+	window    uint64
+	estimates [3]struct {
+		value Bandwidth
+		round uint64
+	}
+}
+
+// Best returns the current maximum.
+func (f *windowedMaxFilter) Best() Bandwidth {
+	return f.estimates[0].value
+}
+
+// Update adds a sample taken in the given round.
+func (f *windowedMaxFilter) Update(sample Bandwidth, round uint64) {
+	e := &f.estimates
+	if e[0].value == 0 || sample >= e[0].value || round-e[2].round > f.window {
+		for i := range e {
+			e[i].value, e[i].round = sample, round
+		}
+		return
+	}
+	if sample >= e[1].value {
+		e[1].value, e[1].round = sample, round
+		e[2] = e[1]
+	} else if sample >= e[2].value {
+		e[2].value, e[2].round = sample, round
+	}
+
+	if round-e[0].round > f.window {
+		// The best sample expired, promote the others.
+		e[0] = e[1]
+		e[1] = e[2]
+		e[2].value, e[2].round = sample, round
+		if round-e[0].round > f.window {
+			e[0] = e[1]
+			e[1] = e[2]
+		}
+		return
+	}
+	if e[1].value == e[0].value && round-e[1].round > f.window/4 {
+		// Replace the second best if it's been the same as the best for a
+		// quarter of the window.
+		e[1].value, e[1].round = sample, round
+		e[2] = e[1]
+		return
+	}
+	if e[2].value == e[1].value && round-e[2].round > f.window/2 {
+		e[2].value, e[2].round = sample, round
+	}
+}
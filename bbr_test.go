@@ -0,0 +1,191 @@
+package quic
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// simLink is a bottleneck link with a drop tail queue and random loss. Since
+// it is a FIFO, packets arrive and are acknowledged in the order they were
+// sent.
+type simLink struct {
+	rate       Bandwidth
+	delay      time.Duration
+	queueLimit time.Duration
+	lossRate   float64
+	rand       *rand.Rand
+
+	free time.Time
+}
+
+// send returns when the ACK of a packet sent now reaches the sender, or
+// false if the packet is dropped.
+func (l *simLink) send(now time.Time, bytes uint64) (time.Time, bool) {
+	if l.free.Before(now) {
+		l.free = now
+	}
+	if l.free.Sub(now) > l.queueLimit || l.rand.Float64() < l.lossRate {
+		return time.Time{}, false
+	}
+	l.free = l.free.Add(l.rate.TransferTime(bytes))
+	return l.free.Add(2 * l.delay), true
+}
+
+type simPacket struct {
+	seq      uint64
+	sentTime time.Time
+	ackTime  time.Time
+}
+
+// simulateTransfer sends over link for duration with the congestion
+// controller newCongestion returns, detecting losses like the session does,
+// and returns the goodput.
+func simulateTransfer(newCongestion func(*RTTStats) CongestionControl, link *simLink, duration time.Duration) (Bandwidth, CongestionControl) {
+	start := time.Unix(0, 0)
+	now := start
+	rtt := &RTTStats{}
+	congestion := newCongestion(rtt)
+	pacer := newPacer(congestion, 0)
+
+	var (
+		seq           uint64
+		bytesInFlight uint64
+		ackedBytes    uint64
+		lastProgress  = now
+		outstanding   []simPacket
+		// acks are the delivered packets, in the order their ACKs arrive.
+		acks []simPacket
+	)
+	for now.Before(start.Add(duration)) {
+		for congestion.CanSend(bytesInFlight) && pacer.TimeUntilSend(now, bytesInFlight) == 0 {
+			seq++
+			if bytesInFlight == 0 {
+				lastProgress = now
+			}
+			congestion.OnPacketSent(now, bytesInFlight, seq, maxSegmentSize, true)
+			pacer.OnPacketSent(now, bytesInFlight, maxSegmentSize, true)
+			bytesInFlight += maxSegmentSize
+			p := simPacket{seq: seq, sentTime: now}
+			if ackTime, ok := link.send(now, maxSegmentSize); ok {
+				p.ackTime = ackTime
+				acks = append(acks, p)
+			}
+			outstanding = append(outstanding, p)
+		}
+
+		next := lastProgress.Add(rtt.RetransmissionTimeout())
+		if len(acks) > 0 && acks[0].ackTime.Before(next) {
+			next = acks[0].ackTime
+		}
+		if congestion.CanSend(bytesInFlight) {
+			if t := now.Add(pacer.TimeUntilSend(now, bytesInFlight)); t.Before(next) {
+				next = t
+			}
+		}
+		now = next
+
+		if len(acks) == 0 || acks[0].ackTime.After(now) {
+			if bytesInFlight > 0 && !now.Before(lastProgress.Add(rtt.RetransmissionTimeout())) {
+				// Everything outstanding has been dropped.
+				outstanding = nil
+				bytesInFlight = 0
+				congestion.OnRetransmissionTimeout(true)
+				lastProgress = now
+			}
+			continue
+		}
+		acked := acks[0]
+		acks = acks[1:]
+		rtt.UpdateRTT(now.Sub(acked.sentTime), 0, now)
+		lastProgress = now
+		bytesInFlight -= maxSegmentSize
+		ackedBytes += maxSegmentSize
+		congestion.OnPacketAcked(now, acked.seq, maxSegmentSize, bytesInFlight)
+		pending := outstanding[:0]
+		for _, p := range outstanding {
+			switch {
+			case p.seq == acked.seq:
+			case p.seq+nackThreshold <= acked.seq:
+				bytesInFlight -= maxSegmentSize
+				congestion.OnPacketLost(p.seq, maxSegmentSize, bytesInFlight)
+			default:
+				pending = append(pending, p)
+			}
+		}
+		outstanding = pending
+	}
+	return BandwidthFromDelta(ackedBytes, duration), congestion
+}
+
+func TestBBRRandomLoss(t *testing.T) {
+	newLink := func() *simLink {
+		return &simLink{
+			rate:       10 * MBitsPerSecond,
+			delay:      20 * time.Millisecond,
+			queueLimit: 40 * time.Millisecond,
+			lossRate:   0.02,
+			rand:       rand.New(rand.NewSource(1)),
+		}
+	}
+	const duration = 30 * time.Second
+
+	bbr, congestion := simulateTransfer(NewBBR, newLink(), duration)
+	cubic, _ := simulateTransfer(NewCubic, newLink(), duration)
+	t.Logf("goodput with 2%% loss: BBR %d kbit/s, Cubic %d kbit/s", bbr/KBitsPerSecond, cubic/KBitsPerSecond)
+
+	if bbr < 7*MBitsPerSecond {
+		t.Errorf("BBR goodput = %d kbit/s, want at least 7000", bbr/KBitsPerSecond)
+	}
+	if 2*bbr < 3*cubic {
+		t.Errorf("BBR goodput = %d kbit/s, want at least 1.5 times Cubic's %d", bbr/KBitsPerSecond, cubic/KBitsPerSecond)
+	}
+	// Lost packets are forgotten.
+	if n := len(congestion.(*bbrSender).packets); n > maxCongestionWindow {
+		t.Errorf("BBR tracks %d packets", n)
+	}
+}
+
+func TestBBRRecovery(t *testing.T) {
+	b := NewBBR(&RTTStats{}).(*bbrSender)
+	now := time.Unix(0, 0)
+	var bytesInFlight uint64
+	// Fewer packets than the initial window are in flight, so only the
+	// recovery window can stop sending.
+	for seq := uint64(1); seq <= 8; seq++ {
+		b.OnPacketSent(now, bytesInFlight, seq, maxSegmentSize, true)
+		bytesInFlight += maxSegmentSize
+	}
+
+	bytesInFlight -= 2 * maxSegmentSize
+	b.OnPacketLost(1, maxSegmentSize, bytesInFlight+maxSegmentSize)
+	b.OnPacketLost(2, maxSegmentSize, bytesInFlight)
+	if b.CanSend(bytesInFlight) {
+		t.Errorf("CanSend(%d) after losing 2 packets = true, want false", bytesInFlight)
+	}
+	// In conservation, a packet may be sent for each one ACKed.
+	bytesInFlight -= maxSegmentSize
+	b.OnPacketAcked(now.Add(time.Millisecond), 3, maxSegmentSize, bytesInFlight)
+	if !b.CanSend(bytesInFlight) {
+		t.Errorf("CanSend(%d) after an ACK = false, want true", bytesInFlight)
+	}
+
+	// Recovery ends with the ACK of a packet sent after the loss.
+	b.OnPacketSent(now, bytesInFlight, 9, maxSegmentSize, true)
+	b.OnPacketAcked(now.Add(2*time.Millisecond), 9, maxSegmentSize, bytesInFlight)
+	if b.recoveryState != bbrNotInRecovery {
+		t.Errorf("recoveryState = %d, want bbrNotInRecovery", b.recoveryState)
+	}
+}
+
+func TestBBRRetransmissionTimeout(t *testing.T) {
+	b := NewBBR(&RTTStats{}).(*bbrSender)
+	now := time.Unix(0, 0)
+	for seq := uint64(1); seq <= 10; seq++ {
+		b.OnPacketSent(now, (seq-1)*maxSegmentSize, seq, maxSegmentSize, true)
+	}
+	b.OnRetransmissionTimeout(true)
+	if len(b.packets) != 0 {
+		t.Errorf("tracking %d packets after an RTO, want 0", len(b.packets))
+	}
+}
@@ -0,0 +1,304 @@
+package quic
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"hash/fnv"
+	"io"
+)
+
+// Certificate entry types of a compressed certificate chain.
+const (
+	certEntryEnd        = 0
+	certEntryCompressed = 1
+	certEntryCached     = 2
+	certEntryCommon     = 3
+)
+
+// maxUncompressedCertsSize limits the memory a server can make a client
+// allocate for its certificate chain.
+const maxUncompressedCertsSize = 128 * 1024
+
+// commonCertSet is a set of certificates both sides know, such as common
+// intermediates, which a compressed chain refers to by index.
+type commonCertSet struct {
+	hash  uint64
+	certs [][]byte
+}
+
+// commonCertSets are the sets we offer in CCS and accept references to.
+// The gQUIC spec's sets are Chromium's bundled lists of common
+// intermediates, which we don't ship, so there are none by default and CCS
+// is only sent once sets are added here.
+var commonCertSets []commonCertSet
+
+// commonCertSubstrings seeds the zlib dictionary with strings that are
+// common in certificates: algorithm and attribute OIDs, and extension
+// prefixes. The gQUIC spec uses Chromium's pre-shared dictionary instead,
+// which isn't bundled here either. Until it is, chains with compressed
+// certificates can only be decompressed by this package, while cached and
+// common certificate references work with any implementation.
+var commonCertSubstrings = []byte("" +
+	"\x06\x09\x2a\x86\x48\x86\xf7\x0d\x01\x01\x01\x05\x00" + // rsaEncryption
+	"\x06\x09\x2a\x86\x48\x86\xf7\x0d\x01\x01\x05\x05\x00" + // sha1WithRSAEncryption
+	"\x06\x09\x2a\x86\x48\x86\xf7\x0d\x01\x01\x0b\x05\x00" + // sha256WithRSAEncryption
+	"\x06\x07\x2a\x86\x48\xce\x3d\x02\x01" + // ecPublicKey
+	"\x06\x08\x2a\x86\x48\xce\x3d\x03\x01\x07" + // prime256v1
+	"\x06\x08\x2a\x86\x48\xce\x3d\x04\x03\x02" + // ecdsaWithSHA256
+	"\x06\x03\x55\x04\x06\x13\x02" + // countryName
+	"\x06\x03\x55\x04\x08\x0c" + // stateOrProvinceName
+	"\x06\x03\x55\x04\x07\x0c" + // localityName
+	"\x06\x03\x55\x04\x0a\x0c" + // organizationName
+	"\x06\x03\x55\x04\x0b\x0c" + // organizationalUnitName
+	"\x06\x03\x55\x04\x03\x0c" + // commonName
+	"\x06\x03\x55\x1d\x0e\x04\x16\x04\x14" + // subjectKeyIdentifier
+	"\x06\x03\x55\x1d\x23\x04\x18\x30\x16\x80\x14" + // authorityKeyIdentifier
+	"\x06\x03\x55\x1d\x13\x01\x01\xff\x04" + // basicConstraints
+	"\x06\x03\x55\x1d\x0f\x01\x01\xff\x04\x04\x03\x02" + // keyUsage
+	"\x06\x03\x55\x1d\x25\x04" + // extKeyUsage
+	"\x06\x08\x2b\x06\x01\x05\x05\x07\x03\x01" + // serverAuth
+	"\x06\x08\x2b\x06\x01\x05\x05\x07\x03\x02" + // clientAuth
+	"\x06\x03\x55\x1d\x11\x04" + // subjectAltName
+	"\x06\x03\x55\x1d\x1f\x04" + // cRLDistributionPoints
+	"\x06\x03\x55\x1d\x20\x04" + // certificatePolicies
+	"\x06\x08\x2b\x06\x01\x05\x05\x07\x01\x01\x04" + // authorityInfoAccess
+	"\x06\x08\x2b\x06\x01\x05\x05\x07\x30\x01\x86" + // ocsp
+	"\x06\x08\x2b\x06\x01\x05\x05\x07\x30\x02\x86" + // caIssuers
+	"http://ocsp.http://crl..crl.crthttps://www.Certification AuthorityInc.")
+
+// certHash returns the hash a cached certificate is referred to by.
+func certHash(cert []byte) uint64 {
+	h := fnv.New64a()
+	h.Write(cert)
+	return h.Sum64()
+}
+
+// certHashes returns the CCRT value listing the hashes of certs.
+func certHashes(certs [][]byte) []byte {
+	buf := make([]byte, 8*len(certs))
+	for i, cert := range certs {
+		binary.LittleEndian.PutUint64(buf[8*i:], certHash(cert))
+	}
+	return buf
+}
+
+// commonCertSetHashes returns the CCS value listing the common sets we know.
+func commonCertSetHashes() []byte {
+	buf := make([]byte, 8*len(commonCertSets))
+	for i, set := range commonCertSets {
+		binary.LittleEndian.PutUint64(buf[8*i:], set.hash)
+	}
+	return buf
+}
+
+// parseHashes parses a list of 64 bit hashes such as CCS or CCRT.
+func parseHashes(buf []byte) map[uint64]bool {
+	hashes := map[uint64]bool{}
+	for i := 0; i+8 <= len(buf); i += 8 {
+		hashes[binary.LittleEndian.Uint64(buf[i:])] = true
+	}
+	return hashes
+}
+
+// certEntry describes how a certificate of a chain is sent.
+type certEntry struct {
+	typ     byte
+	hash    uint64
+	setHash uint64
+	index   uint32
+}
+
+// compressCertChain compresses a certificate chain for a client that has
+// the certificates hashed in cachedHashes (CCRT) and the common certificate
+// sets in commonSetHashes (CCS). Those certificates are sent as references,
+// the rest are zlib compressed with a dictionary made of the referenced
+// certificates and commonCertSubstrings.
+func compressCertChain(chain [][]byte, commonSetHashes, cachedHashes []byte) ([]byte, error) {
+	cached := parseHashes(cachedHashes)
+	common := parseHashes(commonSetHashes)
+	entries := make([]certEntry, len(chain))
+	var buf bytes.Buffer
+	uncompressedSize := 0
+	for i, cert := range chain {
+		entries[i] = certEntry{typ: certEntryCompressed}
+		if hash := certHash(cert); cached[hash] {
+			entries[i] = certEntry{typ: certEntryCached, hash: hash}
+		} else if setHash, index, ok := findCommonCert(common, cert); ok {
+			entries[i] = certEntry{typ: certEntryCommon, setHash: setHash, index: index}
+		} else {
+			uncompressedSize += 4 + len(cert)
+		}
+		buf.WriteByte(entries[i].typ)
+		switch entries[i].typ {
+		case certEntryCached:
+			binary.Write(&buf, binary.LittleEndian, entries[i].hash)
+		case certEntryCommon:
+			binary.Write(&buf, binary.LittleEndian, entries[i].setHash)
+			binary.Write(&buf, binary.LittleEndian, entries[i].index)
+		}
+	}
+	buf.WriteByte(certEntryEnd)
+	if uncompressedSize == 0 {
+		return buf.Bytes(), nil
+	}
+
+	binary.Write(&buf, binary.LittleEndian, uint32(uncompressedSize))
+	w, err := zlib.NewWriterLevelDict(&buf, zlib.BestCompression, certDictionary(chain, entries))
+	if err != nil {
+		return nil, err
+	}
+	for i, cert := range chain {
+		if entries[i].typ != certEntryCompressed {
+			continue
+		}
+		binary.Write(w, binary.LittleEndian, uint32(len(cert)))
+		w.Write(cert)
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decompressCertChain reverses compressCertChain. cachedCerts are the
+// certificates whose hashes the client sent in CCRT.
+func decompressCertChain(buf []byte, cachedCerts [][]byte) ([][]byte, error) {
+	errInvalid := qerr(QUIC_INVALID_CRYPTO_MESSAGE_PARAMETER, "invalid compressed certificate chain")
+	var entries []certEntry
+	var chain [][]byte
+	for {
+		if len(buf) < 1 {
+			return nil, errInvalid
+		}
+		typ := buf[0]
+		buf = buf[1:]
+		if typ == certEntryEnd {
+			break
+		}
+		entry := certEntry{typ: typ}
+		var cert []byte
+		switch typ {
+		case certEntryCompressed:
+		case certEntryCached:
+			if len(buf) < 8 {
+				return nil, errInvalid
+			}
+			entry.hash = binary.LittleEndian.Uint64(buf)
+			buf = buf[8:]
+			for _, c := range cachedCerts {
+				if certHash(c) == entry.hash {
+					cert = c
+					break
+				}
+			}
+			if cert == nil {
+				return nil, qerr(QUIC_INVALID_CRYPTO_MESSAGE_PARAMETER, "unknown cached certificate %x", entry.hash)
+			}
+		case certEntryCommon:
+			if len(buf) < 12 {
+				return nil, errInvalid
+			}
+			entry.setHash = binary.LittleEndian.Uint64(buf)
+			entry.index = binary.LittleEndian.Uint32(buf[8:])
+			buf = buf[12:]
+			if cert = commonCert(entry.setHash, entry.index); cert == nil {
+				return nil, qerr(QUIC_INVALID_CRYPTO_MESSAGE_PARAMETER, "unknown common certificate %x/%d", entry.setHash, entry.index)
+			}
+		default:
+			return nil, errInvalid
+		}
+		entries = append(entries, entry)
+		chain = append(chain, cert)
+	}
+
+	uncompressed := 0
+	for _, entry := range entries {
+		if entry.typ == certEntryCompressed {
+			uncompressed++
+		}
+	}
+	if uncompressed == 0 {
+		if len(buf) != 0 {
+			return nil, errInvalid
+		}
+		return chain, nil
+	}
+	if len(buf) < 4 {
+		return nil, errInvalid
+	}
+	size := binary.LittleEndian.Uint32(buf)
+	if size > maxUncompressedCertsSize {
+		return nil, qerr(QUIC_INVALID_CRYPTO_MESSAGE_PARAMETER, "certificate chain of %d bytes too large", size)
+	}
+	r, err := zlib.NewReaderDict(bytes.NewReader(buf[4:]), certDictionary(chain, entries))
+	if err != nil {
+		return nil, errInvalid
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, errInvalid
+	}
+	// Reading to the end checks the zlib checksum.
+	if n, err := r.Read(make([]byte, 1)); n != 0 || err != io.EOF {
+		return nil, errInvalid
+	}
+	for i, entry := range entries {
+		if entry.typ != certEntryCompressed {
+			continue
+		}
+		if len(data) < 4 {
+			return nil, errInvalid
+		}
+		n := binary.LittleEndian.Uint32(data)
+		if uint32(len(data)-4) < n {
+			return nil, errInvalid
+		}
+		chain[i] = data[4 : 4+n]
+		data = data[4+n:]
+	}
+	if len(data) != 0 {
+		return nil, errInvalid
+	}
+	return chain, nil
+}
+
+// certDictionary returns the zlib dictionary for a chain: the certificates
+// sent as references, last first, followed by commonCertSubstrings, which
+// zlib favours as it's nearest.
+func certDictionary(chain [][]byte, entries []certEntry) []byte {
+	var dict []byte
+	for i := len(entries) - 1; i >= 0; i-- {
+		if entries[i].typ != certEntryCompressed {
+			dict = append(dict, chain[i]...)
+		}
+	}
+	return append(dict, commonCertSubstrings...)
+}
+
+// findCommonCert returns the position of cert in one of the common sets the
+// client has.
+func findCommonCert(sets map[uint64]bool, cert []byte) (uint64, uint32, bool) {
+	for _, set := range commonCertSets {
+		if !sets[set.hash] {
+			continue
+		}
+		for i, c := range set.certs {
+			if bytes.Equal(c, cert) {
+				return set.hash, uint32(i), true
+			}
+		}
+	}
+	return 0, 0, false
+}
+
+// commonCert returns a certificate of a common set, or nil if we don't have
+// it.
+func commonCert(setHash uint64, index uint32) []byte {
+	for _, set := range commonCertSets {
+		if set.hash == setHash && int(index) < len(set.certs) {
+			return set.certs[index]
+		}
+	}
+	return nil
+}
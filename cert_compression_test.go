@@ -0,0 +1,194 @@
+package quic
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// exampleCertChain returns the chain of the example certificate.
+func exampleCertChain(t *testing.T) [][]byte {
+	return testTLSConfig(t).Certificates[0].Certificate
+}
+
+func TestCertChainCompression(t *testing.T) {
+	cert := exampleCertChain(t)[0]
+	// A second certificate that shares most of its bytes with the first,
+	// like an intermediate from the same CA would share its names.
+	other := append([]byte(nil), cert...)
+	other[len(other)-1] ^= 0xff
+
+	tests := []struct {
+		name   string
+		chain  [][]byte
+		cached [][]byte
+	}{
+		{"single certificate", [][]byte{cert}, nil},
+		{"cached leaf", [][]byte{cert}, [][]byte{cert}},
+		{"cached intermediate", [][]byte{other, cert}, [][]byte{cert}},
+		{"unrelated cached certificate", [][]byte{cert}, [][]byte{other}},
+		{"two certificates", [][]byte{cert, other}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf, err := compressCertChain(tt.chain, nil, certHashes(tt.cached))
+			if err != nil {
+				t.Fatal(err)
+			}
+			size := 0
+			for _, c := range tt.chain {
+				size += len(c)
+			}
+			if len(buf) >= size {
+				t.Errorf("compressed %d bytes of certificates to %d", size, len(buf))
+			}
+			got, err := decompressCertChain(buf, tt.cached)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.chain) {
+				t.Errorf("decompressCertChain() returned a different chain")
+			}
+		})
+	}
+}
+
+func TestCertChainCompressionCachedOnly(t *testing.T) {
+	cert := exampleCertChain(t)[0]
+	buf, err := compressCertChain([][]byte{cert}, nil, certHashes([][]byte{cert}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The type, the hash and the end of the entries.
+	if want := 1 + 8 + 1; len(buf) != want {
+		t.Errorf("compressed a cached certificate to %d bytes, want %d", len(buf), want)
+	}
+}
+
+// withCommonCertSets replaces commonCertSets for the rest of the test.
+func withCommonCertSets(t *testing.T, sets []commonCertSet) {
+	old := commonCertSets
+	commonCertSets = sets
+	t.Cleanup(func() { commonCertSets = old })
+}
+
+func TestCertChainCompressionCommonSets(t *testing.T) {
+	cert := exampleCertChain(t)[0]
+	intermediate := append([]byte(nil), cert...)
+	intermediate[len(intermediate)-1] ^= 0xff
+	withCommonCertSets(t, []commonCertSet{
+		{hash: 1, certs: [][]byte{cert}},
+		{hash: 2, certs: [][]byte{[]byte("another"), intermediate}},
+	})
+	chain := [][]byte{cert, intermediate}
+
+	tests := []struct {
+		name string
+		// sets are the hashes of the common sets the client has.
+		sets []uint64
+		// entries are the types of the chain's entries.
+		entries []byte
+	}{
+		{"client has every set", []uint64{1, 2}, []byte{certEntryCommon, certEntryCommon}},
+		{"client has one set", []uint64{2}, []byte{certEntryCompressed, certEntryCommon}},
+		{"client has no sets", nil, []byte{certEntryCompressed, certEntryCompressed}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ccs := make([]byte, 8*len(tt.sets))
+			for i, hash := range tt.sets {
+				binary.LittleEndian.PutUint64(ccs[8*i:], hash)
+			}
+			buf, err := compressCertChain(chain, ccs, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var entries []byte
+			for i := 0; buf[i] != certEntryEnd; i++ {
+				entries = append(entries, buf[i])
+				if buf[i] == certEntryCommon {
+					i += 12
+				}
+			}
+			if !bytes.Equal(entries, tt.entries) {
+				t.Errorf("entry types = %v, want %v", entries, tt.entries)
+			}
+			got, err := decompressCertChain(buf, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, chain) {
+				t.Errorf("decompressCertChain() returned a different chain")
+			}
+		})
+	}
+}
+
+func TestCommonCertSetsInCHLO(t *testing.T) {
+	sentCCS := func() ([]byte, bool) {
+		s, _ := newTestSession(t)
+		var ccs []byte
+		var ok bool
+		doSync(s, func() {
+			if err := s.sendCHLO(); err != nil {
+				return
+			}
+			if chlo := lastHandshakeMessage(s); chlo != nil {
+				ccs, ok = chlo.Values[TagCCS]
+			}
+		})
+		return ccs, ok
+	}
+	if ccs, ok := sentCCS(); ok {
+		t.Errorf("CHLO without common sets has CCS %x", ccs)
+	}
+	withCommonCertSets(t, []commonCertSet{{hash: 7}})
+	if ccs, _ := sentCCS(); !bytes.Equal(ccs, commonCertSetHashes()) {
+		t.Errorf("CHLO has CCS %x, want %x", ccs, commonCertSetHashes())
+	}
+}
+
+func TestDecompressCertChainErrors(t *testing.T) {
+	cert := exampleCertChain(t)[0]
+	compressed, err := compressCertChain([][]byte{cert}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cached, err := compressCertChain([][]byte{cert}, nil, certHashes([][]byte{cert}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tooLarge := append([]byte(nil), compressed...)
+	tooLarge[2] = 0xff
+	corrupt := append([]byte(nil), compressed...)
+	corrupt[len(corrupt)/2] ^= 0xff
+
+	tests := []struct {
+		name   string
+		buf    []byte
+		cached [][]byte
+	}{
+		{"empty", nil, nil},
+		{"no end of entries", []byte{certEntryCompressed}, nil},
+		{"unknown common certificate", []byte{certEntryCommon, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, certEntryEnd}, nil},
+		{"truncated common certificate", []byte{certEntryCommon, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}, nil},
+		{"unknown entry type", []byte{0x7f, certEntryEnd}, nil},
+		{"unknown cached certificate", cached, nil},
+		{"truncated cached hash", cached[:5], [][]byte{cert}},
+		{"trailing data", append(append([]byte(nil), cached...), 0), [][]byte{cert}},
+		{"missing size", compressed[:2], nil},
+		{"too large", tooLarge, nil},
+		{"truncated zlib data", compressed[:len(compressed)-10], nil},
+		{"corrupt zlib data", corrupt, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := decompressCertChain(tt.buf, tt.cached)
+			if !errors.Is(err, QUIC_INVALID_CRYPTO_MESSAGE_PARAMETER) {
+				t.Fatalf("decompressCertChain() = %v, want QUIC_INVALID_CRYPTO_MESSAGE_PARAMETER", err)
+			}
+		})
+	}
+}
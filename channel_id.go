@@ -0,0 +1,135 @@
+package quic
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"math/big"
+)
+
+// Channel ID tags
+const (
+	// TagCETV holds the client's encrypted tag values.
+	TagCETV Tag = 'C' | 'E'<<8 | 'T'<<16 | 'V'<<24
+	// TagCIDK is the client's channel ID public key, as X and Y.
+	TagCIDK Tag = 'C' | 'I'<<8 | 'D'<<16 | 'K'<<24
+	// TagCIDS is the channel ID signature, as R and S.
+	TagCIDS Tag = 'C' | 'I'<<8 | 'D'<<16 | 'S'<<24
+)
+
+// cetvKeyLabel is the key derivation label of the CETV encryption key.
+const cetvKeyLabel = "QUIC CETV block"
+
+// channelIDSignatureLabel prefixes the handshake hash a channel ID signs.
+var channelIDSignatureLabel = []byte("QUIC ChannelID\x00client -> server\x00")
+
+// errChannelIDCurve is returned for channel ID keys on other curves.
+var errChannelIDCurve = errors.New("quic: channel ID key must be on P-256")
+
+// channelIDCoordinateSize is the size of a P-256 coordinate.
+const channelIDCoordinateSize = 32
+
+// uncompressedPointPrefix starts a SEC 1 uncompressed point. CIDK holds the
+// point without it.
+const uncompressedPointPrefix = 4
+
+// channelIDSignedData returns what a channel ID signs: hashes of the CHLO,
+// without the CETV, and of the server config.
+func channelIDSignedData(chlo, scfg []byte) []byte {
+	chloHash := sha256.Sum256(chlo)
+	scfgHash := sha256.Sum256(scfg)
+	data := append([]byte(nil), channelIDSignatureLabel...)
+	data = append(data, chloHash[:]...)
+	return append(data, scfgHash[:]...)
+}
+
+// withoutCETV returns a serialized copy of the CHLO without its CETV.
+func withoutCETV(chlo *HandshakeMessage) []byte {
+	msg := NewHandshakeMessage(chlo.Tag)
+	for tag, v := range chlo.Values {
+		if tag != TagCETV {
+			msg.Values[tag] = v
+		}
+	}
+	return msg.ToBuf()
+}
+
+// addChannelID signs the CHLO with the channel ID key and adds the key and
+// signature to it as a CETV, encrypted with a key derived from the initial
+// secret so only the server can link them to the client.
+func (s *Session) addChannelID(chlo *HandshakeMessage, key *ecdsa.PrivateKey, secret []byte) error {
+	if key.Curve != elliptic.P256() {
+		return errChannelIDCurve
+	}
+	point, err := key.PublicKey.Bytes()
+	if err != nil {
+		return err
+	}
+	hello := chlo.ToBuf()
+	digest := sha256.Sum256(channelIDSignedData(hello, s.serverConfig.serialized))
+	r, sig, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	if err != nil {
+		return err
+	}
+	cetv := NewHandshakeMessage(TagCETV)
+	cetv.Values[TagCIDK] = point[1:]
+	cetv.Values[TagCIDS] = append(padCoordinate(r), padCoordinate(sig)...)
+	clientAEAD, _, err := deriveKeys(cetvKeyLabel, secret, s.clientNonce, s.ConnID, hello, s.serverConfig.serialized)
+	if err != nil {
+		return err
+	}
+	chlo.Values[TagCETV] = clientAEAD.Seal(cetv.ToBuf(), 0, nil)
+	return nil
+}
+
+// verifyChannelID decrypts the CETV of a CHLO and checks its channel ID
+// signature. It returns the client's channel ID key.
+func verifyChannelID(chlo *HandshakeMessage, secret []byte, connID uint64, scfg []byte) (*ecdsa.PublicKey, error) {
+	hello := withoutCETV(chlo)
+	clientAEAD, _, err := deriveKeys(cetvKeyLabel, secret, chlo.Values[TagNONC], connID, hello, scfg)
+	if err != nil {
+		return nil, err
+	}
+	buf, err := clientAEAD.Open(chlo.Values[TagCETV], 0, nil)
+	if err != nil {
+		return nil, qerr(QUIC_INVALID_CHANNEL_ID_SIGNATURE, "can't decrypt CETV")
+	}
+	cetv, n, err := ParseHandshakeMessage(buf)
+	if err != nil || n != len(buf) || cetv.Tag != TagCETV {
+		return nil, qerr(QUIC_INVALID_CHANNEL_ID_SIGNATURE, "invalid CETV")
+	}
+	cidk, cids := cetv.Values[TagCIDK], cetv.Values[TagCIDS]
+	if len(cidk) != 2*channelIDCoordinateSize || len(cids) != 2*channelIDCoordinateSize {
+		return nil, qerr(QUIC_INVALID_CHANNEL_ID_SIGNATURE, "invalid channel ID key or signature")
+	}
+	key, err := ecdsa.ParseUncompressedPublicKey(elliptic.P256(), append([]byte{uncompressedPointPrefix}, cidk...))
+	if err != nil {
+		return nil, qerr(QUIC_INVALID_CHANNEL_ID_SIGNATURE, "channel ID key not on P-256")
+	}
+	r := new(big.Int).SetBytes(cids[:channelIDCoordinateSize])
+	sig := new(big.Int).SetBytes(cids[channelIDCoordinateSize:])
+	digest := sha256.Sum256(channelIDSignedData(hello, scfg))
+	if !ecdsa.Verify(key, digest[:], r, sig) {
+		return nil, qerr(QUIC_INVALID_CHANNEL_ID_SIGNATURE, "invalid channel ID signature")
+	}
+	return key, nil
+}
+
+// padCoordinate returns n as a big endian P-256 coordinate.
+func padCoordinate(n *big.Int) []byte {
+	return n.FillBytes(make([]byte, channelIDCoordinateSize))
+}
+
+// ChannelID returns the channel ID key the client proved it holds in the
+// handshake, or nil if it didn't send one. It is always nil before the
+// handshake completes.
+func (s *Session) ChannelID() *ecdsa.PublicKey {
+	select {
+	case <-s.handshakeDone:
+		return s.channelID
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,231 @@
+package quic
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// channelIDTest holds what both sides of a handshake share when the client
+// adds a channel ID to its CHLO.
+type channelIDTest struct {
+	secret []byte
+	connID uint64
+	scfg   []byte
+	chlo   *HandshakeMessage
+}
+
+func newChannelIDTest(t *testing.T) *channelIDTest {
+	nonce := make([]byte, clientNonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		t.Fatal(err)
+	}
+	chlo := NewHandshakeMessage(TagCHLO)
+	chlo.Values[TagNONC] = nonce
+	chlo.Values[TagSNI] = []byte("example.com")
+	return &channelIDTest{
+		secret: []byte("shared secret"),
+		connID: 7,
+		scfg:   []byte("server config"),
+		chlo:   chlo,
+	}
+}
+
+// add signs the CHLO with key, as the client does.
+func (c *channelIDTest) add(key *ecdsa.PrivateKey) error {
+	s := &Session{
+		ConnID:       c.connID,
+		clientNonce:  c.chlo.Values[TagNONC],
+		serverConfig: &cachedServerConfig{serialized: c.scfg},
+	}
+	return s.addChannelID(c.chlo, key, c.secret)
+}
+
+// seal replaces the CHLO's CETV with cetv, encrypted like the client does.
+func (c *channelIDTest) seal(t *testing.T, cetv *HandshakeMessage) {
+	clientAEAD, _, err := deriveKeys(cetvKeyLabel, c.secret, c.chlo.Values[TagNONC], c.connID, withoutCETV(c.chlo), c.scfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.chlo.Values[TagCETV] = clientAEAD.Seal(cetv.ToBuf(), 0, nil)
+}
+
+// openCETV decrypts the CHLO's CETV.
+func (c *channelIDTest) openCETV(t *testing.T) *HandshakeMessage {
+	clientAEAD, _, err := deriveKeys(cetvKeyLabel, c.secret, c.chlo.Values[TagNONC], c.connID, withoutCETV(c.chlo), c.scfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf, err := clientAEAD.Open(c.chlo.Values[TagCETV], 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cetv, _, err := ParseHandshakeMessage(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cetv
+}
+
+// verify checks the channel ID, as the server does.
+func (c *channelIDTest) verify() (*ecdsa.PublicKey, error) {
+	return verifyChannelID(c.chlo, c.secret, c.connID, c.scfg)
+}
+
+func TestChannelID(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := newChannelIDTest(t)
+	if err := c.add(key); err != nil {
+		t.Fatal(err)
+	}
+	got, err := c.verify()
+	if err != nil {
+		t.Fatalf("verifyChannelID() = %v", err)
+	}
+	if !got.Equal(&key.PublicKey) {
+		t.Error("verifyChannelID() returned a different key")
+	}
+}
+
+func TestChannelIDOtherCurve(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := newChannelIDTest(t).add(key); err != errChannelIDCurve {
+		t.Errorf("addChannelID() with a P-384 key = %v, want %v", err, errChannelIDCurve)
+	}
+}
+
+func TestChannelIDRejected(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPoint, err := other.PublicKey.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		// change modifies the CHLO after the client added its channel ID.
+		change func(t *testing.T, c *channelIDTest)
+	}{
+		{"other server config", func(t *testing.T, c *channelIDTest) {
+			c.scfg = []byte("other server config")
+		}},
+		{"other secret", func(t *testing.T, c *channelIDTest) {
+			c.secret = []byte("other secret")
+		}},
+		{"corrupt CETV", func(t *testing.T, c *channelIDTest) {
+			c.chlo.Values[TagCETV][0] ^= 0xff
+		}},
+		{"not a CETV", func(t *testing.T, c *channelIDTest) {
+			c.seal(t, NewHandshakeMessage(TagCHLO))
+		}},
+		{"short key", func(t *testing.T, c *channelIDTest) {
+			cetv := c.openCETV(t)
+			cetv.Values[TagCIDK] = cetv.Values[TagCIDK][1:]
+			c.seal(t, cetv)
+		}},
+		{"short signature", func(t *testing.T, c *channelIDTest) {
+			cetv := c.openCETV(t)
+			cetv.Values[TagCIDS] = cetv.Values[TagCIDS][1:]
+			c.seal(t, cetv)
+		}},
+		{"key not on the curve", func(t *testing.T, c *channelIDTest) {
+			cetv := c.openCETV(t)
+			cidk := append([]byte(nil), cetv.Values[TagCIDK]...)
+			cidk[len(cidk)-1] ^= 1
+			cetv.Values[TagCIDK] = cidk
+			c.seal(t, cetv)
+		}},
+		{"signature by another key", func(t *testing.T, c *channelIDTest) {
+			cetv := c.openCETV(t)
+			cetv.Values[TagCIDK] = otherPoint[1:]
+			c.seal(t, cetv)
+		}},
+		{"tampered signature", func(t *testing.T, c *channelIDTest) {
+			cetv := c.openCETV(t)
+			cids := append([]byte(nil), cetv.Values[TagCIDS]...)
+			cids[0] ^= 0xff
+			cetv.Values[TagCIDS] = cids
+			c.seal(t, cetv)
+		}},
+		{"signature over another CHLO", func(t *testing.T, c *channelIDTest) {
+			cetv := c.openCETV(t)
+			c.chlo.Values[TagSNI] = []byte("example.org")
+			c.seal(t, cetv)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newChannelIDTest(t)
+			if err := c.add(key); err != nil {
+				t.Fatal(err)
+			}
+			tt.change(t, c)
+			if _, err := c.verify(); !errors.Is(err, QUIC_INVALID_CHANNEL_ID_SIGNATURE) {
+				t.Errorf("verifyChannelID() = %v, want QUIC_INVALID_CHANNEL_ID_SIGNATURE", err)
+			}
+		})
+	}
+}
+
+func TestChannelIDHandshake(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := NewListener(conn, &Config{TLSConfig: testTLSConfig(t)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	accepted := make(chan *Session, 1)
+	go func() {
+		if s, err := l.Accept(); err == nil {
+			accepted <- s
+		}
+	}()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := Dial(conn.LocalAddr().String(), &Config{
+		TLSConfig:    &tls.Config{InsecureSkipVerify: true},
+		ChannelIDKey: key,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	select {
+	case s := <-accepted:
+		select {
+		case <-s.handshakeDone:
+		case <-time.After(2 * time.Second):
+			t.Fatal("server handshake didn't complete")
+		}
+		if got := s.ChannelID(); got == nil || !got.Equal(&key.PublicKey) {
+			t.Errorf("ChannelID() = %v, want the client's key", got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no session accepted")
+	}
+}
@@ -0,0 +1,90 @@
+package quic
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"log"
+	"net"
+)
+
+// Dial opens a session to the QUIC server at addr, a host:port pair. config
+// may be nil to use the defaults.
+//
+// Dial returns once the handshake is complete. If config has a
+// ClientSessionCache holding the server's config from an earlier session,
+// Dial returns immediately and streams send 0-RTT data until the handshake
+// completes, unless Disable0RTT is set.
+func Dial(addr string, config *Config) (*Session, error) {
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	// Connection IDs are written as varints into 8 bytes, which holds 56 bits.
+	connID := binary.LittleEndian.Uint64(buf) & (1<<56 - 1)
+
+	s := newSession(conn, udpAddr, connID, config, nil, func() {
+		conn.Close()
+	})
+	go s.readPackets(conn)
+
+	started := make(chan bool, 1)
+	s.do(func() {
+		zeroRTT, err := s.startHandshake(host)
+		if err != nil {
+			s.closeWithError(QUIC_HANDSHAKE_FAILED, err.Error())
+		}
+		started <- zeroRTT
+	})
+	select {
+	case zeroRTT := <-started:
+		if zeroRTT {
+			return s, nil
+		}
+	case <-s.closeChan:
+		return nil, s.closeErr
+	}
+	select {
+	case <-s.handshakeDone:
+		return s, nil
+	case <-s.closeChan:
+		return nil, s.closeErr
+	}
+}
+
+// readPackets hands the packets a client receives on conn to the session
+// until conn is closed.
+func (s *Session) readPackets(conn net.PacketConn) {
+	buf := make([]byte, maxUDPPayloadSize)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if s.Err() == nil {
+				log.Println(err)
+			}
+			return
+		}
+		p, headerLen, err := ParsePublicHeader(buf[:n])
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		if p.ConnID != s.ConnID {
+			continue
+		}
+		data := append([]byte(nil), buf[:n]...)
+		s.queuePacket(receivedPacket{packet: p, addr: addr, size: n, data: data, headerLen: headerLen})
+	}
+}
@@ -0,0 +1,79 @@
+package quic
+
+import (
+	"container/list"
+	"sync"
+)
+
+// ClientSessionState is what a client learned about a server in an earlier
+// session, which lets it start the next one with 0-RTT.
+type ClientSessionState struct {
+	// ServerConfig is the serialized server config (SCFG).
+	ServerConfig []byte
+	// SourceAddressToken is the token proving the client's address.
+	SourceAddressToken []byte
+	// Certificates is the server's certificate chain, so the server can
+	// refer to the certificates instead of sending them again.
+	Certificates [][]byte
+}
+
+// ClientSessionCache caches ClientSessionState by server name. Implementations
+// must be safe for concurrent use, and may persist the states to disk.
+type ClientSessionCache interface {
+	Get(serverName string) (*ClientSessionState, bool)
+	Put(serverName string, state *ClientSessionState)
+}
+
+// lruClientSessionCache is a ClientSessionCache that keeps the most recently
+// used states in memory.
+type lruClientSessionCache struct {
+	mu       sync.Mutex
+	capacity int
+	entries  map[string]*list.Element
+	order    *list.List
+}
+
+type lruClientSessionCacheEntry struct {
+	serverName string
+	state      *ClientSessionState
+}
+
+// NewLRUClientSessionCache returns a ClientSessionCache holding up to
+// capacity states in memory. A capacity below 1 uses a default of 64.
+func NewLRUClientSessionCache(capacity int) ClientSessionCache {
+	if capacity < 1 {
+		capacity = 64
+	}
+	return &lruClientSessionCache{
+		capacity: capacity,
+		entries:  map[string]*list.Element{},
+		order:    list.New(),
+	}
+}
+
+func (c *lruClientSessionCache) Get(serverName string) (*ClientSessionState, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, ok := c.entries[serverName]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	return elem.Value.(*lruClientSessionCacheEntry).state, true
+}
+
+func (c *lruClientSessionCache) Put(serverName string, state *ClientSessionState) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.entries[serverName]; ok {
+		elem.Value.(*lruClientSessionCacheEntry).state = state
+		c.order.MoveToFront(elem)
+		return
+	}
+	if c.order.Len() >= c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*lruClientSessionCacheEntry).serverName)
+	}
+	c.entries[serverName] = c.order.PushFront(&lruClientSessionCacheEntry{serverName: serverName, state: state})
+}
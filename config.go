@@ -0,0 +1,193 @@
+package quic
+
+import (
+	"crypto/ecdsa"
+	"crypto/tls"
+	"time"
+)
+
+// Config configures QUIC sessions. A nil Config uses the defaults.
+type Config struct {
+	// NewCongestionControl creates the congestion controller of each
+	// session, e.g. NewCubic or a custom implementation. Defaults to NewCubic.
+	NewCongestionControl func(rtt *RTTStats) CongestionControl
+	// InitialBurst is the number of packets that may be sent back to back
+	// before pacing starts, and again after the session has been idle.
+	// Defaults to 10.
+	InitialBurst int
+	// MinRTO is the smallest retransmission timeout. Defaults to 200ms.
+	MinRTO time.Duration
+	// MinTLP is the smallest tail loss probe timeout. Defaults to 10ms.
+	MinTLP time.Duration
+	// IdleTimeout is how long a session may go without network activity
+	// before it is closed. The client and server use the lower of their
+	// values. Defaults to 30 seconds.
+	IdleTimeout time.Duration
+	// HandshakeTimeout is how long the crypto handshake may take. Defaults to
+	// 10 seconds.
+	HandshakeTimeout time.Duration
+	// KeepAlive sends a PING when the session has been idle for half the
+	// idle timeout, so NAT bindings of long-poll clients don't expire.
+	KeepAlive bool
+	// MaxIncomingStreams is the number of streams the peer may have open at
+	// once. The client and server use the lower of their values. Defaults to
+	// 100.
+	MaxIncomingStreams int
+	// MaxPacketSize is the largest UDP payload path MTU discovery probes
+	// for. Sessions start with 1252 byte packets, which any IPv6 path
+	// supports. Defaults to 1452, raise it on jumbo frame networks.
+	MaxPacketSize int
+	// DisableMigration closes sessions with QUIC_ERROR_MIGRATING_ADDRESS
+	// when the client's address changes, instead of following the client
+	// to its new address.
+	DisableMigration bool
+	// ClientSessionCache caches server configs and source-address tokens
+	// between the sessions of a client, so later sessions to the same server
+	// can send data in their first packet (0-RTT). Nil disables caching.
+	ClientSessionCache ClientSessionCache
+	// TLSConfig holds the server's certificates, which are served by a
+	// ProofSource from NewTLSProofSource unless ProofSource is set. Clients
+	// verify the server's certificate with its RootCAs and ServerName, the
+	// system roots and the dialed host are used by default.
+	TLSConfig *tls.Config
+	// ProofSource provides the server's certificates and signs its server
+	// configs with their keys.
+	ProofSource ProofSource
+	// ChannelIDKey is a persistent P-256 key clients prove they hold in the
+	// handshake, so servers can bind their credentials to the client.
+	// Servers expose it through Session.ChannelID.
+	ChannelIDKey *ecdsa.PrivateKey
+	// Disable0RTT stops clients sending data before the handshake completes,
+	// and makes servers reject the first CHLO of every session so they never
+	// act on data the client sent before hearing from them.
+	Disable0RTT bool
+}
+
+// newCongestionControl returns the congestion controller for a new session.
+func (c *Config) newCongestionControl(rtt *RTTStats) CongestionControl {
+	if c == nil || c.NewCongestionControl == nil {
+		return NewCubic(rtt)
+	}
+	return c.NewCongestionControl(rtt)
+}
+
+// initialBurst returns the number of packets sent before pacing starts.
+func (c *Config) initialBurst() int {
+	if c == nil || c.InitialBurst <= 0 {
+		return defaultInitialBurst
+	}
+	return c.InitialBurst
+}
+
+// minRTO returns the smallest retransmission timeout.
+func (c *Config) minRTO() time.Duration {
+	if c == nil || c.MinRTO <= 0 {
+		return defaultMinRTO
+	}
+	return c.MinRTO
+}
+
+// minTLP returns the smallest tail loss probe timeout.
+func (c *Config) minTLP() time.Duration {
+	if c == nil || c.MinTLP <= 0 {
+		return defaultMinTLPTimeout
+	}
+	return c.MinTLP
+}
+
+// idleTimeout returns the idle timeout to negotiate.
+func (c *Config) idleTimeout() time.Duration {
+	if c == nil || c.IdleTimeout <= 0 {
+		return defaultIdleTimeout
+	}
+	if c.IdleTimeout > maxIdleTimeout {
+		return maxIdleTimeout
+	}
+	return c.IdleTimeout
+}
+
+// handshakeTimeout returns how long the crypto handshake may take.
+func (c *Config) handshakeTimeout() time.Duration {
+	if c == nil || c.HandshakeTimeout <= 0 {
+		return defaultHandshakeTimeout
+	}
+	return c.HandshakeTimeout
+}
+
+// maxIncomingStreams returns the stream limit to negotiate.
+func (c *Config) maxIncomingStreams() uint32 {
+	if c == nil || c.MaxIncomingStreams <= 0 {
+		return defaultMaxStreams
+	}
+	return uint32(c.MaxIncomingStreams)
+}
+
+// maxPacketSize returns the largest packet size to probe for.
+func (c *Config) maxPacketSize() int {
+	if c == nil || c.MaxPacketSize <= 0 {
+		return defaultMaxPacketSize
+	}
+	if c.MaxPacketSize < initialPacketSize {
+		return initialPacketSize
+	}
+	if c.MaxPacketSize > maxUDPPayloadSize {
+		return maxUDPPayloadSize
+	}
+	return c.MaxPacketSize
+}
+
+// keepAlive reports whether keepalive PINGs are sent.
+func (c *Config) keepAlive() bool {
+	return c != nil && c.KeepAlive
+}
+
+// disableMigration reports whether sessions may follow the client to a new
+// address.
+func (c *Config) disableMigration() bool {
+	return c != nil && c.DisableMigration
+}
+
+// clientSessionCache returns the cache for server configs.
+func (c *Config) clientSessionCache() ClientSessionCache {
+	if c == nil {
+		return nil
+	}
+	return c.ClientSessionCache
+}
+
+// disable0RTT reports whether data may be sent or accepted before the
+// handshake completes.
+func (c *Config) disable0RTT() bool {
+	return c != nil && c.Disable0RTT
+}
+
+// proofSource returns the source of the server's certificates, or nil if it
+// has none. Listeners call it once and share the result between sessions.
+func (c *Config) proofSource() ProofSource {
+	if c == nil {
+		return nil
+	}
+	if c.ProofSource != nil {
+		return c.ProofSource
+	}
+	if c.TLSConfig != nil {
+		return NewTLSProofSource(c.TLSConfig)
+	}
+	return nil
+}
+
+// tlsConfig returns the TLS config clients verify certificates with.
+func (c *Config) tlsConfig() *tls.Config {
+	if c == nil {
+		return nil
+	}
+	return c.TLSConfig
+}
+
+// channelIDKey returns the client's channel ID key, or nil to not send one.
+func (c *Config) channelIDKey() *ecdsa.PrivateKey {
+	if c == nil {
+		return nil
+	}
+	return c.ChannelIDKey
+}
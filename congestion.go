@@ -0,0 +1,63 @@
+package quic
+
+import "time"
+
+// Bandwidth is a data rate in bits per second.
+type Bandwidth uint64
+
+// Bandwidth units
+const (
+	BitsPerSecond  Bandwidth = 1
+	KBitsPerSecond           = 1000 * BitsPerSecond
+	MBitsPerSecond           = 1000 * KBitsPerSecond
+	BytesPerSecond           = 8 * BitsPerSecond
+)
+
+// BandwidthFromDelta returns the bandwidth of transferring bytes over delta.
+func BandwidthFromDelta(bytes uint64, delta time.Duration) Bandwidth {
+	if delta <= 0 {
+		return 0
+	}
+	return Bandwidth(bytes) * BytesPerSecond * Bandwidth(time.Second) / Bandwidth(delta)
+}
+
+// TransferTime returns how long it takes to send bytes at bandwidth b.
+func (b Bandwidth) TransferTime(bytes uint64) time.Duration {
+	if b == 0 {
+		return 0
+	}
+	return time.Duration(Bandwidth(bytes) * BytesPerSecond * Bandwidth(time.Second) / b)
+}
+
+// Congestion control constants
+const (
+	// maxSegmentSize is the packet size congestion windows are counted in.
+	maxSegmentSize = 1350
+	// initialCongestionWindow is the initial congestion window in packets.
+	initialCongestionWindow = 10
+	// minCongestionWindow is the smallest congestion window in packets.
+	minCongestionWindow = 2
+	// maxCongestionWindow is the largest congestion window in packets.
+	maxCongestionWindow = 2000
+	// nackThreshold is how many newer packets have to be acknowledged before a
+	// missing packet is declared lost.
+	nackThreshold = 3
+)
+
+// CongestionControl decides how much data a session may have in flight.
+// Implementations are only called from the session goroutine.
+type CongestionControl interface {
+	// OnPacketSent is called for every packet that is sent. bytesInFlight
+	// doesn't include the packet being sent.
+	OnPacketSent(sentTime time.Time, bytesInFlight, sequenceNumber, bytes uint64, retransmittable bool)
+	// OnPacketAcked is called for every retransmittable packet the peer acknowledges.
+	OnPacketAcked(ackTime time.Time, sequenceNumber, ackedBytes, bytesInFlight uint64)
+	// OnPacketLost is called for every retransmittable packet declared lost.
+	OnPacketLost(sequenceNumber, lostBytes, bytesInFlight uint64)
+	// OnRetransmissionTimeout is called when the retransmission timer fires.
+	OnRetransmissionTimeout(packetsRetransmitted bool)
+	// CanSend reports whether another retransmittable packet may be sent.
+	CanSend(bytesInFlight uint64) bool
+	// BandwidthEstimate returns the estimated bandwidth to the peer.
+	BandwidthEstimate() Bandwidth
+}
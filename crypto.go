@@ -0,0 +1,172 @@
+package quic
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+)
+
+// errDecryptionFailed is returned for packets that can't be opened with the
+// keys we have.
+var errDecryptionFailed = errors.New("quic: packet decryption failed")
+
+// errUnencryptedPacket is returned for unencrypted packets carrying frames
+// that must be authenticated.
+var errUnencryptedPacket = qerr(QUIC_CRYPTO_ENCRYPTION_LEVEL_INCORRECT, "unexpected unencrypted packet")
+
+// encryptionLevel is the kind of keys a packet is sealed with.
+type encryptionLevel int
+
+// Encryption levels, in the order the handshake establishes them.
+const (
+	// encryptionUnencrypted packets only carry a hash against corruption.
+	encryptionUnencrypted encryptionLevel = iota
+	// encryptionInitial keys come from the client's ephemeral key and the
+	// server config's static key, so a client can use them for 0-RTT data.
+	encryptionInitial
+	// encryptionForwardSecure keys come from ephemeral keys on both sides.
+	encryptionForwardSecure
+)
+
+// Crypto constants
+const (
+	// aeadKeySize is the size of the AES-128 keys packets are sealed with.
+	aeadKeySize = 16
+	// aeadNoncePrefixSize is the size of the fixed part of the nonce, the
+	// rest is the packet sequence number.
+	aeadNoncePrefixSize = 4
+	// aeadTagSize is the size of the authentication tag of sealed packets.
+	aeadTagSize = 12
+	// nullHashSize is the size of the FNV-1a hash of unencrypted packets.
+	nullHashSize = 12
+)
+
+// packetAEAD seals and opens packet payloads. The public header is
+// authenticated but not encrypted.
+type packetAEAD interface {
+	Seal(payload []byte, sequenceNumber uint64, header []byte) []byte
+	Open(sealed []byte, sequenceNumber uint64, header []byte) ([]byte, error)
+	Overhead() int
+}
+
+// nullAEAD is used for unencrypted packets. It prepends the low 96 bits of
+// an FNV-1a 128 hash of the header and payload.
+type nullAEAD struct{}
+
+func (nullAEAD) hash(header, payload []byte) []byte {
+	h := fnv.New128a()
+	h.Write(header)
+	h.Write(payload)
+	sum := h.Sum(nil)
+	// The hash is sent little endian, low 96 bits only.
+	out := make([]byte, nullHashSize)
+	for i := range out {
+		out[i] = sum[len(sum)-1-i]
+	}
+	return out
+}
+
+func (a nullAEAD) Seal(payload []byte, sequenceNumber uint64, header []byte) []byte {
+	return append(a.hash(header, payload), payload...)
+}
+
+func (a nullAEAD) Open(sealed []byte, sequenceNumber uint64, header []byte) ([]byte, error) {
+	if len(sealed) < nullHashSize {
+		return nil, errDecryptionFailed
+	}
+	payload := sealed[nullHashSize:]
+	hash := a.hash(header, payload)
+	for i := range hash {
+		if hash[i] != sealed[i] {
+			return nil, errDecryptionFailed
+		}
+	}
+	return payload, nil
+}
+
+func (nullAEAD) Overhead() int {
+	return nullHashSize
+}
+
+// aesgAEAD seals packets with AES-128-GCM and a 12 byte tag, the AESG AEAD.
+// The nonce is a fixed prefix followed by the sequence number.
+type aesgAEAD struct {
+	aead        cipher.AEAD
+	noncePrefix []byte
+}
+
+func newAESGAEAD(key, noncePrefix []byte) (*aesgAEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCMWithTagSize(block, aeadTagSize)
+	if err != nil {
+		return nil, err
+	}
+	return &aesgAEAD{aead: aead, noncePrefix: noncePrefix}, nil
+}
+
+func (a *aesgAEAD) nonce(sequenceNumber uint64) []byte {
+	nonce := make([]byte, aeadNoncePrefixSize+8)
+	copy(nonce, a.noncePrefix)
+	binary.LittleEndian.PutUint64(nonce[aeadNoncePrefixSize:], sequenceNumber)
+	return nonce
+}
+
+func (a *aesgAEAD) Seal(payload []byte, sequenceNumber uint64, header []byte) []byte {
+	return a.aead.Seal(nil, a.nonce(sequenceNumber), payload, header)
+}
+
+func (a *aesgAEAD) Open(sealed []byte, sequenceNumber uint64, header []byte) ([]byte, error) {
+	payload, err := a.aead.Open(nil, a.nonce(sequenceNumber), sealed, header)
+	if err != nil {
+		return nil, errDecryptionFailed
+	}
+	return payload, nil
+}
+
+func (a *aesgAEAD) Overhead() int {
+	return aeadTagSize
+}
+
+// Key derivation labels
+const (
+	initialKeyLabel       = "QUIC key expansion"
+	forwardSecureKeyLabel = "QUIC forward secure key expansion"
+)
+
+// deriveKeys expands a shared secret into the AEADs for both directions of
+// a session. The info binds the keys to the connection and the handshake
+// messages they were negotiated with. It returns the client's and the
+// server's sealer.
+func deriveKeys(label string, secret, clientNonce []byte, connID uint64, chlo, scfg []byte) (client, server packetAEAD, err error) {
+	info := make([]byte, 0, len(label)+1+8+len(chlo)+len(scfg))
+	info = append(info, label...)
+	info = append(info, 0)
+	info = binary.LittleEndian.AppendUint64(info, connID)
+	info = append(info, chlo...)
+	info = append(info, scfg...)
+
+	material, err := hkdf.Key(sha256.New, secret, clientNonce, string(info), 2*aeadKeySize+2*aeadNoncePrefixSize)
+	if err != nil {
+		return nil, nil, err
+	}
+	clientKey := material[:aeadKeySize]
+	serverKey := material[aeadKeySize : 2*aeadKeySize]
+	clientIV := material[2*aeadKeySize : 2*aeadKeySize+aeadNoncePrefixSize]
+	serverIV := material[2*aeadKeySize+aeadNoncePrefixSize:]
+	c, err := newAESGAEAD(clientKey, clientIV)
+	if err != nil {
+		return nil, nil, err
+	}
+	s, err := newAESGAEAD(serverKey, serverIV)
+	if err != nil {
+		return nil, nil, err
+	}
+	return c, s, nil
+}
@@ -0,0 +1,498 @@
+package quic
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"errors"
+	"io"
+	"net"
+	"time"
+)
+
+// Handshake constants
+const (
+	// maxRejects is the number of REJs a client accepts before giving up.
+	maxRejects = 3
+	// maxUndecryptablePackets is the number of packets kept until the
+	// handshake provides their keys.
+	maxUndecryptablePackets = 10
+)
+
+// serverCrypto is the handshake state a listener shares between its
+// sessions.
+type serverCrypto struct {
+	stk     *stkSource
+	configs *serverConfigStore
+	strikes *strikeRegister
+	// proofs provides the certificates REJs carry, nil to send none.
+	proofs ProofSource
+}
+
+// newServerCrypto returns the handshake state of a listener proving its
+// identity with proofs, which may be nil.
+func newServerCrypto(now func() time.Time, proofs ProofSource) (*serverCrypto, error) {
+	orbit := make([]byte, orbitSize)
+	if _, err := io.ReadFull(rand.Reader, orbit); err != nil {
+		return nil, err
+	}
+	configs, err := newServerConfigStore(now, orbit)
+	if err != nil {
+		return nil, err
+	}
+	return &serverCrypto{
+		stk:     newSTKSource(now),
+		configs: configs,
+		strikes: newStrikeRegister(now, orbit),
+		proofs:  proofs,
+	}, nil
+}
+
+// cryptoLevelRange is a range of the crypto stream, up to end, that was sent
+// at an encryption level.
+type cryptoLevelRange struct {
+	end   uint64
+	level encryptionLevel
+}
+
+// handleCryptoFrame reassembles the crypto stream and handles each complete
+// handshake message.
+func (s *Session) handleCryptoFrame(frame FrameStream) error {
+	end := frame.Offset + uint64(len(frame.Data))
+	readEnd := s.cryptoReadOffset + uint64(len(s.cryptoBuffer))
+	if frame.Offset > readEnd || end <= readEnd {
+		// Out of order or duplicate data, the peer will retransmit what's missing.
+		return nil
+	}
+	s.cryptoBuffer = append(s.cryptoBuffer, frame.Data[readEnd-frame.Offset:]...)
+	for {
+		msg, n, err := ParseHandshakeMessage(s.cryptoBuffer)
+		if err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		s.cryptoBuffer = s.cryptoBuffer[n:]
+		s.cryptoReadOffset += uint64(n)
+		if err := s.handleHandshakeMessage(msg); err != nil {
+			return err
+		}
+	}
+}
+
+// handleHandshakeMessage handles a handshake message from the peer.
+func (s *Session) handleHandshakeMessage(msg *HandshakeMessage) error {
+	switch msg.Tag {
+	case TagCHLO:
+		return s.handleCHLO(msg)
+	case TagSHLO:
+		return s.handleSHLO(msg)
+	case TagREJ:
+		return s.handleREJ(msg)
+	}
+	return qerr(QUIC_INVALID_CRYPTO_MESSAGE_TYPE, "unexpected handshake message %s", msg.Tag)
+}
+
+// startHandshake starts the client's handshake with serverName. With a server
+// config and source-address token cached from an earlier session, a full
+// CHLO is sent straight away and stream data can follow under the initial
+// keys. It reports whether that 0-RTT handshake is being attempted.
+func (s *Session) startHandshake(serverName string) (bool, error) {
+	s.serverName = serverName
+	if cache := s.config.clientSessionCache(); cache != nil {
+		if state, ok := cache.Get(serverName); ok {
+			if config, err := parseServerConfig(state.ServerConfig); err == nil && time.Now().Before(config.expiry) {
+				s.serverConfig = config
+				s.stk = state.SourceAddressToken
+			}
+			s.serverCerts = state.Certificates
+		}
+	}
+	if err := s.sendCHLO(); err != nil {
+		return false, err
+	}
+	return s.sealLevel == encryptionInitial && !s.config.disable0RTT(), nil
+}
+
+// sendCHLO sends a CHLO. Without a server config it is inchoate, only asking
+// for a REJ with the server config and a source-address token. Otherwise it
+// is a full CHLO and the initial keys are derived from it, carrying our
+// channel ID if we have one.
+func (s *Session) sendCHLO() error {
+	chlo := NewHandshakeMessage(TagCHLO)
+	chlo.SetUint32(TagICSL, uint32(s.config.idleTimeout()/time.Second))
+	chlo.SetUint32(TagMSPC, s.config.maxIncomingStreams())
+	if s.serverName != "" && net.ParseIP(s.serverName) == nil {
+		chlo.Values[TagSNI] = []byte(s.serverName)
+	}
+	if s.stk != nil {
+		chlo.Values[TagSTK] = s.stk
+	}
+	if len(commonCertSets) > 0 {
+		chlo.Values[TagCCS] = commonCertSetHashes()
+	}
+	if len(s.serverCerts) > 0 {
+		chlo.Values[TagCCRT] = certHashes(s.serverCerts)
+	}
+	if s.serverConfig == nil {
+		s.sendHandshakeMessage(chlo)
+		return nil
+	}
+
+	if s.clientKey == nil {
+		key, err := ecdh.X25519().GenerateKey(rand.Reader)
+		if err != nil {
+			return err
+		}
+		s.clientKey = key
+	}
+	nonce, err := newClientNonce(time.Now(), s.serverConfig.orbit)
+	if err != nil {
+		return err
+	}
+	s.clientNonce = nonce
+	chlo.Values[TagSCID] = s.serverConfig.id
+	chlo.SetUint32(TagKEXS, uint32(TagC255))
+	chlo.SetUint32(TagAEAD, uint32(TagAESG))
+	chlo.Values[TagPUBS] = s.clientKey.PublicKey().Bytes()
+	chlo.Values[TagNONC] = nonce
+
+	secret, err := s.clientKey.ECDH(s.serverConfig.publicKey)
+	if err != nil {
+		return qerr(QUIC_CRYPTO_INTERNAL_ERROR, "key exchange failed: %v", err)
+	}
+	if key := s.config.channelIDKey(); key != nil {
+		if err := s.addChannelID(chlo, key, secret); err != nil {
+			return err
+		}
+	}
+	s.clientHello = chlo.ToBuf()
+	s.sendHandshakeMessage(chlo)
+	clientAEAD, serverAEAD, err := deriveKeys(initialKeyLabel, secret, nonce, s.ConnID, s.clientHello, s.serverConfig.serialized)
+	if err != nil {
+		return err
+	}
+	s.installKeys(encryptionInitial, clientAEAD, serverAEAD)
+	return nil
+}
+
+// handleREJ retries the handshake with the server config and source-address
+// token the server rejected our CHLO with, and caches them for later
+// sessions. The server config must be signed by a certificate valid for the
+// server name. Anything sent under the rejected initial keys is retransmitted
+// under the new ones.
+func (s *Session) handleREJ(rej *HandshakeMessage) error {
+	if !s.isClient {
+		return qerr(QUIC_INVALID_CRYPTO_MESSAGE_TYPE, "client sent REJ")
+	}
+	s.rejects++
+	if s.rejects > maxRejects {
+		return qerr(QUIC_CRYPTO_TOO_MANY_REJECTS, "server rejected %d CHLOs", s.rejects)
+	}
+	stk, ok := rej.Values[TagSTK]
+	if !ok {
+		return qerr(QUIC_CRYPTO_MESSAGE_PARAMETER_NOT_FOUND, "REJ without STK")
+	}
+	s.stk = stk
+	if scfg, ok := rej.Values[TagSCFG]; ok {
+		config, err := parseServerConfig(scfg)
+		if err != nil {
+			return err
+		}
+		if !time.Now().Before(config.expiry) {
+			return qerr(QUIC_CRYPTO_SERVER_CONFIG_EXPIRED, "server sent an expired server config")
+		}
+		crt, ok := rej.Values[TagCRT]
+		if !ok {
+			return qerr(QUIC_PROOF_INVALID, "REJ without certificate chain")
+		}
+		chain, err := decompressCertChain(crt, s.serverCerts)
+		if err != nil {
+			return err
+		}
+		if err := verifyProof(chain, scfg, rej.Values[TagPROF], s.serverName, s.config.tlsConfig()); err != nil {
+			return err
+		}
+		s.serverConfig = config
+		s.serverCerts = chain
+	}
+	if s.serverConfig == nil {
+		return qerr(QUIC_CRYPTO_MESSAGE_PARAMETER_NOT_FOUND, "REJ without SCFG")
+	}
+	if cache := s.config.clientSessionCache(); cache != nil {
+		cache.Put(s.serverName, &ClientSessionState{
+			ServerConfig:       s.serverConfig.serialized,
+			SourceAddressToken: s.stk,
+			Certificates:       s.serverCerts,
+		})
+	}
+	if s.sealLevel == encryptionInitial {
+		s.rejectEarlyData()
+	}
+	return s.sendCHLO()
+}
+
+// rejectEarlyData drops the initial keys after the server rejected our full
+// CHLO, and queues the data sent under them to be sent again.
+func (s *Session) rejectEarlyData() {
+	s.sealers[encryptionInitial] = nil
+	s.openers[encryptionInitial] = nil
+	s.sealLevel = encryptionUnencrypted
+	for _, sent := range s.outstandingPackets(false) {
+		if sent.level == encryptionInitial {
+			s.retransmit(sent)
+		}
+	}
+}
+
+// sendREJ rejects a CHLO and hands the client our server config, a new
+// source-address token and the certificate chain for the server name it
+// asked for, compressed against the certificates the CHLO says the client
+// has, with a signature of the server config.
+func (s *Session) sendREJ(chlo *HandshakeMessage) error {
+	stk, err := s.server.stk.NewToken(s.addr)
+	if err != nil {
+		return err
+	}
+	config, err := s.server.configs.Primary()
+	if err != nil {
+		return err
+	}
+	rej := NewHandshakeMessage(TagREJ)
+	rej.Values[TagSTK] = stk
+	rej.Values[TagSCFG] = config.serialized
+	if proofs := s.server.proofs; proofs != nil {
+		sni := string(chlo.Values[TagSNI])
+		chain, err := proofs.GetCertChain(sni)
+		if err != nil {
+			return err
+		}
+		crt, err := compressCertChain(chain, chlo.Values[TagCCS], chlo.Values[TagCCRT])
+		if err != nil {
+			return err
+		}
+		proof, err := proofs.Sign(sni, config.serialized)
+		if err != nil {
+			return err
+		}
+		rej.Values[TagCRT] = crt
+		rej.Values[TagPROF] = proof
+	}
+	s.sendHandshakeMessage(rej)
+	s.rejectSent = true
+	return nil
+}
+
+// handleCHLO negotiates the connection parameters requested by the client and
+// replies with a SHLO. A CHLO is rejected if it is inchoate, is for a server
+// config we don't have or that expired, the client hasn't proven it owns its
+// address with a source-address token, or its nonce is stale or was seen
+// before, which stops 0-RTT data from being replayed. With 0-RTT disabled the
+// first CHLO of every session is rejected, so nothing the client sent before
+// hearing from us is used.
+func (s *Session) handleCHLO(chlo *HandshakeMessage) error {
+	if s.isClient {
+		return qerr(QUIC_INVALID_CRYPTO_MESSAGE_TYPE, "server sent CHLO")
+	}
+	if s.handshakeComplete {
+		return qerr(QUIC_CRYPTO_MESSAGE_AFTER_HANDSHAKE_COMPLETE, "CHLO after the handshake completed")
+	}
+	config, err := s.server.configs.Get(chlo.Values[TagSCID])
+	if errors.Is(err, QUIC_CRYPTO_SERVER_CONFIG_EXPIRED) {
+		// The client cached a config that has since expired, the REJ hands
+		// it our current one.
+		config = nil
+	} else if err != nil {
+		return err
+	}
+	stk, ok := chlo.Values[TagSTK]
+	if !ok || s.server.stk.ValidateToken(stk, s.addr) != nil || config == nil ||
+		s.config.disable0RTT() && !s.rejectSent {
+		return s.sendREJ(chlo)
+	}
+	s.pathValidated = true
+
+	if !chlo.hasTag(TagKEXS, TagC255) || !chlo.hasTag(TagAEAD, TagAESG) {
+		return qerr(QUIC_CRYPTO_NO_SUPPORT, "no supported key exchange or AEAD in CHLO")
+	}
+	clientPub, err := ecdh.X25519().NewPublicKey(chlo.Values[TagPUBS])
+	if err != nil {
+		return qerr(QUIC_INVALID_CRYPTO_MESSAGE_PARAMETER, "invalid client public key")
+	}
+	nonce := chlo.Values[TagNONC]
+	if len(nonce) != clientNonceSize {
+		return qerr(QUIC_INVALID_CRYPTO_MESSAGE_PARAMETER, "invalid client nonce")
+	}
+	if s.server.strikes.Insert(nonce) != nil {
+		// A replayed CHLO, or one we can't tell apart from a replay. The
+		// client retries with a fresh nonce.
+		return s.sendREJ(chlo)
+	}
+	chloBuf := chlo.ToBuf()
+	secret, err := config.key.ECDH(clientPub)
+	if err != nil {
+		return qerr(QUIC_INVALID_CRYPTO_MESSAGE_PARAMETER, "key exchange failed: %v", err)
+	}
+	if _, ok := chlo.Values[TagCETV]; ok {
+		if s.channelID, err = verifyChannelID(chlo, secret, s.ConnID, config.serialized); err != nil {
+			return err
+		}
+	}
+	clientAEAD, serverAEAD, err := deriveKeys(initialKeyLabel, secret, nonce, s.ConnID, chloBuf, config.serialized)
+	if err != nil {
+		return err
+	}
+	s.installKeys(encryptionInitial, serverAEAD, clientAEAD)
+
+	icsl, ok := chlo.GetUint32(TagICSL)
+	s.idleTimeout = negotiateIdleTimeout(icsl, ok, s.config.idleTimeout())
+
+	maxStreams := s.config.maxIncomingStreams()
+	if mspc, ok := chlo.GetUint32(TagMSPC); ok && mspc > 0 && mspc < maxStreams {
+		maxStreams = mspc
+	}
+	s.setMaxStreams(maxStreams)
+
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return err
+	}
+	shlo := NewHandshakeMessage(TagSHLO)
+	shlo.SetUint32(TagICSL, uint32(s.idleTimeout/time.Second))
+	shlo.SetUint32(TagMSPC, maxStreams)
+	shlo.Values[TagPUBS] = ephemeral.PublicKey().Bytes()
+	// The SHLO goes out under the initial keys, which authenticates our
+	// ephemeral key.
+	s.sendHandshakeMessage(shlo)
+
+	fsSecret, err := ephemeral.ECDH(clientPub)
+	if err != nil {
+		return qerr(QUIC_INVALID_CRYPTO_MESSAGE_PARAMETER, "key exchange failed: %v", err)
+	}
+	clientAEAD, serverAEAD, err = deriveKeys(forwardSecureKeyLabel, fsSecret, nonce, s.ConnID, chloBuf, config.serialized)
+	if err != nil {
+		return err
+	}
+	s.installKeys(encryptionForwardSecure, serverAEAD, clientAEAD)
+	s.setHandshakeComplete()
+	return nil
+}
+
+// handleSHLO derives the forward-secure keys from the server's ephemeral key
+// and applies the connection parameters negotiated by the server.
+func (s *Session) handleSHLO(shlo *HandshakeMessage) error {
+	if !s.isClient {
+		return qerr(QUIC_INVALID_CRYPTO_MESSAGE_TYPE, "client sent SHLO")
+	}
+	if s.receivedLevel != encryptionInitial {
+		return qerr(QUIC_CRYPTO_ENCRYPTION_LEVEL_INCORRECT, "SHLO wasn't sealed with the initial keys")
+	}
+	serverPub, err := ecdh.X25519().NewPublicKey(shlo.Values[TagPUBS])
+	if err != nil {
+		return qerr(QUIC_INVALID_CRYPTO_MESSAGE_PARAMETER, "invalid server public key")
+	}
+	secret, err := s.clientKey.ECDH(serverPub)
+	if err != nil {
+		return qerr(QUIC_INVALID_CRYPTO_MESSAGE_PARAMETER, "key exchange failed: %v", err)
+	}
+	clientAEAD, serverAEAD, err := deriveKeys(forwardSecureKeyLabel, secret, s.clientNonce, s.ConnID, s.clientHello, s.serverConfig.serialized)
+	if err != nil {
+		return err
+	}
+	s.installKeys(encryptionForwardSecure, clientAEAD, serverAEAD)
+
+	// The server may only lower the idle timeout we asked for.
+	icsl, ok := shlo.GetUint32(TagICSL)
+	s.idleTimeout = negotiateIdleTimeout(icsl, ok, s.config.idleTimeout())
+	if mspc, ok := shlo.GetUint32(TagMSPC); ok && mspc > 0 {
+		s.setMaxStreams(mspc)
+	}
+	s.setHandshakeComplete()
+	return nil
+}
+
+// setHandshakeComplete marks the handshake as complete.
+func (s *Session) setHandshakeComplete() {
+	s.handshakeComplete = true
+	close(s.handshakeDone)
+}
+
+// installKeys starts using the keys of a new encryption level.
+func (s *Session) installKeys(level encryptionLevel, sealer, opener packetAEAD) {
+	s.sealers[level] = sealer
+	s.openers[level] = opener
+	if level > s.sealLevel {
+		s.sealLevel = level
+	}
+	s.keysChanged = true
+}
+
+// openPacket decrypts a packet with the highest level keys that work, and
+// parses its frames. Unencrypted packets may only carry handshake data.
+func (s *Session) openPacket(p receivedPacket) (encryptionLevel, error) {
+	header := p.data[:p.headerLen]
+	sealed := p.data[p.headerLen:]
+	for level := encryptionForwardSecure; level >= encryptionUnencrypted; level-- {
+		opener := s.openers[level]
+		if opener == nil {
+			continue
+		}
+		payload, err := opener.Open(sealed, p.packet.SequenceNumber, header)
+		if err != nil {
+			continue
+		}
+		if err := p.packet.ParsePayload(payload); err != nil {
+			return level, err
+		}
+		if level == encryptionUnencrypted {
+			return level, s.checkUnencryptedFrames(p.packet.Frames)
+		}
+		return level, nil
+	}
+	return 0, errDecryptionFailed
+}
+
+// checkUnencryptedFrames checks that an unencrypted packet only carries
+// frames the handshake needs. Anyone who sees the connection ID can forge
+// unencrypted packets, so they may not touch streams or the connection, and
+// are ignored altogether once forward-secure keys are set.
+func (s *Session) checkUnencryptedFrames(frames []Frame) error {
+	if s.openers[encryptionForwardSecure] != nil {
+		return errUnencryptedPacket
+	}
+	for _, f := range frames {
+		switch frame := f.(type) {
+		case FrameStream:
+			if frame.StreamID != cryptoStreamID {
+				return qerr(QUIC_UNENCRYPTED_STREAM_DATA, "unencrypted data on stream %d", frame.StreamID)
+			}
+		case FrameAck, FrameStopWaiting, *FramePadding, *FramePing:
+		default:
+			return errUnencryptedPacket
+		}
+	}
+	return nil
+}
+
+// cryptoLevel returns the encryption level crypto stream data at offset was
+// first sent at.
+func (s *Session) cryptoLevel(offset uint64) encryptionLevel {
+	for _, r := range s.cryptoLevels {
+		if offset < r.end {
+			return r.level
+		}
+	}
+	return s.sealLevel
+}
+
+// sendHandshakeMessage queues a handshake message on the crypto stream.
+func (s *Session) sendHandshakeMessage(msg *HandshakeMessage) {
+	data := msg.ToBuf()
+	s.queueFrame(FrameStream{
+		StreamID: cryptoStreamID,
+		Offset:   s.cryptoWriteOffset,
+		Data:     string(data),
+	})
+	s.cryptoWriteOffset += uint64(len(data))
+	s.cryptoLevels = append(s.cryptoLevels, cryptoLevelRange{end: s.cryptoWriteOffset, level: s.sealLevel})
+}
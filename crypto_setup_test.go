@@ -0,0 +1,224 @@
+package quic
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestCheckUnencryptedFrames(t *testing.T) {
+	tests := []struct {
+		name  string
+		frame Frame
+		ok    bool
+	}{
+		{"crypto stream", FrameStream{StreamID: cryptoStreamID, Data: "CHLO"}, true},
+		{"ack", FrameAck{LargestObserved: 1}, true},
+		{"stop_waiting", FrameStopWaiting{}, true},
+		{"padding", &FramePadding{Size: 10}, true},
+		{"ping", &FramePing{}, true},
+		{"data stream", FrameStream{StreamID: 5, Data: "GET /"}, false},
+		{"connection_close", FrameConnectionClose{ErrorCode: QUIC_NO_ERROR}, false},
+		{"rst_stream", FrameResetStream{StreamID: 5}, false},
+		{"goaway", FrameGoAway{}, false},
+		{"window_update", FrameWindowUpdate{StreamID: 0, ByteOffset: 1}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Session{}
+			err := s.checkUnencryptedFrames([]Frame{tt.frame})
+			if (err == nil) != tt.ok {
+				t.Fatalf("checkUnencryptedFrames() = %v", err)
+			}
+
+			// Nothing is accepted once the handshake set forward-secure
+			// keys.
+			s.openers[encryptionForwardSecure] = nullAEAD{}
+			if err := s.checkUnencryptedFrames([]Frame{tt.frame}); !errors.Is(err, errUnencryptedPacket) {
+				t.Fatalf("after handshake checkUnencryptedFrames() = %v", err)
+			}
+		})
+	}
+}
+
+func TestHandleCHLOExpiredServerConfig(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1500000000, 0)}
+	server, err := newServerCrypto(clock.Now, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := server.configs.Primary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 4433}
+	chlo := func() *HandshakeMessage {
+		stk, err := server.stk.NewToken(addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		chlo := NewHandshakeMessage(TagCHLO)
+		chlo.Values[TagSCID] = config.id
+		chlo.Values[TagSTK] = stk
+		return chlo
+	}
+	newTestSession := func() *Session {
+		s := newSession(nil, addr, 1, &Config{}, server, nil)
+		s.rejectSent = true
+		return s
+	}
+
+	// With a valid config, the CHLO is only turned down for lacking a key
+	// exchange.
+	s := newTestSession()
+	if err := s.handleCHLO(chlo()); !errors.Is(err, QUIC_CRYPTO_NO_SUPPORT) {
+		t.Fatalf("handleCHLO() = %v, want QUIC_CRYPTO_NO_SUPPORT", err)
+	}
+
+	clock.now = config.expiry
+	s = newTestSession()
+	if err := s.handleCHLO(chlo()); err != nil {
+		t.Fatalf("handleCHLO() with an expired config = %v", err)
+	}
+	if len(s.pendingFrames) != 1 {
+		t.Fatalf("queued %d frames, want a REJ", len(s.pendingFrames))
+	}
+	rej, _, err := ParseHandshakeMessage([]byte(s.pendingFrames[0].(FrameStream).Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rej.Tag != TagREJ {
+		t.Fatalf("sent %s, want REJ", rej.Tag)
+	}
+	primary, err := server.configs.Primary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(rej.Values[TagSCFG], primary.serialized) {
+		t.Errorf("REJ doesn't carry the current server config")
+	}
+}
+
+// fullCHLO returns a CHLO the server completes the handshake for.
+func fullCHLO(t *testing.T, server *serverCrypto, addr net.Addr, now time.Time) *HandshakeMessage {
+	config, err := server.configs.Primary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stk, err := server.stk.NewToken(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce, err := newClientNonce(now, config.orbit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chlo := NewHandshakeMessage(TagCHLO)
+	chlo.Values[TagSCID] = config.id
+	chlo.Values[TagSTK] = stk
+	chlo.SetUint32(TagKEXS, uint32(TagC255))
+	chlo.SetUint32(TagAEAD, uint32(TagAESG))
+	chlo.Values[TagPUBS] = key.PublicKey().Bytes()
+	chlo.Values[TagNONC] = nonce
+	return chlo
+}
+
+// lastHandshakeMessage returns the last handshake message the session
+// queued, or nil if there is none.
+func lastHandshakeMessage(s *Session) *HandshakeMessage {
+	var data string
+	for _, f := range s.pendingFrames {
+		if frame, ok := f.(FrameStream); ok && frame.StreamID == cryptoStreamID {
+			data = frame.Data
+		}
+	}
+	msg, _, err := ParseHandshakeMessage([]byte(data))
+	if err != nil {
+		return nil
+	}
+	return msg
+}
+
+// sentHandshakeMessage returns the tag of the last handshake message the
+// session queued.
+func sentHandshakeMessage(s *Session) Tag {
+	if msg := lastHandshakeMessage(s); msg != nil {
+		return msg.Tag
+	}
+	return 0
+}
+
+func TestHandleCHLOReplayed(t *testing.T) {
+	server, err := newServerCrypto(time.Now, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chlo := fullCHLO(t, server, &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 4433}, time.Now())
+
+	for i, want := range []Tag{TagSHLO, TagREJ} {
+		s, _ := newTestServerSession(t, server)
+		var got Tag
+		doSync(s, func() {
+			err = s.handleCHLO(chlo)
+			got = sentHandshakeMessage(s)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("CHLO %d answered with %s, want %s", i+1, got, want)
+		}
+	}
+}
+
+func TestUnencryptedFramesAfterHandshake(t *testing.T) {
+	s, _ := newTestSession(t)
+	addr := s.RemoteAddr()
+	chlo := fullCHLO(t, s.server, addr, time.Now())
+	// An ACK of a packet we never sent, which would close the session if
+	// it were handled.
+	p := &Packet{
+		PublicFlags:    ConnID8Bytes | SequenceNumber6Bytes,
+		ConnID:         s.ConnID,
+		SequenceNumber: 1,
+		Frames:         []Frame{FrameAck{LargestObserved: 1000, ReceivedEntropy: 0xff}},
+	}
+	payload, err := p.PayloadToBuf()
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := p.PublicHeaderToBuf()
+	data := append(header, nullAEAD{}.Seal(payload, p.SequenceNumber, header)...)
+	parsed, headerLen, err := ParsePublicHeader(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var handshakeErr error
+	var forwardSecure, handled bool
+	doSync(s, func() {
+		if handshakeErr = s.handleCHLO(chlo); handshakeErr != nil {
+			return
+		}
+		forwardSecure = s.openers[encryptionForwardSecure] != nil
+		err = s.handleReceivedPacket(receivedPacket{packet: parsed, addr: addr, size: len(data), data: data, headerLen: headerLen})
+		handled = s.largestObservedByPeer != 0 || s.receivedEntropy.largestObserved != 0
+	})
+	if handshakeErr != nil || !forwardSecure {
+		t.Fatalf("handshake failed: %v", handshakeErr)
+	}
+	if err != nil {
+		t.Errorf("handleReceivedPacket() = %v, want the packet dropped", err)
+	}
+	if handled {
+		t.Errorf("unencrypted ACK was handled")
+	}
+}
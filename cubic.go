@@ -0,0 +1,281 @@
+package quic
+
+import (
+	"math"
+	"time"
+)
+
+// Cubic constants
+const (
+	// cubeScale and cubeCongestionWindowScale are the fixed point scaling of
+	// the cubic function. The time unit is 1/1024 of a second.
+	cubeScale                 = 40
+	cubeCongestionWindowScale = 410
+	cubeFactor                = (uint64(1) << cubeScale) / cubeCongestionWindowScale
+	// cubicBeta is the multiplicative decrease on loss.
+	cubicBeta = 0.7
+	// cubicBetaLastMax is the additional backoff for fast convergence.
+	cubicBetaLastMax = 0.85
+	// numConnections is the number of TCP connections Cubic emulates.
+	numConnections = 2
+	// cubicNConnectionBeta and cubicNConnectionBetaLastMax are cubicBeta and
+	// cubicBetaLastMax for numConnections flows: only one of them backs off
+	// on a loss.
+	cubicNConnectionBeta        = (numConnections - 1 + cubicBeta) / numConnections
+	cubicNConnectionBetaLastMax = (numConnections - 1 + cubicBetaLastMax) / numConnections
+	// maxBurstPackets is how many packets may be sent back to back before the
+	// sender counts as congestion window limited.
+	maxBurstPackets = 3
+)
+
+// Hybrid slow start constants
+const (
+	hybridStartLowWindow         = 16
+	hybridStartMinSamples        = 8
+	hybridStartDelayFactorExp    = 3
+	hybridStartDelayMinThreshold = 4 * time.Millisecond
+	hybridStartDelayMaxThreshold = 16 * time.Millisecond
+)
+
+// cubic computes the congestion window growth in congestion avoidance.
+type cubic struct {
+	epoch             time.Time
+	lastMaxCwnd       uint64
+	ackedPacketsCount uint64
+	estimatedTCPCwnd  uint64
+	originPointCwnd   uint64
+	timeToOriginPoint uint64
+}
+
+// reset forgets the current epoch, e.g. after a retransmission timeout.
+func (c *cubic) reset() {
+	*c = cubic{}
+}
+
+// cwndAfterLoss returns the new congestion window in packets after a loss.
+func (c *cubic) cwndAfterLoss(cwnd uint64) uint64 {
+	if cwnd < c.lastMaxCwnd {
+		// We never reached the old max, so assume we are competing with
+		// another flow and back off further.
+		c.lastMaxCwnd = uint64(cubicNConnectionBetaLastMax * float64(cwnd))
+	} else {
+		c.lastMaxCwnd = cwnd
+	}
+	c.epoch = time.Time{}
+	return uint64(float64(cwnd) * cubicNConnectionBeta)
+}
+
+// cwndAfterAck returns the new congestion window in packets after an ACK.
+func (c *cubic) cwndAfterAck(cwnd uint64, minRTT time.Duration, now time.Time) uint64 {
+	c.ackedPacketsCount++
+	if c.epoch.IsZero() {
+		c.epoch = now
+		c.ackedPacketsCount = 1
+		c.estimatedTCPCwnd = cwnd
+		if c.lastMaxCwnd <= cwnd {
+			c.timeToOriginPoint = 0
+			c.originPointCwnd = cwnd
+		} else {
+			c.timeToOriginPoint = uint64(math.Cbrt(float64(cubeFactor * (c.lastMaxCwnd - cwnd))))
+			c.originPointCwnd = c.lastMaxCwnd
+		}
+	}
+	elapsed := uint64((now.Add(minRTT).Sub(c.epoch) / time.Microsecond) << 10 / 1000000)
+	offset := int64(c.timeToOriginPoint) - int64(elapsed)
+	deltaCwnd := (cubeCongestionWindowScale * offset * offset * offset) >> cubeScale
+	target := uint64(0)
+	if deltaCwnd < int64(c.originPointCwnd) {
+		target = uint64(int64(c.originPointCwnd) - deltaCwnd)
+	}
+
+	// Grow at least as fast as Reno would, with numConnections flows.
+	for {
+		requiredAckCount := c.estimatedTCPCwnd / numConnections
+		if requiredAckCount == 0 || c.ackedPacketsCount < requiredAckCount {
+			break
+		}
+		c.ackedPacketsCount -= requiredAckCount
+		c.estimatedTCPCwnd++
+	}
+	if target < c.estimatedTCPCwnd {
+		target = c.estimatedTCPCwnd
+	}
+	return target
+}
+
+// hybridSlowStart exits slow start once the RTT starts increasing, before
+// the queue overflows and packets are lost.
+type hybridSlowStart struct {
+	started           bool
+	found             bool
+	lastSent          uint64
+	endSequenceNumber uint64
+	currentMinRTT     time.Duration
+	rttSampleCount    int
+}
+
+// onPacketSent records the most recently sent packet.
+func (h *hybridSlowStart) onPacketSent(sequenceNumber uint64) {
+	h.lastSent = sequenceNumber
+}
+
+// onPacketAcked ends the current round once its last packet is acknowledged.
+func (h *hybridSlowStart) onPacketAcked(sequenceNumber uint64) {
+	if h.started && sequenceNumber >= h.endSequenceNumber {
+		h.started = false
+	}
+}
+
+// restart starts looking for a new exit point.
+func (h *hybridSlowStart) restart() {
+	h.started = false
+	h.found = false
+}
+
+// shouldExitSlowStart reports whether the RTT increase in the current round
+// indicates that the bottleneck queue is filling up.
+func (h *hybridSlowStart) shouldExitSlowStart(latestRTT, minRTT time.Duration, cwnd uint64) bool {
+	if !h.started {
+		h.started = true
+		h.endSequenceNumber = h.lastSent
+		h.currentMinRTT = 0
+		h.rttSampleCount = 0
+	}
+	if h.found {
+		return true
+	}
+	h.rttSampleCount++
+	if h.rttSampleCount <= hybridStartMinSamples {
+		if h.currentMinRTT == 0 || h.currentMinRTT > latestRTT {
+			h.currentMinRTT = latestRTT
+		}
+	}
+	if h.rttSampleCount == hybridStartMinSamples {
+		threshold := minRTT >> hybridStartDelayFactorExp
+		if threshold < hybridStartDelayMinThreshold {
+			threshold = hybridStartDelayMinThreshold
+		}
+		if threshold > hybridStartDelayMaxThreshold {
+			threshold = hybridStartDelayMaxThreshold
+		}
+		if h.currentMinRTT > minRTT+threshold {
+			h.found = true
+		}
+	}
+	return cwnd >= hybridStartLowWindow && h.found
+}
+
+// cubicSender implements CongestionControl using TCP Cubic with slow start
+// and hybrid slow start.
+type cubicSender struct {
+	rtt         *RTTStats
+	cubic       cubic
+	hybridStart hybridSlowStart
+
+	// Windows are counted in packets of maxSegmentSize.
+	cwnd     uint64
+	ssthresh uint64
+
+	largestSent              uint64
+	largestAcked             uint64
+	largestSentAtLastCutback uint64
+}
+
+// NewCubic returns the default Cubic congestion controller.
+func NewCubic(rtt *RTTStats) CongestionControl {
+	return &cubicSender{
+		rtt:      rtt,
+		cwnd:     initialCongestionWindow,
+		ssthresh: maxCongestionWindow,
+	}
+}
+
+func (c *cubicSender) inSlowStart() bool {
+	return c.cwnd < c.ssthresh
+}
+
+func (c *cubicSender) inRecovery(sequenceNumber uint64) bool {
+	return sequenceNumber <= c.largestSentAtLastCutback
+}
+
+func (c *cubicSender) congestionWindow() uint64 {
+	return c.cwnd * maxSegmentSize
+}
+
+// isCwndLimited reports whether the sender is using enough of the window for
+// an ACK to justify growing it.
+func (c *cubicSender) isCwndLimited(bytesInFlight uint64) bool {
+	cwnd := c.congestionWindow()
+	if bytesInFlight >= cwnd {
+		return true
+	}
+	available := cwnd - bytesInFlight
+	return (c.inSlowStart() && bytesInFlight > cwnd/2) || available <= maxBurstPackets*maxSegmentSize
+}
+
+func (c *cubicSender) OnPacketSent(sentTime time.Time, bytesInFlight, sequenceNumber, bytes uint64, retransmittable bool) {
+	if !retransmittable {
+		return
+	}
+	c.largestSent = sequenceNumber
+	c.hybridStart.onPacketSent(sequenceNumber)
+}
+
+func (c *cubicSender) OnPacketAcked(ackTime time.Time, sequenceNumber, ackedBytes, bytesInFlight uint64) {
+	newLargest := sequenceNumber > c.largestAcked
+	if newLargest {
+		c.largestAcked = sequenceNumber
+	}
+	c.hybridStart.onPacketAcked(sequenceNumber)
+	if c.inRecovery(sequenceNumber) {
+		// No window growth during recovery.
+		return
+	}
+	if newLargest && c.inSlowStart() &&
+		c.hybridStart.shouldExitSlowStart(c.rtt.LatestRTT(), c.rtt.MinRTT(), c.cwnd) {
+		c.ssthresh = c.cwnd
+	}
+	if !c.isCwndLimited(bytesInFlight) || c.cwnd >= maxCongestionWindow {
+		return
+	}
+	if c.inSlowStart() {
+		c.cwnd++
+		return
+	}
+	c.cwnd = c.cubic.cwndAfterAck(c.cwnd, c.rtt.MinRTT(), ackTime)
+	if c.cwnd > maxCongestionWindow {
+		c.cwnd = maxCongestionWindow
+	}
+}
+
+func (c *cubicSender) OnPacketLost(sequenceNumber, lostBytes, bytesInFlight uint64) {
+	if c.inRecovery(sequenceNumber) {
+		// Only back off once per window.
+		return
+	}
+	c.cwnd = c.cubic.cwndAfterLoss(c.cwnd)
+	if c.cwnd < minCongestionWindow {
+		c.cwnd = minCongestionWindow
+	}
+	c.ssthresh = c.cwnd
+	c.largestSentAtLastCutback = c.largestSent
+}
+
+func (c *cubicSender) OnRetransmissionTimeout(packetsRetransmitted bool) {
+	c.largestSentAtLastCutback = 0
+	if !packetsRetransmitted {
+		return
+	}
+	c.hybridStart.restart()
+	c.cubic.reset()
+	c.ssthresh = c.cwnd / 2
+	c.cwnd = minCongestionWindow
+}
+
+func (c *cubicSender) CanSend(bytesInFlight uint64) bool {
+	return bytesInFlight < c.congestionWindow()
+}
+
+func (c *cubicSender) BandwidthEstimate() Bandwidth {
+	return BandwidthFromDelta(c.congestionWindow(), c.rtt.SmoothedRTT())
+}
@@ -0,0 +1,135 @@
+package quic
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCubicCwndAfterLoss(t *testing.T) {
+	c := &cubic{}
+	// Only one of the numConnections emulated flows backs off.
+	if got := c.cwndAfterLoss(100); got != 85 {
+		t.Errorf("cwndAfterLoss(100) = %d, want 85", got)
+	}
+	if c.lastMaxCwnd != 100 {
+		t.Errorf("lastMaxCwnd = %d, want 100", c.lastMaxCwnd)
+	}
+
+	// A loss before reaching the last max backs off further.
+	if got := c.cwndAfterLoss(80); got != 68 {
+		t.Errorf("cwndAfterLoss(80) = %d, want 68", got)
+	}
+	if c.lastMaxCwnd != 74 {
+		t.Errorf("lastMaxCwnd = %d, want 74", c.lastMaxCwnd)
+	}
+}
+
+// newTestCubic returns a Cubic sender with a min RTT of 100ms.
+func newTestCubic(now time.Time) (*cubicSender, *RTTStats) {
+	rtt := &RTTStats{}
+	rtt.UpdateRTT(100*time.Millisecond, 0, now)
+	return NewCubic(rtt).(*cubicSender), rtt
+}
+
+// ackRound sends and acks a window of packets starting at sequenceNumber,
+// keeping the window full. It returns the next sequence number.
+func ackRound(c *cubicSender, sequenceNumber uint64, now time.Time) uint64 {
+	cwnd := c.cwnd
+	for i := uint64(0); i < cwnd; i++ {
+		c.OnPacketSent(now, i*maxSegmentSize, sequenceNumber+i, maxSegmentSize, true)
+	}
+	for i := uint64(0); i < cwnd; i++ {
+		c.OnPacketAcked(now, sequenceNumber+i, maxSegmentSize, c.congestionWindow())
+	}
+	return sequenceNumber + cwnd
+}
+
+func TestCubicSlowStart(t *testing.T) {
+	now := time.Now()
+	c, _ := newTestCubic(now)
+	seq := uint64(1)
+	for want := uint64(2 * initialCongestionWindow); want <= 160; want *= 2 {
+		seq = ackRound(c, seq, now)
+		if c.cwnd != want || !c.inSlowStart() {
+			t.Fatalf("cwnd = %d (slow start %t), want %d in slow start", c.cwnd, c.inSlowStart(), want)
+		}
+	}
+
+	// Without enough data in flight the window doesn't grow.
+	c.OnPacketSent(now, 0, seq, maxSegmentSize, true)
+	c.OnPacketAcked(now, seq, maxSegmentSize, 0)
+	if c.cwnd != 160 {
+		t.Errorf("cwnd = %d after an ACK while application limited, want 160", c.cwnd)
+	}
+}
+
+func TestCubicHybridSlowStartExit(t *testing.T) {
+	tests := []struct {
+		name      string
+		latestRTT time.Duration
+		exit      bool
+	}{
+		// The threshold is an eighth of the min RTT, 12.5ms.
+		{"queue filling up", 120 * time.Millisecond, true},
+		{"rtt within the threshold", 110 * time.Millisecond, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now := time.Now()
+			c, rtt := newTestCubic(now)
+			rtt.UpdateRTT(tt.latestRTT, 0, now)
+			seq := uint64(1)
+			for i := 0; i < 4; i++ {
+				seq = ackRound(c, seq, now)
+			}
+			if c.inSlowStart() != !tt.exit {
+				t.Fatalf("in slow start = %t, want %t", c.inSlowStart(), !tt.exit)
+			}
+			// The exit waits for the window to reach hybridStartLowWindow.
+			if tt.exit && (c.ssthresh < hybridStartLowWindow || c.ssthresh > 2*hybridStartLowWindow) {
+				t.Errorf("slow start exited at %d packets", c.ssthresh)
+			}
+		})
+	}
+}
+
+func TestCubicEmulatedConnectionsBeta(t *testing.T) {
+	now := time.Now()
+	c, _ := newTestCubic(now)
+	c.cwnd, c.ssthresh = 100, 100
+	c.largestSent = 200
+
+	// With numConnections flows emulated, only one of them halves its share
+	// of the window: (numConnections-1+cubicBeta)/numConnections.
+	c.OnPacketLost(150, maxSegmentSize, 0)
+	if c.cwnd != 85 || c.ssthresh != 85 {
+		t.Fatalf("cwnd, ssthresh = %d, %d after a loss, want 85, 85", c.cwnd, c.ssthresh)
+	}
+	// Losses of packets sent before the cutback are in the same window.
+	c.OnPacketLost(160, maxSegmentSize, 0)
+	if c.cwnd != 85 {
+		t.Errorf("cwnd = %d after a second loss in the window, want 85", c.cwnd)
+	}
+	// A loss in a later window backs off again.
+	c.OnPacketSent(now, 0, 201, maxSegmentSize, true)
+	c.OnPacketLost(201, maxSegmentSize, 0)
+	if c.cwnd != 72 {
+		t.Errorf("cwnd = %d after a loss in the next window, want 72", c.cwnd)
+	}
+}
+
+func TestCubicRenoFriendlyGrowth(t *testing.T) {
+	c := &cubic{}
+	cwnd := c.cwndAfterLoss(100)
+	now := time.Now()
+	// Right after the loss the cubic function is flat, and the window grows
+	// like numConnections Reno flows would: numConnections packets per
+	// window of ACKs.
+	var got uint64
+	for i := uint64(0); i < cwnd; i++ {
+		got = c.cwndAfterAck(cwnd, 0, now)
+	}
+	if want := cwnd + numConnections; got != want {
+		t.Errorf("cwnd = %d after a window of ACKs, want %d", got, want)
+	}
+}
@@ -0,0 +1,237 @@
+package quic
+
+import "math/rand"
+
+// maxPacketGap is the largest jump in sequence numbers we'll track missing
+// packets across. It is well above maxCongestionWindow, so a peer only jumps
+// further if it is broken or malicious.
+const maxPacketGap = 5000
+
+// entropyHash returns the entropy contribution of a single packet. The
+// entropy bit is shifted by the sequence number so a peer has to know which
+// packets it received to produce the right cumulative hash.
+func entropyHash(sequenceNumber uint64, entropy bool) byte {
+	if !entropy {
+		return 0
+	}
+	return 1 << (sequenceNumber % 8)
+}
+
+// randomEntropy returns a random entropy bit for an outgoing packet.
+func randomEntropy() bool {
+	return rand.Intn(2) == 1
+}
+
+// sentEntropyManager keeps track of the entropy of the packets we've sent so
+// that the ReceivedEntropy in a peer's ACK can be checked. A peer that ACKs
+// packets it never received can't guess the hash and gets caught.
+type sentEntropyManager struct {
+	// hashes holds the entropy hash of each packet at or above leastUnacked.
+	hashes map[uint64]byte
+	// cumulative holds the XOR of the hashes of every packet up to and
+	// including the sequence number.
+	cumulative map[uint64]byte
+
+	lastHash     byte
+	leastUnacked uint64
+	// baseHash is the cumulative hash of every packet below leastUnacked.
+	baseHash byte
+}
+
+func newSentEntropyManager() *sentEntropyManager {
+	return &sentEntropyManager{
+		hashes:       map[uint64]byte{},
+		cumulative:   map[uint64]byte{},
+		leastUnacked: 1,
+	}
+}
+
+// RecordPacket records the entropy bit of a packet as it is sent.
+func (m *sentEntropyManager) RecordPacket(sequenceNumber uint64, entropy bool) {
+	hash := entropyHash(sequenceNumber, entropy)
+	m.lastHash ^= hash
+	m.hashes[sequenceNumber] = hash
+	m.cumulative[sequenceNumber] = m.lastHash
+}
+
+// EntropyHash returns the cumulative entropy hash of every packet sent up to
+// and including sequenceNumber.
+func (m *sentEntropyManager) EntropyHash(sequenceNumber uint64) (byte, bool) {
+	if sequenceNumber+1 == m.leastUnacked {
+		return m.baseHash, true
+	}
+	hash, ok := m.cumulative[sequenceNumber]
+	return hash, ok
+}
+
+// IsValidEntropy checks the entropy hash the peer reported in an ACK against
+// the packets we actually sent.
+func (m *sentEntropyManager) IsValidEntropy(largestObserved uint64, missingPackets []uint64, hash byte) bool {
+	expected, ok := m.EntropyHash(largestObserved)
+	if !ok {
+		return false
+	}
+	for _, sequenceNumber := range missingPackets {
+		if sequenceNumber < m.leastUnacked || sequenceNumber > largestObserved {
+			return false
+		}
+		expected ^= m.hashes[sequenceNumber]
+	}
+	return expected == hash
+}
+
+// ClearBefore forgets about every packet below sequenceNumber. Only call it
+// once the peer can no longer report those packets as missing.
+func (m *sentEntropyManager) ClearBefore(sequenceNumber uint64) {
+	if sequenceNumber <= m.leastUnacked {
+		return
+	}
+	if hash, ok := m.cumulative[sequenceNumber-1]; ok {
+		m.baseHash = hash
+	}
+	for seq := range m.hashes {
+		if seq < sequenceNumber {
+			delete(m.hashes, seq)
+			delete(m.cumulative, seq)
+		}
+	}
+	m.leastUnacked = sequenceNumber
+}
+
+// StopWaitingFrame returns the STOP_WAITING frame telling the peer to stop
+// waiting for packets below leastUnacked. sequenceNumber is the sequence
+// number of the packet the frame will be sent in. leastUnacked must not be
+// below the packets cleared with ClearBefore.
+func (m *sentEntropyManager) StopWaitingFrame(sequenceNumber, leastUnacked uint64) FrameStopWaiting {
+	hash, _ := m.EntropyHash(leastUnacked - 1)
+	return FrameStopWaiting{
+		SentEntropy:       hash,
+		LeastUnackedDelta: sequenceNumber - leastUnacked,
+	}
+}
+
+// receivedEntropyManager keeps track of the entropy of the packets we've
+// received so that it can be reported back to the peer in ACKs.
+type receivedEntropyManager struct {
+	// hashes holds the entropy hash of each packet received at or above
+	// leastUnacked.
+	hashes          map[uint64]byte
+	largestObserved uint64
+	leastUnacked    uint64
+	// baseHash is the cumulative hash of every packet below leastUnacked as
+	// reported by the peer's STOP_WAITING.
+	baseHash byte
+}
+
+func newReceivedEntropyManager() *receivedEntropyManager {
+	return &receivedEntropyManager{
+		hashes:       map[uint64]byte{},
+		leastUnacked: 1,
+	}
+}
+
+// RecordPacket records the entropy bit of a received packet. It returns false
+// if the packet is a duplicate or below the peer's STOP_WAITING, and an error
+// if it is too far ahead to be tracked.
+func (m *receivedEntropyManager) RecordPacket(sequenceNumber uint64, entropy bool) (bool, error) {
+	if sequenceNumber > m.largestObserved+maxPacketGap {
+		return false, qerr(QUIC_INVALID_PACKET_HEADER, "packet %d is more than %d packets ahead of %d", sequenceNumber, maxPacketGap, m.largestObserved)
+	}
+	if sequenceNumber < m.leastUnacked {
+		return false, nil
+	}
+	if _, ok := m.hashes[sequenceNumber]; ok {
+		return false, nil
+	}
+	m.hashes[sequenceNumber] = entropyHash(sequenceNumber, entropy)
+	if sequenceNumber > m.largestObserved {
+		m.largestObserved = sequenceNumber
+	}
+	return true, nil
+}
+
+// EntropyHash returns the cumulative entropy hash of every packet received up
+// to the largest observed one.
+func (m *receivedEntropyManager) EntropyHash() byte {
+	hash := m.baseHash
+	for _, h := range m.hashes {
+		hash ^= h
+	}
+	return hash
+}
+
+// SetCumulativeEntropyUpTo handles a STOP_WAITING from the peer. Packets below
+// leastUnacked are no longer reported and their cumulative hash is replaced
+// by the one the peer sent.
+func (m *receivedEntropyManager) SetCumulativeEntropyUpTo(leastUnacked uint64, entropy byte) {
+	if leastUnacked <= m.leastUnacked {
+		return
+	}
+	for seq := range m.hashes {
+		if seq < leastUnacked {
+			delete(m.hashes, seq)
+		}
+	}
+	m.baseHash = entropy
+	m.leastUnacked = leastUnacked
+	if m.largestObserved < leastUnacked-1 {
+		m.largestObserved = leastUnacked - 1
+	}
+}
+
+// MissingPackets returns the sequence numbers between leastUnacked and the
+// largest observed packet that haven't been received.
+func (m *receivedEntropyManager) MissingPackets() []uint64 {
+	var missing []uint64
+	for seq := m.leastUnacked; seq < m.largestObserved; seq++ {
+		if _, ok := m.hashes[seq]; !ok {
+			missing = append(missing, seq)
+		}
+	}
+	return missing
+}
+
+// AckFrame builds an ACK frame covering every packet received so far, in at
+// most maxSize bytes. If the missing packets don't fit, the frame is
+// truncated: LargestObserved is lowered so that every missing packet below it
+// is still listed, and the entropy hash only covers the packets up to it.
+func (m *receivedEntropyManager) AckFrame(maxSize int) FrameAck {
+	missing := m.MissingPackets()
+	maxRanges := (maxSize - ackFrameHeaderSize - ackRangesOverhead) / ackRangeSize
+	if maxRanges > maxAckRanges {
+		maxRanges = maxAckRanges
+	}
+	if len(missing) == 0 || ackRangeCount(missing) <= maxRanges {
+		return FrameAck{
+			ReceivedEntropy: m.EntropyHash(),
+			LargestObserved: m.largestObserved,
+			MissingPackets:  missing,
+		}
+	}
+
+	// Keep the lowest runs of missing packets that fit. The packet below the
+	// first run left out was received, or is below leastUnacked.
+	kept, ranges := 0, 0
+	for kept < len(missing) {
+		n := ackRunLength(missing[kept:])
+		cost := (n + maxAckRangeLength - 1) / maxAckRangeLength
+		if ranges+cost > maxRanges {
+			break
+		}
+		ranges += cost
+		kept += n
+	}
+	largestObserved := missing[kept] - 1
+	hash := m.baseHash
+	for seq, h := range m.hashes {
+		if seq <= largestObserved {
+			hash ^= h
+		}
+	}
+	return FrameAck{
+		ReceivedEntropy: hash,
+		LargestObserved: largestObserved,
+		MissingPackets:  missing[:kept],
+		Truncated:       true,
+	}
+}
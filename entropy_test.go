@@ -0,0 +1,106 @@
+package quic
+
+import (
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// recordPackets records packets 1 to largest as sent, and every packet for
+// which received returns true as received.
+func recordPackets(largest uint64, received func(uint64) bool) (*sentEntropyManager, *receivedEntropyManager) {
+	sent := newSentEntropyManager()
+	recv := newReceivedEntropyManager()
+	for seq := uint64(1); seq <= largest; seq++ {
+		entropy := seq%3 == 0 || seq%7 == 0
+		sent.RecordPacket(seq, entropy)
+		if received(seq) {
+			recv.RecordPacket(seq, entropy)
+		}
+	}
+	return sent, recv
+}
+
+func TestAckFrameTruncation(t *testing.T) {
+	tests := []struct {
+		name      string
+		largest   uint64
+		received  func(uint64) bool
+		maxSize   int
+		truncated bool
+	}{
+		{"no loss", 100, func(uint64) bool { return true }, 100, false},
+		{"fits", 100, func(seq uint64) bool { return seq%10 != 0 || seq == 100 }, 200, false},
+		{"every other packet lost", 1000, func(seq uint64) bool { return seq%2 == 0 }, 1300, true},
+		{"small packet", 1000, func(seq uint64) bool { return seq%2 == 0 }, 100, true},
+		{"long runs", 1000, func(seq uint64) bool { return seq%300 == 0 }, 1300, false},
+		{"long runs in a small packet", 1000, func(seq uint64) bool { return seq%300 == 0 }, ackFrameSize(3), true},
+		{"first run too long", 1000, func(seq uint64) bool { return seq > 600 }, ackFrameSize(2), true},
+		{"too many ranges for any packet", 1200, func(seq uint64) bool { return seq%2 == 0 }, 10000, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sent, recv := recordPackets(tt.largest, tt.received)
+			ack := recv.AckFrame(tt.maxSize)
+			if ack.Truncated != tt.truncated {
+				t.Fatalf("Truncated = %v, want %v", ack.Truncated, tt.truncated)
+			}
+			buf, err := ack.ToBuf()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(buf) > tt.maxSize {
+				t.Fatalf("ack frame is %d bytes, want at most %d", len(buf), tt.maxSize)
+			}
+			if !tt.truncated && ack.LargestObserved != recv.largestObserved {
+				t.Errorf("LargestObserved = %d, want %d", ack.LargestObserved, recv.largestObserved)
+			}
+			if !sent.IsValidEntropy(ack.LargestObserved, ack.MissingPackets, ack.ReceivedEntropy) {
+				t.Fatalf("sender rejects the ack entropy of %+v", ack)
+			}
+
+			p := &Packet{PublicFlags: SequenceNumber6Bytes}
+			if err := p.ParsePayload(append([]byte{0}, buf...)); err != nil {
+				t.Fatal(err)
+			}
+			parsed := p.Frames[0].(FrameAck)
+			if parsed.Truncated != ack.Truncated || parsed.LargestObserved != ack.LargestObserved {
+				t.Errorf("parsed %+v, want %+v", parsed, ack)
+			}
+			if len(ack.MissingPackets) > 0 && !reflect.DeepEqual(sorted(parsed.MissingPackets), sorted(ack.MissingPackets)) {
+				t.Errorf("parsed missing packets %v, want %v", parsed.MissingPackets, ack.MissingPackets)
+			}
+		})
+	}
+}
+
+func TestAckFrameTooManyRanges(t *testing.T) {
+	var missing []uint64
+	for seq := uint64(1); seq < 600; seq += 2 {
+		missing = append(missing, seq)
+	}
+	if _, err := (FrameAck{LargestObserved: 600, MissingPackets: missing}).ToBuf(); err == nil {
+		t.Fatal("ToBuf() succeeded with 300 missing packet ranges")
+	}
+}
+
+func TestReceivedPacketTooFarAhead(t *testing.T) {
+	recv := newReceivedEntropyManager()
+	if ok, err := recv.RecordPacket(maxPacketGap, true); !ok || err != nil {
+		t.Fatalf("RecordPacket(%d) = %t, %v, want true, nil", maxPacketGap, ok, err)
+	}
+	if ok, err := recv.RecordPacket(maxPacketGap, true); ok || err != nil {
+		t.Errorf("RecordPacket() of a duplicate = %t, %v, want false, nil", ok, err)
+	}
+	if _, err := recv.RecordPacket(2*maxPacketGap+1, true); !errors.Is(err, QUIC_INVALID_PACKET_HEADER) {
+		t.Errorf("RecordPacket(%d) = %v, want QUIC_INVALID_PACKET_HEADER", 2*maxPacketGap+1, err)
+	}
+}
+
+// sorted returns a sorted copy of sequence numbers.
+func sorted(seqs []uint64) []uint64 {
+	s := append([]uint64(nil), seqs...)
+	sort.Sort(uint64Slice(s))
+	return s
+}
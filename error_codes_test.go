@@ -0,0 +1,44 @@
+package quic
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestErrorCodeString(t *testing.T) {
+	tests := []struct {
+		code fmt.Stringer
+		want string
+	}{
+		{QUIC_NO_ERROR, "QUIC_NO_ERROR"},
+		{QUIC_INVALID_STREAM_DATA, "QUIC_INVALID_STREAM_DATA"},
+		{QUIC_MISSING_PAYLOAD, "QUIC_MISSING_PAYLOAD"},
+		{QUIC_LAST_ERROR, "QUIC_LAST_ERROR"},
+		{ErrorCode(21), "ErrorCode(21)"},
+		{ErrorCode(1000), "ErrorCode(1000)"},
+		{QUIC_REFUSED_STREAM, "QUIC_REFUSED_STREAM"},
+		{StreamErrorCode(9), "StreamErrorCode(9)"},
+	}
+	for _, tt := range tests {
+		if got := tt.code.String(); got != tt.want {
+			t.Errorf("String() = %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestSessionErrorCodes(t *testing.T) {
+	tests := []struct {
+		err  error
+		code ErrorCode
+	}{
+		{errPeerGoingAway, QUIC_PEER_GOING_AWAY},
+		{errTooManyOpenStreams, QUIC_TOO_MANY_OPEN_STREAMS},
+		{errStreamClosed, QUIC_STREAM_DATA_AFTER_TERMINATION},
+	}
+	for _, tt := range tests {
+		if !errors.Is(tt.err, tt.code) {
+			t.Errorf("%v doesn't match %s", tt.err, tt.code)
+		}
+	}
+}
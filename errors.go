@@ -0,0 +1,52 @@
+package quic
+
+import "fmt"
+
+// QuicError is a connection error with a QUIC error code, such as the one a
+// session was closed with. It matches its ErrorCode with errors.Is.
+type QuicError struct {
+	ErrorCode    ErrorCode
+	ErrorMessage string
+	// Remote is set if the peer closed the connection.
+	Remote bool
+}
+
+func (e *QuicError) Error() string {
+	if e.Remote {
+		return fmt.Sprintf("quic: peer closed connection with %s: %s", e.ErrorCode.String(), e.ErrorMessage)
+	}
+	return fmt.Sprintf("quic: %s: %s", e.ErrorCode.String(), e.ErrorMessage)
+}
+
+// Is reports whether target is the error code of e.
+func (e *QuicError) Is(target error) bool {
+	code, ok := target.(ErrorCode)
+	return ok && code == e.ErrorCode
+}
+
+// ApplicationError is returned by reads and writes on a stream that was
+// reset. It matches its ErrorCode with errors.Is.
+type ApplicationError struct {
+	StreamID  uint64
+	ErrorCode StreamErrorCode
+	// Remote is set if the peer reset the stream.
+	Remote bool
+}
+
+func (e *ApplicationError) Error() string {
+	if e.Remote {
+		return fmt.Sprintf("quic: stream %d reset by peer with %s", e.StreamID, e.ErrorCode.String())
+	}
+	return fmt.Sprintf("quic: stream %d reset with %s", e.StreamID, e.ErrorCode.String())
+}
+
+// Is reports whether target is the error code of e.
+func (e *ApplicationError) Is(target error) bool {
+	code, ok := target.(StreamErrorCode)
+	return ok && code == e.ErrorCode
+}
+
+// qerr returns a local QuicError.
+func qerr(code ErrorCode, format string, args ...interface{}) *QuicError {
+	return &QuicError{ErrorCode: code, ErrorMessage: fmt.Sprintf(format, args...)}
+}
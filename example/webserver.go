@@ -9,23 +9,12 @@ import (
 )
 
 func handler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Add("alternate-protocol", "8443:quic")
-	fmt.Fprintf(w, "Should redirect to QUIC.")
+	fmt.Fprintf(w, "Hello over %s.", r.Proto)
 }
 
 func main() {
-	ql, err := quic.Listen(8443)
-	if err != nil {
-		panic(err)
-	}
-	defer ql.Close()
+	http.HandleFunc("/", handler)
 
 	log.Println("Running")
-
-	http.HandleFunc("/", handler)
-	http.ListenAndServe(":8443", nil)
-	/*err = http.ListenAndServeTLS(":8443", "keys/cert.pem", "keys/key.pem", nil)
-	if err != nil {
-		panic(err)
-	}*/
+	log.Fatal(quic.ListenAndServeDual(":8443", "keys/cert.pem", "keys/key.pem", nil))
 }
@@ -0,0 +1,113 @@
+package quic
+
+import "sync"
+
+// Flow control constants
+const (
+	// defaultStreamWindow is the initial flow control window of a stream.
+	defaultStreamWindow = 16 * 1024
+	// defaultConnectionWindow is the initial flow control window of the
+	// connection as a whole.
+	defaultConnectionWindow = 16 * 1024
+)
+
+// flowController tracks the flow control windows of a stream, or of the
+// whole connection summed over its streams. Offsets are absolute, as in
+// WINDOW_UPDATE frames.
+type flowController struct {
+	mu sync.Mutex
+
+	// Send side
+	bytesSent  uint64
+	sendWindow uint64
+
+	// Receive side
+	highestReceived   uint64
+	bytesRead         uint64
+	receiveWindow     uint64
+	receiveWindowSize uint64
+}
+
+func newFlowController(window uint64) *flowController {
+	return &flowController{
+		sendWindow:        window,
+		receiveWindow:     window,
+		receiveWindowSize: window,
+	}
+}
+
+// SendWindowSize returns how many more bytes may be sent.
+func (f *flowController) SendWindowSize() uint64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.bytesSent > f.sendWindow {
+		return 0
+	}
+	return f.sendWindow - f.bytesSent
+}
+
+// AddBytesSent records data sent to the peer.
+func (f *flowController) AddBytesSent(n uint64) {
+	f.mu.Lock()
+	f.bytesSent += n
+	f.mu.Unlock()
+}
+
+// UpdateSendWindow handles a WINDOW_UPDATE from the peer.
+func (f *flowController) UpdateSendWindow(offset uint64) {
+	f.mu.Lock()
+	if offset > f.sendWindow {
+		f.sendWindow = offset
+	}
+	f.mu.Unlock()
+}
+
+// UpdateHighestReceived records that the peer sent data up to offset and
+// returns by how much the highest received offset grew. It reports false if
+// the peer exceeded the receive window.
+func (f *flowController) UpdateHighestReceived(offset uint64) (uint64, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if offset <= f.highestReceived {
+		return 0, true
+	}
+	increment := offset - f.highestReceived
+	f.highestReceived = offset
+	return increment, f.highestReceived <= f.receiveWindow
+}
+
+// HighestReceived returns the highest offset the peer has sent data up to.
+func (f *flowController) HighestReceived() uint64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.highestReceived
+}
+
+// AddHighestReceived is UpdateHighestReceived for the connection, which
+// grows by the increments of its streams.
+func (f *flowController) AddHighestReceived(increment uint64) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.highestReceived += increment
+	return f.highestReceived <= f.receiveWindow
+}
+
+// AddBytesRead records data consumed by the application, which makes room
+// in the receive window.
+func (f *flowController) AddBytesRead(n uint64) {
+	f.mu.Lock()
+	f.bytesRead += n
+	f.mu.Unlock()
+}
+
+// MaybeWindowUpdate returns the new receive window offset to send the peer
+// once more than half of the window has been consumed.
+func (f *flowController) MaybeWindowUpdate() (uint64, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.receiveWindow-f.bytesRead > f.receiveWindowSize/2 {
+		return 0, false
+	}
+	f.receiveWindow = f.bytesRead + f.receiveWindowSize
+	return f.receiveWindow, true
+}
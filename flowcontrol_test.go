@@ -0,0 +1,285 @@
+package quic
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestFlowControllerSendWindow(t *testing.T) {
+	f := newFlowController(1000)
+	f.AddBytesSent(600)
+	if got := f.SendWindowSize(); got != 400 {
+		t.Errorf("SendWindowSize() = %d, want 400", got)
+	}
+	f.UpdateSendWindow(1500)
+	if got := f.SendWindowSize(); got != 900 {
+		t.Errorf("SendWindowSize() after a WINDOW_UPDATE = %d, want 900", got)
+	}
+	// Reordered WINDOW_UPDATEs don't shrink the window.
+	f.UpdateSendWindow(1200)
+	if got := f.SendWindowSize(); got != 900 {
+		t.Errorf("SendWindowSize() after an old WINDOW_UPDATE = %d, want 900", got)
+	}
+	f.AddBytesSent(1000)
+	if got := f.SendWindowSize(); got != 0 {
+		t.Errorf("SendWindowSize() past the window = %d, want 0", got)
+	}
+}
+
+func TestFlowControllerReceiveWindow(t *testing.T) {
+	f := newFlowController(1000)
+	if increment, ok := f.UpdateHighestReceived(300); increment != 300 || !ok {
+		t.Errorf("UpdateHighestReceived(300) = %d, %t, want 300, true", increment, ok)
+	}
+	// Retransmitted or reordered data doesn't count twice.
+	if increment, ok := f.UpdateHighestReceived(200); increment != 0 || !ok {
+		t.Errorf("UpdateHighestReceived(200) = %d, %t, want 0, true", increment, ok)
+	}
+	if _, ok := f.MaybeWindowUpdate(); ok {
+		t.Error("window update before anything was read")
+	}
+
+	// Reading more than half the window moves it.
+	f.AddBytesRead(501)
+	offset, ok := f.MaybeWindowUpdate()
+	if !ok || offset != 1501 {
+		t.Errorf("MaybeWindowUpdate() = %d, %t, want 1501, true", offset, ok)
+	}
+	if _, ok := f.MaybeWindowUpdate(); ok {
+		t.Error("second window update without more data read")
+	}
+	if _, ok := f.UpdateHighestReceived(1501); !ok {
+		t.Error("data up to the new window is a violation")
+	}
+	if _, ok := f.UpdateHighestReceived(1502); ok {
+		t.Error("data past the window isn't a violation")
+	}
+
+	conn := newFlowController(1000)
+	if !conn.AddHighestReceived(600) || conn.AddHighestReceived(401) {
+		t.Error("connection window isn't enforced across increments")
+	}
+}
+
+// receiveStreamFrame hands frame to the session as if the peer sent it.
+func receiveStreamFrame(s *Session, frame FrameStream) error {
+	var err error
+	doSync(s, func() { err = s.handleStreamFrame(frame) })
+	return err
+}
+
+// queuedWindowUpdates returns the WINDOW_UPDATE frames the session queues
+// for its receive windows.
+func queuedWindowUpdates(s *Session) []FrameWindowUpdate {
+	var updates []FrameWindowUpdate
+	doSync(s, func() {
+		s.queueWindowUpdates()
+		for _, f := range s.pendingFrames {
+			if frame, ok := f.(FrameWindowUpdate); ok {
+				updates = append(updates, frame)
+			}
+		}
+	})
+	return updates
+}
+
+func TestStreamFlowControlViolation(t *testing.T) {
+	s, _ := newTestSession(t)
+	err := receiveStreamFrame(s, FrameStream{StreamID: 5, Offset: defaultStreamWindow, Data: "x"})
+	if !errors.Is(err, QUIC_FLOW_CONTROL_RECEIVED_TOO_MUCH_DATA) {
+		t.Errorf("data past the stream window = %v, want QUIC_FLOW_CONTROL_RECEIVED_TOO_MUCH_DATA", err)
+	}
+}
+
+func TestConnectionFlowControlViolation(t *testing.T) {
+	s, _ := newTestSession(t)
+	half := strings.Repeat("x", defaultConnectionWindow/2)
+	for _, id := range []uint64{5, 7} {
+		if err := receiveStreamFrame(s, FrameStream{StreamID: id, Data: half}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Each stream is within its own window, together they exceed the
+	// connection's.
+	err := receiveStreamFrame(s, FrameStream{StreamID: 9, Data: "x"})
+	if !errors.Is(err, QUIC_FLOW_CONTROL_RECEIVED_TOO_MUCH_DATA) {
+		t.Errorf("data past the connection window = %v, want QUIC_FLOW_CONTROL_RECEIVED_TOO_MUCH_DATA", err)
+	}
+}
+
+func TestWindowUpdateAfterRead(t *testing.T) {
+	s, _ := newTestSession(t)
+	data := strings.Repeat("x", defaultStreamWindow/2+1)
+	if err := receiveStreamFrame(s, FrameStream{StreamID: 5, Data: data}); err != nil {
+		t.Fatal(err)
+	}
+	if updates := queuedWindowUpdates(s); len(updates) != 0 {
+		t.Errorf("queued %+v before anything was read", updates)
+	}
+
+	stream, err := s.AcceptStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadFull(stream, make([]byte, len(data))); err != nil {
+		t.Fatal(err)
+	}
+	got := map[uint64]uint64{}
+	for _, u := range queuedWindowUpdates(s) {
+		got[u.StreamID] = u.ByteOffset
+	}
+	want := uint64(len(data) + defaultStreamWindow)
+	if got[0] != want || got[5] != want {
+		t.Errorf("queued window updates %v, want %d for the connection and stream 5", got, want)
+	}
+
+	// The peer may now send up to the new offsets.
+	if err := receiveStreamFrame(s, FrameStream{StreamID: 5, Offset: want - 1, Data: "x"}); err != nil {
+		t.Errorf("data up to the new window = %v", err)
+	}
+}
+
+func TestHandleWindowUpdate(t *testing.T) {
+	s, _ := newTestSession(t)
+	stream, err := s.OpenStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var streamWindow, connWindow uint64
+	doSync(s, func() {
+		s.handleWindowUpdate(FrameWindowUpdate{StreamID: stream.StreamID(), ByteOffset: 2 * defaultStreamWindow})
+		s.handleWindowUpdate(FrameWindowUpdate{StreamID: 0, ByteOffset: 3 * defaultConnectionWindow})
+		// Unknown streams are ignored.
+		s.handleWindowUpdate(FrameWindowUpdate{StreamID: 101, ByteOffset: 1})
+		streamWindow = stream.flowController.SendWindowSize()
+		connWindow = s.connFlowController.SendWindowSize()
+	})
+	if streamWindow != 2*defaultStreamWindow {
+		t.Errorf("stream send window = %d, want %d", streamWindow, 2*defaultStreamWindow)
+	}
+	if connWindow != 3*defaultConnectionWindow {
+		t.Errorf("connection send window = %d, want %d", connWindow, 3*defaultConnectionWindow)
+	}
+}
+
+func TestHandleResetStream(t *testing.T) {
+	s, _ := newTestSession(t)
+	if err := receiveStreamFrame(s, FrameStream{StreamID: 5, Data: "hello"}); err != nil {
+		t.Fatal(err)
+	}
+	stream, err := s.AcceptStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var resetErr error
+	var highest, read uint64
+	var queued []Frame
+	doSync(s, func() {
+		s.pendingFrames = nil
+		resetErr = s.handleResetStream(FrameResetStream{StreamID: 5, ErrorCode: QUIC_STREAM_CANCELLED, ByteOffset: 1000})
+		highest, read = s.connFlowController.highestReceived, s.connFlowController.bytesRead
+		queued = s.pendingFrames
+	})
+	if resetErr != nil {
+		t.Fatal(resetErr)
+	}
+	// The data up to the final offset counts against the connection window,
+	// and is given back since it will never be read.
+	if highest != 1000 || read != 1000 {
+		t.Errorf("connection received %d and read %d bytes, want 1000 and 1000", highest, read)
+	}
+	if len(queued) != 1 || queued[0] != (FrameResetStream{StreamID: 5, ErrorCode: QUIC_RST_ACKNOWLEDGEMENT}) {
+		t.Errorf("queued %+v, want a RST_STREAM acknowledgement", queued)
+	}
+	var appErr *ApplicationError
+	if _, err := stream.Read(make([]byte, 10)); !errors.As(err, &appErr) || appErr.ErrorCode != QUIC_STREAM_CANCELLED || !appErr.Remote {
+		t.Errorf("Read() after the reset = %v, want the peer's QUIC_STREAM_CANCELLED", err)
+	}
+}
+
+func TestHandleResetStreamNoError(t *testing.T) {
+	s, _ := newTestSession(t)
+	if err := receiveStreamFrame(s, FrameStream{StreamID: 5, Data: "hello"}); err != nil {
+		t.Fatal(err)
+	}
+	stream, err := s.AcceptStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var resetErr error
+	doSync(s, func() {
+		resetErr = s.handleResetStream(FrameResetStream{StreamID: 5, ErrorCode: QUIC_STREAM_NO_ERROR, ByteOffset: 5})
+	})
+	if resetErr != nil {
+		t.Fatal(resetErr)
+	}
+	// The peer only stopped reading, what it sent can still be read.
+	got, err := io.ReadAll(stream)
+	if err != nil || string(got) != "hello" {
+		t.Errorf("ReadAll() = %q, %v, want hello", got, err)
+	}
+	if _, err := stream.Write([]byte("x")); err == nil {
+		t.Error("Write() after the peer stopped reading succeeded")
+	}
+}
+
+func TestHandleResetStreamViolations(t *testing.T) {
+	tests := []struct {
+		name  string
+		reset FrameResetStream
+		code  ErrorCode
+	}{
+		{"past the stream window", FrameResetStream{StreamID: 5, ByteOffset: defaultStreamWindow + 1}, QUIC_FLOW_CONTROL_RECEIVED_TOO_MUCH_DATA},
+		{"below received data", FrameResetStream{StreamID: 5, ByteOffset: 2}, QUIC_STREAM_DATA_AFTER_TERMINATION},
+		{"other final offset", FrameResetStream{StreamID: 7, ByteOffset: 6}, QUIC_STREAM_DATA_AFTER_TERMINATION},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _ := newTestSession(t)
+			if err := receiveStreamFrame(s, FrameStream{StreamID: 5, Data: "hello"}); err != nil {
+				t.Fatal(err)
+			}
+			if err := receiveStreamFrame(s, FrameStream{StreamID: 7, Data: "hello", Fin: true}); err != nil {
+				t.Fatal(err)
+			}
+			var err error
+			doSync(s, func() { err = s.handleResetStream(tt.reset) })
+			if !errors.Is(err, tt.code) {
+				t.Errorf("handleResetStream() = %v, want %s", err, tt.code)
+			}
+		})
+	}
+}
+
+func TestHandleGoAway(t *testing.T) {
+	s, _ := newTestSession(t)
+	var streams []*Stream
+	for i := 0; i < 2; i++ {
+		stream, err := s.OpenStream()
+		if err != nil {
+			t.Fatal(err)
+		}
+		streams = append(streams, stream)
+	}
+	doSync(s, func() {
+		s.handleGoAway(FrameGoAway{ErrorCode: QUIC_PEER_GOING_AWAY, LastGoodStreamID: streams[0].StreamID()})
+	})
+
+	// Streams the peer processed carry on, later ones are aborted.
+	streams[0].mu.Lock()
+	kept := streams[0].err
+	streams[0].mu.Unlock()
+	if kept != nil {
+		t.Errorf("stream %d processed by the peer was aborted: %v", streams[0].StreamID(), kept)
+	}
+	var appErr *ApplicationError
+	if _, err := streams[1].Read(make([]byte, 1)); !errors.As(err, &appErr) || appErr.ErrorCode != QUIC_STREAM_PEER_GOING_AWAY {
+		t.Errorf("Read() on stream %d = %v, want QUIC_STREAM_PEER_GOING_AWAY", streams[1].StreamID(), err)
+	}
+	if _, err := s.OpenStream(); err != errPeerGoingAway {
+		t.Errorf("OpenStream() after GOAWAY = %v, want %v", err, errPeerGoingAway)
+	}
+}
@@ -2,7 +2,7 @@ package quic
 
 import (
 	"encoding/binary"
-	"errors"
+	"sort"
 )
 
 // Frame Types
@@ -48,9 +48,9 @@ func (f FrameStream) ToBuf() ([]byte, error) {
 		buf[0] = buf[0] | 0x40
 	}
 	binary.PutUvarint(buf[1:5], f.StreamID)
-	binary.PutUvarint(buf[5:9], f.Offset)
-	binary.PutUvarint(buf[9:11], uint64(len(f.Data)))
-	copy(buf[11:], f.Data)
+	binary.PutUvarint(buf[5:13], f.Offset)
+	binary.PutUvarint(buf[13:15], uint64(len(f.Data)))
+	copy(buf[15:], f.Data)
 	return buf, nil
 }
 
@@ -64,38 +64,141 @@ const (
 
 // FrameAck represents a AckFrame
 type FrameAck struct {
-	ReceivedEntropy                           byte
-	LargestObserved, LargestObservedDeltaTime uint64
+	ReceivedEntropy byte
+	LargestObserved uint64
+	// LargestObservedDeltaTime is the time in microseconds between receiving
+	// the largest observed packet and sending the ACK.
+	LargestObservedDeltaTime uint64
+	// MissingPackets lists the sequence numbers below LargestObserved that
+	// haven't been received.
+	MissingPackets []uint64
+	// Truncated is set if packets above LargestObserved were received but
+	// left out for lack of space.
+	Truncated bool
 }
 
-// ToBuf serializes a frame into a byte array
+// ACK frame sizes
+const (
+	// ackFrameHeaderSize is the size of an ACK frame without missing
+	// packets.
+	ackFrameHeaderSize = 1 + 1 + 6 + 2 + 1
+	// ackRangesOverhead is the size of the range and revived packet counts
+	// of an ACK frame with missing packets.
+	ackRangesOverhead = 1 + 1
+	// ackRangeSize is the size of a missing packet range.
+	ackRangeSize = 6 + 1
+	// maxAckRanges is the most missing packet ranges an ACK frame holds.
+	maxAckRanges = 0xff
+	// maxAckRangeLength is the most missing packets a single range holds.
+	maxAckRangeLength = 0xff + 1
+)
+
+// ackRangeCount returns the number of ranges needed to list the missing
+// packets, sorted in either order, in an ACK frame.
+func ackRangeCount(missing []uint64) int {
+	ranges := 0
+	for i := 0; i < len(missing); {
+		n := ackRunLength(missing[i:])
+		ranges += (n + maxAckRangeLength - 1) / maxAckRangeLength
+		i += n
+	}
+	return ranges
+}
+
+// ackRunLength returns the number of consecutive sequence numbers at the
+// start of the sorted missing packets.
+func ackRunLength(missing []uint64) int {
+	n := 1
+	for n < len(missing) && (missing[n] == missing[n-1]+1 || missing[n] == missing[n-1]-1) {
+		n++
+	}
+	return n
+}
+
+// ackFrameSize returns the size of an ACK frame with the given number of
+// missing packet ranges.
+func ackFrameSize(ranges int) int {
+	if ranges == 0 {
+		return ackFrameHeaderSize
+	}
+	return ackFrameHeaderSize + ackRangesOverhead + ranges*ackRangeSize
+}
+
+// ToBuf serializes a frame into a byte array. It fails if the missing
+// packets need more than maxAckRanges ranges.
 func (f FrameAck) ToBuf() ([]byte, error) {
-	buf := make([]byte, 1+1+6+2+1)
-	buf[0] = AckFrame
+	buf := make([]byte, ackFrameHeaderSize)
+	buf[0] = AckFrame | LargestObservedSequenceNumberLenMask | SequenceNumberDeltaLenMask
+	if f.Truncated {
+		buf[0] |= TruncatedMask
+	}
 	buf[1] = f.ReceivedEntropy
 	binary.PutUvarint(buf[2:8], f.LargestObserved)
-	binary.PutUvarint(buf[8:10], f.LargestObservedDeltaTime)
-	// TODO rest of this shit.
-	return buf, errors.New("frame FrameAck not fully implemented")
+	binary.LittleEndian.PutUint16(buf[8:10], uint64ToUFloat16(f.LargestObservedDeltaTime))
+	// No timestamps are sent.
+	buf[10] = 0
+	if len(f.MissingPackets) == 0 {
+		return buf, nil
+	}
+	buf[0] |= NackMask
+	if ackRangeCount(f.MissingPackets) > maxAckRanges {
+		return nil, qerr(QUIC_INVALID_ACK_DATA, "too many missing packet ranges for an ack frame")
+	}
+
+	missing := make([]uint64, len(f.MissingPackets))
+	copy(missing, f.MissingPackets)
+	sort.Sort(sort.Reverse(uint64Slice(missing)))
+
+	var ranges []byte
+	numRanges := 0
+	next := f.LargestObserved
+	for i := 0; i < len(missing); numRanges++ {
+		if missing[i] >= next {
+			return nil, qerr(QUIC_INVALID_ACK_DATA, "missing packet %d is not below largest observed %d", missing[i], f.LargestObserved)
+		}
+		start := missing[i]
+		rangeLength := 0
+		for i+1 < len(missing) && missing[i+1] == missing[i]-1 && rangeLength < maxAckRangeLength-1 {
+			i++
+			rangeLength++
+		}
+		i++
+		rangeBuf := make([]byte, ackRangeSize)
+		binary.PutUvarint(rangeBuf[0:6], next-start)
+		rangeBuf[6] = byte(rangeLength)
+		ranges = append(ranges, rangeBuf...)
+		next = start - uint64(rangeLength)
+	}
+	buf = append(buf, byte(numRanges))
+	buf = append(buf, ranges...)
+	// No revived packets.
+	buf = append(buf, 0)
+	return buf, nil
 }
 
 // Constants for FrameAck
 const (
 	SequenceNumberDeltaLenMask           = 0x03
 	LargestObservedSequenceNumberLenMask = 0xC
+	TruncatedMask                        = 0x10
+	NackMask                             = 0x20
 )
 
 // FrameResetStream represents a ResetStreamFrame
 type FrameResetStream struct {
-	StreamID, ErrorCode uint64
+	StreamID  uint64
+	ErrorCode StreamErrorCode
+	// ByteOffset is the final offset of the data sent on the stream.
+	ByteOffset uint64
 }
 
 // ToBuf serializes a frame into a byte array
 func (f FrameResetStream) ToBuf() ([]byte, error) {
-	buf := make([]byte, 1+4+4)
+	buf := make([]byte, 1+4+8+4)
 	buf[0] = ResetStreamFrame
 	binary.PutUvarint(buf[1:5], f.StreamID)
-	binary.PutUvarint(buf[5:9], f.ErrorCode)
+	binary.PutUvarint(buf[5:13], f.ByteOffset)
+	binary.PutUvarint(buf[13:17], uint64(f.ErrorCode))
 	return buf, nil
 }
 
@@ -105,10 +208,13 @@ type FrameStopWaiting struct {
 	LeastUnackedDelta uint64
 }
 
+// stopWaitingFrameSize is the size of a STOP_WAITING frame.
+const stopWaitingFrameSize = 2 + 6
+
 // ToBuf serializes a frame into a byte array
 // TODO Variable length delta
 func (f FrameStopWaiting) ToBuf() ([]byte, error) {
-	buf := make([]byte, 2+6)
+	buf := make([]byte, stopWaitingFrameSize)
 	buf[0] = StopWaitingFrame
 	buf[1] = f.SentEntropy
 	binary.PutUvarint(buf[2:8], f.LeastUnackedDelta)
@@ -162,24 +268,41 @@ func (f FramePing) ToBuf() ([]byte, error) {
 
 // FramePadding represents a PaddingFrame
 type FramePadding struct {
+	// Size is the number of bytes of padding, which fills the rest of the
+	// packet.
+	Size int
 }
 
 // ToBuf serializes a FramePadding into a byte array
 func (f FramePadding) ToBuf() ([]byte, error) {
-	return []byte{PaddingFrame}, nil
+	if f.Size <= 1 {
+		return []byte{PaddingFrame}, nil
+	}
+	return make([]byte, f.Size), nil
 }
 
+// maxReasonLength is the longest reason phrase the two byte length of
+// CONNECTION_CLOSE and GOAWAY frames can hold.
+const maxReasonLength = 1<<14 - 1
+
+// connectionCloseFrameHeaderSize is the size of a CONNECTION_CLOSE frame
+// without its reason.
+const connectionCloseFrameHeaderSize = 1 + 4 + 2
+
 // FrameConnectionClose represents a ConnectionCloseFrame
 type FrameConnectionClose struct {
-	ErrorCode uint64
+	ErrorCode ErrorCode
 	Reason    string
 }
 
 // ToBuf serializes a FrameConnectionClose into a byte array
 func (f FrameConnectionClose) ToBuf() ([]byte, error) {
-	buf := make([]byte, 1+4+2+len(f.Reason))
+	if len(f.Reason) > maxReasonLength {
+		return nil, qerr(QUIC_INVALID_CONNECTION_CLOSE_DATA, "connection close reason of %d bytes is too long", len(f.Reason))
+	}
+	buf := make([]byte, connectionCloseFrameHeaderSize+len(f.Reason))
 	buf[0] = ConnectionCloseFrame
-	binary.PutUvarint(buf[1:5], f.ErrorCode)
+	binary.PutUvarint(buf[1:5], uint64(f.ErrorCode))
 	binary.PutUvarint(buf[5:7], uint64(len(f.Reason)))
 	copy(buf[7:], f.Reason)
 	return buf, nil
@@ -187,17 +310,21 @@ func (f FrameConnectionClose) ToBuf() ([]byte, error) {
 
 // FrameGoAway represents a GoAwayFrame
 type FrameGoAway struct {
-	ErrorCode, LastGoodStreamID uint64
-	Reason                      string
+	ErrorCode        ErrorCode
+	LastGoodStreamID uint64
+	Reason           string
 }
 
 // ToBuf serializes a FrameGoAway into a byte array
 func (f FrameGoAway) ToBuf() ([]byte, error) {
+	if len(f.Reason) > maxReasonLength {
+		return nil, qerr(QUIC_INVALID_GOAWAY_DATA, "goaway reason of %d bytes is too long", len(f.Reason))
+	}
 	buf := make([]byte, 1+4+4+2+len(f.Reason))
 	buf[0] = GoAwayFrame
-	binary.PutUvarint(buf[1:5], f.ErrorCode)
+	binary.PutUvarint(buf[1:5], uint64(f.ErrorCode))
 	binary.PutUvarint(buf[5:9], f.LastGoodStreamID)
-	binary.PutUvarint(buf[8:11], uint64(len(f.Reason)))
+	binary.PutUvarint(buf[9:11], uint64(len(f.Reason)))
 	copy(buf[11:], f.Reason)
 	return buf, nil
 }
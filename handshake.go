@@ -0,0 +1,174 @@
+package quic
+
+import (
+	"encoding/binary"
+	"io"
+	"sort"
+)
+
+// Tag is a four byte tag identifying a handshake message or one of its values.
+type Tag uint32
+
+// String returns the tag's characters.
+func (t Tag) String() string {
+	buf := make([]byte, 4)
+	binary.LittleEndian.PutUint32(buf, uint32(t))
+	for i := 3; i > 0 && buf[i] == 0; i-- {
+		buf = buf[:i]
+	}
+	return string(buf)
+}
+
+// Handshake message tags
+const (
+	TagCHLO Tag = 'C' | 'H'<<8 | 'L'<<16 | 'O'<<24
+	TagSHLO Tag = 'S' | 'H'<<8 | 'L'<<16 | 'O'<<24
+	TagREJ  Tag = 'R' | 'E'<<8 | 'J'<<16
+	TagSCFG Tag = 'S' | 'C'<<8 | 'F'<<16 | 'G'<<24
+)
+
+// Handshake value tags
+const (
+	// TagICSL is the idle connection state lifetime in seconds.
+	TagICSL Tag = 'I' | 'C'<<8 | 'S'<<16 | 'L'<<24
+	// TagMSPC is the maximum number of concurrent streams per connection.
+	TagMSPC Tag = 'M' | 'S'<<8 | 'P'<<16 | 'C'<<24
+	// TagSTK is a source-address token.
+	TagSTK Tag = 'S' | 'T'<<8 | 'K'<<16
+	// TagSCID is the ID of a server config.
+	TagSCID Tag = 'S' | 'C'<<8 | 'I'<<16 | 'D'<<24
+	// TagKEXS lists key exchange algorithms.
+	TagKEXS Tag = 'K' | 'E'<<8 | 'X'<<16 | 'S'<<24
+	// TagAEAD lists authenticated encryption algorithms.
+	TagAEAD Tag = 'A' | 'E'<<8 | 'A'<<16 | 'D'<<24
+	// TagPUBS holds a public key.
+	TagPUBS Tag = 'P' | 'U'<<8 | 'B'<<16 | 'S'<<24
+	// TagEXPY is the expiry of a server config in Unix seconds.
+	TagEXPY Tag = 'E' | 'X'<<8 | 'P'<<16 | 'Y'<<24
+	// TagORBT is the orbit of a server config, which clients copy into
+	// their nonces.
+	TagORBT Tag = 'O' | 'R'<<8 | 'B'<<16 | 'T'<<24
+	// TagNONC is the client nonce.
+	TagNONC Tag = 'N' | 'O'<<8 | 'N'<<16 | 'C'<<24
+	// TagCRT is the server's compressed certificate chain.
+	TagCRT Tag = 'C' | 'R'<<8 | 'T'<<16 | 0xff<<24
+	// TagCCS lists the hashes of the common certificate sets the client has.
+	TagCCS Tag = 'C' | 'C'<<8 | 'S'<<16
+	// TagCCRT lists the hashes of the certificates the client has cached.
+	TagCCRT Tag = 'C' | 'C'<<8 | 'R'<<16 | 'T'<<24
+	// TagSNI is the server name the client is connecting to.
+	TagSNI Tag = 'S' | 'N'<<8 | 'I'<<16
+	// TagPROF is the signature of the server config.
+	TagPROF Tag = 'P' | 'R'<<8 | 'O'<<16 | 'F'<<24
+)
+
+// maxHandshakeMessageSize limits the memory a peer can make us buffer.
+const maxHandshakeMessageSize = 16 * 1024
+
+// errHandshakeMessageTooLarge is returned for handshake messages over maxHandshakeMessageSize.
+var errHandshakeMessageTooLarge = &QuicError{
+	ErrorCode:    QUIC_CRYPTO_TOO_MANY_ENTRIES,
+	ErrorMessage: "handshake message too large",
+}
+
+// HandshakeMessage represents a crypto handshake message such as a CHLO
+type HandshakeMessage struct {
+	Tag    Tag
+	Values map[Tag][]byte
+}
+
+// NewHandshakeMessage returns an empty handshake message.
+func NewHandshakeMessage(tag Tag) *HandshakeMessage {
+	return &HandshakeMessage{Tag: tag, Values: map[Tag][]byte{}}
+}
+
+// SetUint32 sets a little endian uint32 value.
+func (m *HandshakeMessage) SetUint32(tag Tag, v uint32) {
+	buf := make([]byte, 4)
+	binary.LittleEndian.PutUint32(buf, v)
+	m.Values[tag] = buf
+}
+
+// GetUint32 returns a little endian uint32 value.
+func (m *HandshakeMessage) GetUint32(tag Tag) (uint32, bool) {
+	v, ok := m.Values[tag]
+	if !ok || len(v) != 4 {
+		return 0, false
+	}
+	return binary.LittleEndian.Uint32(v), true
+}
+
+// hasTag reports whether the tag list value of tag contains want.
+func (m *HandshakeMessage) hasTag(tag, want Tag) bool {
+	v := m.Values[tag]
+	for i := 0; i+4 <= len(v); i += 4 {
+		if Tag(binary.LittleEndian.Uint32(v[i:])) == want {
+			return true
+		}
+	}
+	return false
+}
+
+// ToBuf serializes a handshake message into a byte array
+func (m *HandshakeMessage) ToBuf() []byte {
+	tags := make([]Tag, 0, len(m.Values))
+	for tag := range m.Values {
+		tags = append(tags, tag)
+	}
+	sort.Slice(tags, func(i, j int) bool { return tags[i] < tags[j] })
+
+	buf := make([]byte, 8+8*len(tags))
+	binary.LittleEndian.PutUint32(buf[0:4], uint32(m.Tag))
+	binary.LittleEndian.PutUint16(buf[4:6], uint16(len(tags)))
+	// buf[6:8] is padding.
+	offset := uint32(0)
+	for i, tag := range tags {
+		offset += uint32(len(m.Values[tag]))
+		binary.LittleEndian.PutUint32(buf[8+8*i:], uint32(tag))
+		binary.LittleEndian.PutUint32(buf[12+8*i:], offset)
+	}
+	for _, tag := range tags {
+		buf = append(buf, m.Values[tag]...)
+	}
+	return buf
+}
+
+// ParseHandshakeMessage parses a handshake message from the start of buf and
+// returns it with the number of bytes it used. io.ErrUnexpectedEOF is
+// returned if buf doesn't hold the whole message yet.
+func ParseHandshakeMessage(buf []byte) (*HandshakeMessage, int, error) {
+	if len(buf) < 8 {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+	m := NewHandshakeMessage(Tag(binary.LittleEndian.Uint32(buf[0:4])))
+	numEntries := int(binary.LittleEndian.Uint16(buf[4:6]))
+	headerLen := 8 + 8*numEntries
+	if headerLen > maxHandshakeMessageSize {
+		return nil, 0, errHandshakeMessageTooLarge
+	}
+	if len(buf) < headerLen {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+	start := uint32(0)
+	var lastTag Tag
+	for i := 0; i < numEntries; i++ {
+		tag := Tag(binary.LittleEndian.Uint32(buf[8+8*i:]))
+		end := binary.LittleEndian.Uint32(buf[12+8*i:])
+		if i > 0 && tag <= lastTag {
+			return nil, 0, qerr(QUIC_CRYPTO_TAGS_OUT_OF_ORDER, "handshake message tags out of order")
+		}
+		if end < start {
+			return nil, 0, qerr(QUIC_CRYPTO_INVALID_VALUE_LENGTH, "invalid handshake message value length")
+		}
+		if headerLen+int(end) > maxHandshakeMessageSize {
+			return nil, 0, errHandshakeMessageTooLarge
+		}
+		if len(buf) < headerLen+int(end) {
+			return nil, 0, io.ErrUnexpectedEOF
+		}
+		m.Values[tag] = buf[headerLen+int(start) : headerLen+int(end)]
+		start = end
+		lastTag = tag
+	}
+	return m, headerLen + int(start), nil
+}
@@ -0,0 +1,337 @@
+package quic
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"sync"
+
+	"golang.org/x/net/http2/hpack"
+)
+
+// HTTP/2 frame types used on the headers stream.
+const (
+	http2FrameHeaders      = 0x1
+	http2FrameSettings     = 0x4
+	http2FramePushPromise  = 0x5
+	http2FrameContinuation = 0x9
+)
+
+// HTTP/2 frame flags used on the headers stream.
+const (
+	http2FlagEndStream  = 0x1
+	http2FlagEndHeaders = 0x4
+	http2FlagPadded     = 0x8
+	http2FlagPriority   = 0x20
+)
+
+// Headers stream constants
+const (
+	// http2FrameHeaderSize is the size of an HTTP/2 frame header.
+	http2FrameHeaderSize = 9
+	// maxHeadersFrameSize is the largest frame we send. Larger header blocks
+	// are continued in CONTINUATION frames.
+	maxHeadersFrameSize = 16 * 1024
+	// maxHeaderBlockSize limits the memory a peer can make us buffer for
+	// one header block.
+	maxHeaderBlockSize = 256 * 1024
+)
+
+// HeadersFrame is a header block sent on the headers stream, for the data
+// stream it belongs to.
+type HeadersFrame struct {
+	// StreamID is the data stream the headers are for.
+	StreamID uint64
+	// PromisedStreamID is the stream a PUSH_PROMISE reserves, 0 for
+	// HEADERS.
+	PromisedStreamID uint64
+	Headers          []hpack.HeaderField
+	// Fin is set if no data follows the headers on the data stream, e.g. a
+	// request without a body. The data stream is still closed as usual.
+	Fin bool
+	// Priority is the data stream's priority, if HasPriority is set.
+	Priority    uint8
+	HasPriority bool
+	// Stream is the data stream, nil if it has already finished. It is only
+	// set for received frames.
+	Stream *Stream
+}
+
+// HeadersStream carries HTTP header blocks for the session's data streams
+// on the reserved headers stream, as HTTP/2 HEADERS and PUSH_PROMISE frames
+// with HPACK compression.
+type HeadersStream struct {
+	session *Session
+	stream  *Stream
+
+	writeMu sync.Mutex
+	encoder *hpack.Encoder
+	encoded bytes.Buffer
+
+	readMu  sync.Mutex
+	decoder *hpack.Decoder
+	header  [http2FrameHeaderSize]byte
+}
+
+func newHeadersStream(session *Session, stream *Stream) *HeadersStream {
+	h := &HeadersStream{session: session, stream: stream}
+	h.encoder = hpack.NewEncoder(&h.encoded)
+	h.decoder = hpack.NewDecoder(4096, nil)
+	h.decoder.SetMaxStringLength(maxHeaderBlockSize)
+	return h
+}
+
+// HeadersStream returns the session's headers stream.
+func (s *Session) HeadersStream() *HeadersStream {
+	return s.headers
+}
+
+// WriteHeaders sends the headers of a data stream.
+func (h *HeadersStream) WriteHeaders(frame *HeadersFrame) error {
+	h.writeMu.Lock()
+	defer h.writeMu.Unlock()
+	block, err := h.encode(frame.Headers)
+	if err != nil {
+		return err
+	}
+	var flags byte
+	var prefix []byte
+	if frame.Fin {
+		flags |= http2FlagEndStream
+	}
+	if frame.HasPriority {
+		flags |= http2FlagPriority
+		// Exclusive dependency on the root, with the weight of the
+		// priority.
+		prefix = []byte{0x80, 0, 0, 0, byte(spdyPriorityToHTTP2Weight(frame.Priority) - 1)}
+	}
+	return h.writeBlock(http2FrameHeaders, flags, frame.StreamID, prefix, block)
+}
+
+// WritePushPromise reserves a stream for a response the server pushes
+// alongside the response on frame.StreamID. frame.Headers holds the request
+// the pushed response answers.
+func (h *HeadersStream) WritePushPromise(frame *HeadersFrame) error {
+	h.writeMu.Lock()
+	defer h.writeMu.Unlock()
+	block, err := h.encode(frame.Headers)
+	if err != nil {
+		return err
+	}
+	prefix := make([]byte, 4)
+	binary.BigEndian.PutUint32(prefix, uint32(frame.PromisedStreamID))
+	return h.writeBlock(http2FramePushPromise, 0, frame.StreamID, prefix, block)
+}
+
+// encode HPACK encodes a header block.
+func (h *HeadersStream) encode(headers []hpack.HeaderField) ([]byte, error) {
+	h.encoded.Reset()
+	for _, f := range headers {
+		if err := h.encoder.WriteField(f); err != nil {
+			return nil, err
+		}
+	}
+	return append([]byte(nil), h.encoded.Bytes()...), nil
+}
+
+// writeBlock writes a header block as a frame of type typ, followed by
+// CONTINUATION frames if it doesn't fit in one.
+func (h *HeadersStream) writeBlock(typ, flags byte, streamID uint64, prefix, block []byte) error {
+	var buf []byte
+	payload := append(prefix, block...)
+	for first := true; first || len(payload) > 0; first = false {
+		n := len(payload)
+		if n > maxHeadersFrameSize {
+			n = maxHeadersFrameSize
+		}
+		frameFlags := byte(0)
+		frameType := byte(http2FrameContinuation)
+		if first {
+			frameType = typ
+			frameFlags = flags
+		}
+		if n == len(payload) {
+			frameFlags |= http2FlagEndHeaders
+		}
+		buf = appendHTTP2FrameHeader(buf, n, frameType, frameFlags, streamID)
+		buf = append(buf, payload[:n]...)
+		payload = payload[n:]
+	}
+	_, err := h.stream.Write(buf)
+	return err
+}
+
+// appendHTTP2FrameHeader appends an HTTP/2 frame header to buf.
+func appendHTTP2FrameHeader(buf []byte, length int, typ, flags byte, streamID uint64) []byte {
+	return append(buf,
+		byte(length>>16), byte(length>>8), byte(length),
+		typ, flags,
+		byte(streamID>>24)&0x7f, byte(streamID>>16), byte(streamID>>8), byte(streamID))
+}
+
+// ReadHeaders reads the next header block from the peer. Malformed frames
+// close the session with QUIC_INVALID_HEADERS_STREAM_DATA, and header blocks
+// that fail to decompress with QUIC_DECOMPRESSION_FAILURE.
+func (h *HeadersStream) ReadHeaders() (*HeadersFrame, error) {
+	h.readMu.Lock()
+	defer h.readMu.Unlock()
+	frame, err := h.readHeaders()
+	if err == nil {
+		err = h.resolveStreams(frame)
+	}
+	if err != nil {
+		if qe, ok := err.(*QuicError); ok {
+			h.session.CloseWithError(qe.ErrorCode, qe.ErrorMessage)
+		}
+		return nil, err
+	}
+	if frame.HasPriority && frame.Stream != nil {
+		frame.Stream.SetPriority(frame.Priority)
+	}
+	return frame, nil
+}
+
+// resolveStreams sets the data stream of a frame, opening it if the peer
+// hasn't sent on it yet, and opens the stream a PUSH_PROMISE reserves.
+func (h *HeadersStream) resolveStreams(frame *HeadersFrame) error {
+	done := make(chan error, 1)
+	h.session.do(func() {
+		var err error
+		frame.Stream, err = h.session.getOrOpenPeerStream(frame.StreamID)
+		if err == nil && frame.PromisedStreamID != 0 {
+			_, err = h.session.getOrOpenPeerStream(frame.PromisedStreamID)
+		}
+		done <- err
+	})
+	select {
+	case err := <-done:
+		return err
+	case <-h.session.closeChan:
+		return h.session.closeErr
+	}
+}
+
+// readHeaders reads frames up to the end of the next header block.
+func (h *HeadersStream) readHeaders() (*HeadersFrame, error) {
+	for {
+		typ, flags, streamID, payload, err := h.readFrame()
+		if err != nil {
+			return nil, err
+		}
+		frame := &HeadersFrame{StreamID: streamID, Fin: flags&http2FlagEndStream > 0}
+		switch typ {
+		case http2FrameSettings:
+			// We use the default HPACK table size and frame size.
+			continue
+		case http2FrameHeaders:
+			if payload, err = stripPadding(flags, payload); err != nil {
+				return nil, err
+			}
+			if flags&http2FlagPriority > 0 {
+				if len(payload) < 5 {
+					return nil, errInvalidHeadersFrame
+				}
+				frame.HasPriority = true
+				frame.Priority = http2WeightToSPDYPriority(int(payload[4]) + 1)
+				payload = payload[5:]
+			}
+		case http2FramePushPromise:
+			if payload, err = stripPadding(flags, payload); err != nil {
+				return nil, err
+			}
+			if len(payload) < 4 {
+				return nil, errInvalidHeadersFrame
+			}
+			frame.PromisedStreamID = uint64(binary.BigEndian.Uint32(payload) & 0x7fffffff)
+			frame.Fin = false
+			payload = payload[4:]
+		default:
+			return nil, qerr(QUIC_INVALID_HEADERS_STREAM_DATA, "unexpected frame type %d on headers stream", typ)
+		}
+		if frame.StreamID == 0 || frame.StreamID == cryptoStreamID || frame.StreamID == headersStreamID {
+			return nil, qerr(QUIC_INVALID_HEADERS_STREAM_DATA, "headers for stream %d", frame.StreamID)
+		}
+
+		block := payload
+		for flags&http2FlagEndHeaders == 0 {
+			var contType byte
+			var contStreamID uint64
+			contType, flags, contStreamID, payload, err = h.readFrame()
+			if err != nil {
+				return nil, err
+			}
+			if contType != http2FrameContinuation || contStreamID != frame.StreamID {
+				return nil, qerr(QUIC_INVALID_HEADERS_STREAM_DATA, "header block interrupted by frame type %d", contType)
+			}
+			if len(block)+len(payload) > maxHeaderBlockSize {
+				return nil, qerr(QUIC_INVALID_HEADERS_STREAM_DATA, "header block too large")
+			}
+			block = append(block, payload...)
+		}
+		frame.Headers, err = h.decoder.DecodeFull(block)
+		if err != nil {
+			return nil, qerr(QUIC_DECOMPRESSION_FAILURE, "%v", err)
+		}
+		return frame, nil
+	}
+}
+
+// errInvalidHeadersFrame is returned for truncated frames.
+var errInvalidHeadersFrame = &QuicError{
+	ErrorCode:    QUIC_INVALID_HEADERS_STREAM_DATA,
+	ErrorMessage: "invalid frame on headers stream",
+}
+
+// readFrame reads an HTTP/2 frame from the headers stream.
+func (h *HeadersStream) readFrame() (typ, flags byte, streamID uint64, payload []byte, err error) {
+	if _, err := io.ReadFull(h.stream, h.header[:]); err != nil {
+		return 0, 0, 0, nil, h.readError(err)
+	}
+	length := int(h.header[0])<<16 | int(h.header[1])<<8 | int(h.header[2])
+	if length > maxHeaderBlockSize {
+		return 0, 0, 0, nil, qerr(QUIC_INVALID_HEADERS_STREAM_DATA, "frame of %d bytes on headers stream", length)
+	}
+	payload = make([]byte, length)
+	if _, err := io.ReadFull(h.stream, payload); err != nil {
+		return 0, 0, 0, nil, h.readError(err)
+	}
+	streamID = uint64(binary.BigEndian.Uint32(h.header[5:]) & 0x7fffffff)
+	return h.header[3], h.header[4], streamID, payload, nil
+}
+
+// readError returns the error for a failed read from the headers stream. The
+// peer must not close it, so EOF is a protocol error.
+func (h *HeadersStream) readError(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return qerr(QUIC_INVALID_HEADERS_STREAM_DATA, "headers stream closed")
+	}
+	return err
+}
+
+// stripPadding removes the padding of a padded HEADERS or PUSH_PROMISE frame.
+func stripPadding(flags byte, payload []byte) ([]byte, error) {
+	if flags&http2FlagPadded == 0 {
+		return payload, nil
+	}
+	if len(payload) < 1 || int(payload[0]) >= len(payload) {
+		return nil, errInvalidHeadersFrame
+	}
+	return payload[1 : len(payload)-int(payload[0])], nil
+}
+
+// spdyPriorityToHTTP2Weight maps a priority from 0 (highest) to 7 (lowest)
+// to an HTTP/2 weight from 256 to 1.
+func spdyPriorityToHTTP2Weight(priority uint8) int {
+	if priority > lowestPriority {
+		priority = lowestPriority
+	}
+	const steps = 255.9 / 7
+	return int(steps*float64(lowestPriority-priority)) + 1
+}
+
+// http2WeightToSPDYPriority maps an HTTP/2 weight from 1 to 256 to a
+// priority from 7 (lowest) to 0 (highest).
+func http2WeightToSPDYPriority(weight int) uint8 {
+	const steps = 255.9 / 7
+	return uint8(7 - float64(weight-1)/steps)
+}
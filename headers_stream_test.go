@@ -0,0 +1,264 @@
+package quic
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/http2/hpack"
+)
+
+// headersFeed delivers bytes on a session's headers stream as if the peer
+// sent them.
+type headersFeed struct {
+	t      *testing.T
+	s      *Session
+	offset uint64
+}
+
+func (f *headersFeed) send(data []byte, fin bool) {
+	var err error
+	doSync(f.s, func() {
+		err = f.s.handleStreamFrame(FrameStream{StreamID: headersStreamID, Offset: f.offset, Data: string(data), Fin: fin})
+	})
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	f.offset += uint64(len(data))
+}
+
+// writtenHeaders returns and forgets the data queued on a session's headers
+// stream. Nothing is sent before the handshake completes, so it is all
+// still queued.
+func writtenHeaders(s *Session) []byte {
+	var data []byte
+	doSync(s, func() {
+		stream := s.headers.stream
+		stream.mu.Lock()
+		data = stream.dataForWriting
+		stream.dataForWriting = nil
+		stream.mu.Unlock()
+	})
+	return data
+}
+
+// newTestClientSession returns a client session writing to a recordingConn.
+// It is closed when the test ends.
+func newTestClientSession(t *testing.T) *Session {
+	addr := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 4433}
+	s := newSession(&recordingConn{}, addr, 1, &Config{}, nil, nil)
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+// http2Frame returns a serialized HTTP/2 frame.
+func http2Frame(typ, flags byte, streamID uint64, payload []byte) []byte {
+	return append(appendHTTP2FrameHeader(nil, len(payload), typ, flags, streamID), payload...)
+}
+
+// hpackBlock returns the HPACK encoding of fields with a fresh encoder.
+func hpackBlock(t *testing.T, fields ...hpack.HeaderField) []byte {
+	var buf bytes.Buffer
+	enc := hpack.NewEncoder(&buf)
+	for _, f := range fields {
+		if err := enc.WriteField(f); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return buf.Bytes()
+}
+
+func TestHeadersStreamRoundTrip(t *testing.T) {
+	client := newTestClientSession(t)
+	server, _ := newTestSession(t)
+	feed := &headersFeed{t: t, s: server}
+	// The large block below doesn't fit the default windows.
+	doSync(server, func() {
+		server.headers.stream.flowController.receiveWindow = 1 << 20
+		server.connFlowController.receiveWindow = 1 << 20
+	})
+
+	frames := []*HeadersFrame{
+		{StreamID: 5, Headers: []hpack.HeaderField{
+			{Name: ":method", Value: "GET"},
+			{Name: ":path", Value: "/"},
+		}, Fin: true, Priority: 2, HasPriority: true},
+		// Repeated headers come from the dynamic table.
+		{StreamID: 7, Headers: []hpack.HeaderField{
+			{Name: ":method", Value: "GET"},
+			{Name: ":path", Value: "/"},
+		}},
+		// Too large for one frame, so CONTINUATION frames follow.
+		{StreamID: 9, Headers: []hpack.HeaderField{
+			{Name: ":method", Value: "POST"},
+			{Name: "x-large", Value: strings.Repeat("a", 3*maxHeadersFrameSize)},
+		}},
+	}
+	for _, frame := range frames {
+		if err := client.HeadersStream().WriteHeaders(frame); err != nil {
+			t.Fatal(err)
+		}
+		data := writtenHeaders(client)
+		if frame.StreamID == 9 {
+			if data[3] != http2FrameHeaders || data[http2FrameHeaderSize+maxHeadersFrameSize+3] != http2FrameContinuation {
+				t.Errorf("large header block isn't split into CONTINUATION frames")
+			}
+		}
+		feed.send(data, false)
+
+		got, err := server.HeadersStream().ReadHeaders()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.StreamID != frame.StreamID || got.Fin != frame.Fin || got.HasPriority != frame.HasPriority || got.Priority != frame.Priority {
+			t.Errorf("ReadHeaders() = %+v, want %+v", got, frame)
+		}
+		if !reflect.DeepEqual(got.Headers, frame.Headers) {
+			t.Errorf("ReadHeaders() headers = %v, want %v", got.Headers, frame.Headers)
+		}
+		if got.Stream == nil || got.Stream.StreamID() != frame.StreamID {
+			t.Errorf("ReadHeaders() didn't open stream %d", frame.StreamID)
+		} else if frame.HasPriority && got.Stream.Priority() != frame.Priority {
+			t.Errorf("stream priority = %d, want %d", got.Stream.Priority(), frame.Priority)
+		}
+	}
+}
+
+func TestHeadersStreamPushPromise(t *testing.T) {
+	server, _ := newTestSession(t)
+	client := newTestClientSession(t)
+	feed := &headersFeed{t: t, s: client}
+	if _, err := client.OpenStream(); err != nil {
+		t.Fatal(err)
+	}
+
+	promise := &HeadersFrame{StreamID: 5, PromisedStreamID: 2, Headers: []hpack.HeaderField{
+		{Name: ":method", Value: "GET"},
+		{Name: ":path", Value: "/style.css"},
+	}}
+	if err := server.HeadersStream().WritePushPromise(promise); err != nil {
+		t.Fatal(err)
+	}
+	feed.send(writtenHeaders(server), false)
+	got, err := client.HeadersStream().ReadHeaders()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.StreamID != 5 || got.PromisedStreamID != 2 || !reflect.DeepEqual(got.Headers, promise.Headers) {
+		t.Errorf("ReadHeaders() = %+v, want %+v", got, promise)
+	}
+	var opened bool
+	doSync(client, func() { _, opened = client.streams[2] })
+	if !opened {
+		t.Error("the promised stream wasn't opened")
+	}
+}
+
+func TestHeadersStreamParsing(t *testing.T) {
+	fields := []hpack.HeaderField{
+		{Name: ":method", Value: "GET"},
+		{Name: ":path", Value: "/index.html"},
+		{Name: "x-test", Value: "value"},
+	}
+	block := hpackBlock(t, fields...)
+	priority := []byte{0x80, 0, 0, 0, byte(spdyPriorityToHTTP2Weight(lowestPriority) - 1)}
+	tests := []struct {
+		name     string
+		data     []byte
+		priority uint8
+		fin      bool
+	}{
+		{"padded", http2Frame(http2FrameHeaders, http2FlagEndHeaders|http2FlagPadded, 5,
+			append(append([]byte{3}, block...), 0, 0, 0)), 0, false},
+		{"priority", http2Frame(http2FrameHeaders, http2FlagEndHeaders|http2FlagPriority|http2FlagEndStream, 5,
+			append(append([]byte(nil), priority...), block...)), lowestPriority, true},
+		{"padded with priority", http2Frame(http2FrameHeaders, http2FlagEndHeaders|http2FlagPriority|http2FlagPadded, 5,
+			append(append(append([]byte{1}, priority...), block...), 0)), lowestPriority, false},
+		{"continuation", append(append(
+			http2Frame(http2FrameHeaders, 0, 5, block[:2]),
+			http2Frame(http2FrameContinuation, 0, 5, block[2:4])...),
+			http2Frame(http2FrameContinuation, http2FlagEndHeaders, 5, block[4:])...), 0, false},
+		{"after settings", append(
+			http2Frame(http2FrameSettings, 0, 0, make([]byte, 6)),
+			http2Frame(http2FrameHeaders, http2FlagEndHeaders, 5, block)...), 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _ := newTestSession(t)
+			(&headersFeed{t: t, s: s}).send(tt.data, false)
+			got, err := s.HeadersStream().ReadHeaders()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got.Headers, fields) {
+				t.Errorf("headers = %v, want %v", got.Headers, fields)
+			}
+			if got.Priority != tt.priority || got.Fin != tt.fin {
+				t.Errorf("priority, fin = %d, %t, want %d, %t", got.Priority, got.Fin, tt.priority, tt.fin)
+			}
+		})
+	}
+}
+
+func TestHeadersStreamErrors(t *testing.T) {
+	block := hpackBlock(t, hpack.HeaderField{Name: ":method", Value: "GET"})
+	tests := []struct {
+		name string
+		data []byte
+		fin  bool
+		code ErrorCode
+	}{
+		{"data frame", http2Frame(0x0, 0, 5, []byte("body")), false, QUIC_INVALID_HEADERS_STREAM_DATA},
+		{"headers for stream 0", http2Frame(http2FrameHeaders, http2FlagEndHeaders, 0, block), false, QUIC_INVALID_HEADERS_STREAM_DATA},
+		{"headers for the crypto stream", http2Frame(http2FrameHeaders, http2FlagEndHeaders, cryptoStreamID, block), false, QUIC_INVALID_HEADERS_STREAM_DATA},
+		{"headers for the headers stream", http2Frame(http2FrameHeaders, http2FlagEndHeaders, headersStreamID, block), false, QUIC_INVALID_HEADERS_STREAM_DATA},
+		{"padding too long", http2Frame(http2FrameHeaders, http2FlagEndHeaders|http2FlagPadded, 5, append([]byte{byte(len(block) + 1)}, block...)), false, QUIC_INVALID_HEADERS_STREAM_DATA},
+		{"truncated priority", http2Frame(http2FrameHeaders, http2FlagEndHeaders|http2FlagPriority, 5, []byte{0x80, 0}), false, QUIC_INVALID_HEADERS_STREAM_DATA},
+		{"truncated push promise", http2Frame(http2FramePushPromise, http2FlagEndHeaders, 5, []byte{0, 0}), false, QUIC_INVALID_HEADERS_STREAM_DATA},
+		{"interrupted header block", append(
+			http2Frame(http2FrameHeaders, 0, 5, block),
+			http2Frame(http2FrameHeaders, http2FlagEndHeaders, 7, block)...), false, QUIC_INVALID_HEADERS_STREAM_DATA},
+		{"continuation on another stream", append(
+			http2Frame(http2FrameHeaders, 0, 5, block),
+			http2Frame(http2FrameContinuation, http2FlagEndHeaders, 7, nil)...), false, QUIC_INVALID_HEADERS_STREAM_DATA},
+		{"frame too large", appendHTTP2FrameHeader(nil, maxHeaderBlockSize+1, http2FrameHeaders, 0, 5), false, QUIC_INVALID_HEADERS_STREAM_DATA},
+		{"stream closed", nil, true, QUIC_INVALID_HEADERS_STREAM_DATA},
+		{"stream closed mid-frame", http2Frame(http2FrameHeaders, http2FlagEndHeaders, 5, block)[:5], true, QUIC_INVALID_HEADERS_STREAM_DATA},
+		// An index into the empty dynamic table.
+		{"bad hpack block", http2Frame(http2FrameHeaders, http2FlagEndHeaders, 5, []byte{0xbe}), false, QUIC_DECOMPRESSION_FAILURE},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _ := newTestSession(t)
+			(&headersFeed{t: t, s: s}).send(tt.data, tt.fin)
+			if _, err := s.HeadersStream().ReadHeaders(); !errors.Is(err, tt.code) {
+				t.Fatalf("ReadHeaders() = %v, want %s", err, tt.code)
+			}
+			<-s.closeChan
+			if err := s.Err(); !errors.Is(err, tt.code) {
+				t.Errorf("session closed with %v, want %s", err, tt.code)
+			}
+		})
+	}
+}
+
+func TestHTTP2WeightMapping(t *testing.T) {
+	for p := uint8(highestPriority); p <= lowestPriority; p++ {
+		weight := spdyPriorityToHTTP2Weight(p)
+		if weight < 1 || weight > 256 {
+			t.Errorf("priority %d maps to weight %d", p, weight)
+		}
+		if got := http2WeightToSPDYPriority(weight); got != p {
+			t.Errorf("priority %d maps to weight %d and back to %d", p, weight, got)
+		}
+	}
+	if w := spdyPriorityToHTTP2Weight(highestPriority); w != 256 {
+		t.Errorf("highest priority maps to weight %d, want 256", w)
+	}
+	if w := spdyPriorityToHTTP2Weight(lowestPriority); w != 1 {
+		t.Errorf("lowest priority maps to weight %d, want 1", w)
+	}
+}
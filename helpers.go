@@ -2,15 +2,57 @@ package quic
 
 import (
 	"encoding/binary"
-	"log"
 	"math"
 )
 
+// UFloat16 constants
+const (
+	ufloat16MantissaBits          = 11
+	ufloat16MantissaEffectiveBits = 12
+	ufloat16MaxValue              = 0x3FFC0000000
+)
+
 // FloatToUFloat16 converts a float64 into a 16 bit unsigned float with 11 explicit bits of mantissa and 5 bits of explicit exponent byte array.
 func FloatToUFloat16(a float64) []byte {
-	bits := math.Float64bits(a)
-	buf := make([]byte, 8)
-	binary.PutUvarint(buf, bits)
-	log.Println(buf)
+	buf := make([]byte, 2)
+	binary.LittleEndian.PutUint16(buf, uint64ToUFloat16(uint64(math.Max(a, 0))))
 	return buf
 }
+
+// uint64ToUFloat16 encodes a value as a 16 bit unsigned float, saturating at the maximum value.
+func uint64ToUFloat16(value uint64) uint16 {
+	if value < 1<<ufloat16MantissaEffectiveBits {
+		// Fast path: the value fits in the denormalized range.
+		return uint16(value)
+	}
+	if value >= ufloat16MaxValue {
+		return math.MaxUint16
+	}
+	exponent := uint64(0)
+	for offset := uint64(16); offset > 0; offset /= 2 {
+		if value >= 1<<(ufloat16MantissaBits+offset) {
+			exponent += offset
+			value >>= offset
+		}
+	}
+	// value has its implicit leading bit set, which bumps the exponent by one.
+	return uint16(value + exponent<<ufloat16MantissaBits)
+}
+
+// ufloat16ToUint64 decodes a 16 bit unsigned float.
+func ufloat16ToUint64(f uint16) uint64 {
+	value := uint64(f)
+	exponent := value >> ufloat16MantissaBits
+	if exponent == 0 {
+		return value
+	}
+	mantissa := value&(1<<ufloat16MantissaBits-1) | 1<<ufloat16MantissaBits
+	return mantissa << (exponent - 1)
+}
+
+// uint64Slice attaches the methods of sort.Interface to []uint64.
+type uint64Slice []uint64
+
+func (s uint64Slice) Len() int           { return len(s) }
+func (s uint64Slice) Less(i, j int) bool { return s[i] < s[j] }
+func (s uint64Slice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
@@ -0,0 +1,383 @@
+package quic
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/http2/hpack"
+)
+
+// errResponseBodyClosed is returned when reading a response body after Close.
+var errResponseBodyClosed = errors.New("quic: read on closed response body")
+
+// RoundTripper is an http.RoundTripper sending requests over QUIC. It keeps
+// one session per host and sends each request on a stream of its own, so it
+// can be used as the Transport of an http.Client.
+type RoundTripper struct {
+	// TLSConfig verifies the server certificates. It overrides the
+	// TLSConfig of QuicConfig.
+	TLSConfig *tls.Config
+	// QuicConfig configures the QUIC sessions, it may be nil.
+	QuicConfig *Config
+
+	mu      sync.Mutex
+	clients map[string]*clientSession
+}
+
+// clientSession is a session of a RoundTripper. Responses are read from its
+// headers stream and handed to the requests waiting for them.
+type clientSession struct {
+	// dialed is closed once the session is dialed, or dialing failed with
+	// err.
+	dialed  chan struct{}
+	session *Session
+	err     error
+
+	mu        sync.Mutex
+	responses map[uint64]chan *HeadersFrame
+	// done is closed when the headers stream fails, with the error in
+	// readErr.
+	done    chan struct{}
+	readErr error
+}
+
+// RoundTrip sends a request and returns its response. The response body
+// reads from the request's stream.
+func (rt *RoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL == nil || req.URL.Scheme != "https" {
+		closeRequestBody(req)
+		return nil, errors.New("quic: unsupported protocol scheme, only https is supported")
+	}
+	if req.URL.Host == "" {
+		closeRequestBody(req)
+		return nil, errors.New("quic: no host in request URL")
+	}
+	addr := req.URL.Host
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(strings.Trim(addr, "[]"), "443")
+	}
+
+	client, stream, err := rt.openStream(addr)
+	if err != nil {
+		closeRequestBody(req)
+		return nil, err
+	}
+	return client.roundTrip(req, stream)
+}
+
+// openStream opens a stream on the session to addr, dialing a new session
+// if there is none or the server is going away.
+func (rt *RoundTripper) openStream(addr string) (*clientSession, *Stream, error) {
+	for {
+		client, err := rt.getClient(addr)
+		if err != nil {
+			return nil, nil, err
+		}
+		stream, err := client.session.OpenStream()
+		if errors.Is(err, QUIC_PEER_GOING_AWAY) {
+			rt.removeClient(addr, client)
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		return client, stream, nil
+	}
+}
+
+// getClient returns the session to addr, dialing it if needed.
+func (rt *RoundTripper) getClient(addr string) (*clientSession, error) {
+	rt.mu.Lock()
+	client, ok := rt.clients[addr]
+	if ok {
+		select {
+		case <-client.dialed:
+			if client.err != nil || client.session.Err() != nil {
+				ok = false
+			}
+		default:
+		}
+	}
+	if !ok {
+		client = &clientSession{
+			dialed:    make(chan struct{}),
+			responses: map[uint64]chan *HeadersFrame{},
+			done:      make(chan struct{}),
+		}
+		if rt.clients == nil {
+			rt.clients = map[string]*clientSession{}
+		}
+		rt.clients[addr] = client
+		go client.dial(addr, rt.config())
+	}
+	rt.mu.Unlock()
+
+	<-client.dialed
+	if client.err != nil {
+		rt.removeClient(addr, client)
+		return nil, client.err
+	}
+	return client, nil
+}
+
+// removeClient stops using the session to addr for new requests.
+func (rt *RoundTripper) removeClient(addr string, client *clientSession) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	if rt.clients[addr] == client {
+		delete(rt.clients, addr)
+	}
+}
+
+// config returns the config for the round tripper's sessions.
+func (rt *RoundTripper) config() *Config {
+	config := &Config{}
+	if rt.QuicConfig != nil {
+		*config = *rt.QuicConfig
+	}
+	if rt.TLSConfig != nil {
+		config.TLSConfig = rt.TLSConfig
+	}
+	return config
+}
+
+// Close closes all sessions.
+func (rt *RoundTripper) Close() error {
+	rt.mu.Lock()
+	clients := rt.clients
+	rt.clients = nil
+	rt.mu.Unlock()
+	for _, client := range clients {
+		<-client.dialed
+		if client.err == nil {
+			client.session.Close()
+		}
+	}
+	return nil
+}
+
+// dial opens the session and starts reading responses.
+func (c *clientSession) dial(addr string, config *Config) {
+	defer close(c.dialed)
+	c.session, c.err = Dial(addr, config)
+	if c.err != nil {
+		return
+	}
+	go func() {
+		// Pushed streams are found through the headers stream, they don't
+		// need accepting.
+		for {
+			if _, err := c.session.AcceptStream(); err != nil {
+				return
+			}
+		}
+	}()
+	go c.readResponses()
+}
+
+// readResponses hands the header blocks read from the headers stream to the
+// requests waiting for them.
+func (c *clientSession) readResponses() {
+	headers := c.session.HeadersStream()
+	for {
+		frame, err := headers.ReadHeaders()
+		if err != nil {
+			c.mu.Lock()
+			c.readErr = err
+			close(c.done)
+			c.mu.Unlock()
+			return
+		}
+		if frame.PromisedStreamID != 0 {
+			// Server push isn't supported.
+			continue
+		}
+		c.mu.Lock()
+		response, ok := c.responses[frame.StreamID]
+		delete(c.responses, frame.StreamID)
+		c.mu.Unlock()
+		if ok {
+			response <- frame
+		}
+	}
+}
+
+// roundTrip sends a request on stream and waits for its response.
+func (c *clientSession) roundTrip(req *http.Request, stream *Stream) (*http.Response, error) {
+	response := make(chan *HeadersFrame, 1)
+	c.mu.Lock()
+	c.responses[stream.StreamID()] = response
+	c.mu.Unlock()
+
+	hasBody := req.Body != nil && req.Body != http.NoBody
+	frame := &HeadersFrame{
+		StreamID: stream.StreamID(),
+		Headers:  requestHeaders(req),
+		Fin:      !hasBody,
+	}
+	if err := c.session.HeadersStream().WriteHeaders(frame); err != nil {
+		c.cancel(stream, err)
+		closeRequestBody(req)
+		return nil, err
+	}
+	if hasBody {
+		go c.writeBody(req, stream)
+	} else {
+		stream.Close()
+	}
+
+	select {
+	case frame := <-response:
+		resp, err := newResponse(req, frame)
+		if err != nil {
+			c.session.CloseWithError(QUIC_INVALID_HEADERS_STREAM_DATA, err.Error())
+			return nil, err
+		}
+		return resp, nil
+	case <-c.done:
+		c.cancel(stream, c.readErr)
+		return nil, c.readErr
+	case <-req.Context().Done():
+		err := req.Context().Err()
+		c.cancel(stream, err)
+		return nil, err
+	}
+}
+
+// writeBody sends the request body on stream and closes it. The stream is
+// reset if the body can't be read, so its end isn't mistaken for the end of
+// the body. If the server stopped reading the body, its response is still
+// read.
+func (c *clientSession) writeBody(req *http.Request, stream *Stream) {
+	_, err := io.Copy(stream, req.Body)
+	req.Body.Close()
+	if errors.Is(err, QUIC_STREAM_NO_ERROR) {
+		return
+	}
+	if err != nil {
+		c.cancel(stream, err)
+		return
+	}
+	stream.Close()
+}
+
+// cancel stops waiting for the response on stream and resets it.
+func (c *clientSession) cancel(stream *Stream, err error) {
+	c.mu.Lock()
+	delete(c.responses, stream.StreamID())
+	c.mu.Unlock()
+	stream.reset(err)
+}
+
+// requestHeaders returns the header block of a request. Connection specific
+// headers have no meaning in QUIC and are left out.
+func requestHeaders(req *http.Request) []hpack.HeaderField {
+	method := req.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+	authority := req.Host
+	if authority == "" {
+		authority = req.URL.Host
+	}
+	fields := []hpack.HeaderField{
+		{Name: ":method", Value: method},
+		{Name: ":scheme", Value: req.URL.Scheme},
+		{Name: ":authority", Value: authority},
+		{Name: ":path", Value: req.URL.RequestURI()},
+	}
+	for name, values := range req.Header {
+		name = strings.ToLower(name)
+		switch name {
+		case "host", "connection", "keep-alive", "proxy-connection", "transfer-encoding", "upgrade", "content-length":
+			continue
+		}
+		for _, v := range values {
+			fields = append(fields, hpack.HeaderField{Name: name, Value: v})
+		}
+	}
+	if req.ContentLength > 0 {
+		fields = append(fields, hpack.HeaderField{Name: "content-length", Value: strconv.FormatInt(req.ContentLength, 10)})
+	}
+	return fields
+}
+
+// newResponse returns the response in a header block, with the data stream
+// as its body.
+func newResponse(req *http.Request, frame *HeadersFrame) (*http.Response, error) {
+	resp := &http.Response{
+		Proto:         "HTTP/2.0",
+		ProtoMajor:    2,
+		Header:        http.Header{},
+		Body:          http.NoBody,
+		ContentLength: -1,
+		Request:       req,
+	}
+	for _, f := range frame.Headers {
+		if f.Name == ":status" {
+			status, err := strconv.Atoi(f.Value)
+			if err != nil || status < 100 || status > 999 {
+				return nil, fmt.Errorf("quic: invalid response status %q", f.Value)
+			}
+			resp.StatusCode = status
+			resp.Status = f.Value + " " + http.StatusText(status)
+			continue
+		}
+		if strings.HasPrefix(f.Name, ":") {
+			continue
+		}
+		resp.Header.Add(http.CanonicalHeaderKey(f.Name), f.Value)
+	}
+	if resp.StatusCode == 0 {
+		return nil, errors.New("quic: response without :status")
+	}
+	if cl := resp.Header.Get("Content-Length"); cl != "" {
+		if n, err := strconv.ParseInt(cl, 10, 64); err == nil && n >= 0 {
+			resp.ContentLength = n
+		}
+	}
+	noBody := resp.StatusCode == http.StatusNoContent || resp.StatusCode == http.StatusNotModified
+	if frame.Fin || noBody || frame.Stream == nil || req.Method == http.MethodHead {
+		if frame.Fin || noBody {
+			resp.ContentLength = 0
+		}
+		if frame.Stream != nil && !frame.Fin {
+			// Nothing will read what the server sends.
+			frame.Stream.reset(errResponseBodyClosed)
+		}
+		return resp, nil
+	}
+	resp.Body = &responseBody{stream: frame.Stream}
+	return resp, nil
+}
+
+// responseBody reads a response body from its data stream.
+type responseBody struct {
+	stream *Stream
+}
+
+func (b *responseBody) Read(p []byte) (int, error) {
+	return b.stream.Read(p)
+}
+
+// Close stops reading the body. If the server hasn't sent all of it, the
+// stream is reset rather than read to the end.
+func (b *responseBody) Close() error {
+	b.stream.reset(errResponseBodyClosed)
+	return nil
+}
+
+// closeRequestBody closes the body of a request that won't be sent, as
+// http.RoundTripper requires.
+func closeRequestBody(req *http.Request) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+}
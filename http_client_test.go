@@ -0,0 +1,117 @@
+package quic
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// testTLSConfig returns a server TLS config with the example certificate.
+func testTLSConfig(t *testing.T) *tls.Config {
+	cert, err := tls.LoadX509KeyPair("example/keys/cert.pem", "example/keys/key.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &tls.Config{Certificates: []tls.Certificate{cert}}
+}
+
+// startTestServer serves handler over QUIC on a local port and returns the
+// server and its base URL.
+func startTestServer(t *testing.T, handler http.Handler) (*Server, string) {
+	server := &Server{Handler: handler}
+	return server, serveTest(t, server)
+}
+
+// serveTest serves with server on a local port, using the example
+// certificate, and returns its base URL.
+func serveTest(t *testing.T, server *Server) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.TLSConfig = testTLSConfig(t)
+	l, err := NewListener(conn, server.config())
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(l)
+	t.Cleanup(func() { server.Close() })
+	return "https://" + conn.LocalAddr().String()
+}
+
+// testRoundTripper returns a RoundTripper that trusts any certificate, the
+// example one having expired.
+func testRoundTripper(t *testing.T) *RoundTripper {
+	rt := &RoundTripper{TLSConfig: &tls.Config{InsecureSkipVerify: true}}
+	t.Cleanup(func() { rt.Close() })
+	return rt
+}
+
+func TestRoundTripper(t *testing.T) {
+	_, url := startTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Method", r.Method)
+		io.Copy(w, r.Body)
+	}))
+	client := &http.Client{Transport: testRoundTripper(t)}
+
+	body := bytes.Repeat([]byte("0123456789"), 10000)
+	resp, err := client.Post(url+"/echo", "text/plain", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("X-Method") != "POST" {
+		t.Errorf("got %s with X-Method %q", resp.Status, resp.Header.Get("X-Method"))
+	}
+	if !bytes.Equal(got, body) {
+		t.Errorf("echoed %d bytes, want %d", len(got), len(body))
+	}
+}
+
+func TestRoundTripperAbortedBodies(t *testing.T) {
+	// Each response is larger than the connection flow control window, so
+	// the session stalls unless aborted bodies give their window back.
+	const size = 4 * defaultConnectionWindow
+	server, url := startTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", strconv.Itoa(size))
+		w.Write(make([]byte, size))
+	}))
+	client := &http.Client{Transport: testRoundTripper(t), Timeout: 5 * time.Second}
+
+	for i := 0; i < 10; i++ {
+		resp, err := client.Get(url)
+		if err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+		if _, err := resp.Body.Read(make([]byte, 1)); err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+		resp.Body.Close()
+	}
+	resp, err := client.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if err != nil || n != size {
+		t.Fatalf("read %d bytes, %v", n, err)
+	}
+
+	// The reset streams finished on the server too.
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown() = %v", err)
+	}
+}
@@ -0,0 +1,361 @@
+package quic
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/http2/hpack"
+)
+
+// Server serves HTTP requests over QUIC. Each request arrives as a header
+// block on the headers stream followed by its body on a data stream, and the
+// response is sent the same way.
+type Server struct {
+	// Addr is the UDP address to listen on, ":https" if empty.
+	Addr string
+	// Handler handles requests, http.DefaultServeMux if nil.
+	Handler http.Handler
+	// TLSConfig holds the server's certificates. It overrides the TLSConfig
+	// of QuicConfig.
+	TLSConfig *tls.Config
+	// QuicConfig configures the QUIC sessions, it may be nil.
+	QuicConfig *Config
+	// ErrorLog is the logger for errors serving requests, such as panics in
+	// the handler. If nil, the log package's standard logger is used.
+	ErrorLog *log.Logger
+
+	mu       sync.Mutex
+	listener *Listener
+	closed   bool
+}
+
+// ListenAndServe listens on addr and serves HTTP requests with handler,
+// using the certificate and key in certFile and keyFile.
+func ListenAndServe(addr, certFile, keyFile string, handler http.Handler) error {
+	server := &Server{Addr: addr, Handler: handler}
+	return server.ListenAndServeTLS(certFile, keyFile)
+}
+
+// ListenAndServe listens on s.Addr and serves requests with the certificates
+// of s.TLSConfig.
+func (s *Server) ListenAndServe() error {
+	return s.ListenAndServeTLS("", "")
+}
+
+// ListenAndServeTLS listens on s.Addr and serves requests. The certificate
+// and key in certFile and keyFile are added to s.TLSConfig if given.
+func (s *Server) ListenAndServeTLS(certFile, keyFile string) error {
+	config := s.config()
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return err
+		}
+		tlsConfig := &tls.Config{}
+		if config.TLSConfig != nil {
+			tlsConfig = config.TLSConfig.Clone()
+		}
+		tlsConfig.Certificates = append(tlsConfig.Certificates, cert)
+		config.TLSConfig = tlsConfig
+	}
+	addr := s.Addr
+	if addr == "" {
+		addr = ":https"
+	}
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return err
+	}
+	l, err := NewListener(conn, config)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	return s.Serve(l)
+}
+
+// config returns the config for the server's sessions.
+func (s *Server) config() *Config {
+	config := &Config{}
+	if s.QuicConfig != nil {
+		*config = *s.QuicConfig
+	}
+	if s.TLSConfig != nil {
+		config.TLSConfig = s.TLSConfig
+	}
+	return config
+}
+
+// Serve serves requests on the sessions of l until the server is closed, in
+// which case it returns http.ErrServerClosed.
+func (s *Server) Serve(l *Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		l.Close()
+		return http.ErrServerClosed
+	}
+	s.listener = l
+	s.mu.Unlock()
+	for {
+		session, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return http.ErrServerClosed
+			}
+			return err
+		}
+		go s.serveSession(session)
+	}
+}
+
+// Close closes the listener and all its sessions immediately.
+func (s *Server) Close() error {
+	l := s.close()
+	if l == nil {
+		return nil
+	}
+	for _, session := range l.openSessions() {
+		session.CloseWithError(QUIC_PEER_GOING_AWAY, "server closed")
+	}
+	l.Close()
+	return nil
+}
+
+// Shutdown gracefully shuts down the server, see Listener.Shutdown.
+func (s *Server) Shutdown(ctx context.Context) error {
+	l := s.close()
+	if l == nil {
+		return nil
+	}
+	return l.Shutdown(ctx)
+}
+
+// close marks the server closed and returns its listener, if it has one.
+func (s *Server) close() *Listener {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return s.listener
+}
+
+// logf logs an error serving a request to s.ErrorLog.
+func (s *Server) logf(format string, args ...interface{}) {
+	if s.ErrorLog != nil {
+		s.ErrorLog.Printf(format, args...)
+	} else {
+		log.Printf(format, args...)
+	}
+}
+
+// serveSession reads the requests of a session from its headers stream and
+// serves each of them in its own goroutine.
+func (s *Server) serveSession(session *Session) {
+	go func() {
+		// Requests are found through the headers stream, the streams
+		// themselves don't need accepting.
+		for {
+			if _, err := session.AcceptStream(); err != nil {
+				return
+			}
+		}
+	}()
+	headers := session.HeadersStream()
+	for {
+		frame, err := headers.ReadHeaders()
+		if err != nil {
+			return
+		}
+		if frame.Stream == nil || frame.PromisedStreamID != 0 {
+			continue
+		}
+		go s.serveRequest(session, frame)
+	}
+}
+
+// serveRequest serves the request in a header block.
+func (s *Server) serveRequest(session *Session, frame *HeadersFrame) {
+	stream := frame.Stream
+	w := &responseWriter{
+		server:  s,
+		headers: session.HeadersStream(),
+		stream:  stream,
+		header:  http.Header{},
+	}
+	defer func() {
+		if err := recover(); err != nil {
+			s.logf("quic: panic serving stream %d: %v\n%s", stream.StreamID(), err, debug.Stack())
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		w.finish()
+		// The handler won't read the rest of the body, so the client is
+		// told to stop sending it.
+		stream.stopReading()
+	}()
+
+	req, err := newRequest(session, frame)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	// The request is cancelled if the session closes.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-session.closeChan:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	handler := s.Handler
+	if handler == nil {
+		handler = http.DefaultServeMux
+	}
+	handler.ServeHTTP(w, req.WithContext(ctx))
+}
+
+// errMissingPseudoHeader is returned for requests without a method or path.
+var errMissingPseudoHeader = errors.New("quic: request without :method or :path")
+
+// newRequest returns the request in a header block, with the data stream as
+// its body.
+func newRequest(session *Session, frame *HeadersFrame) (*http.Request, error) {
+	var method, scheme, authority, path string
+	header := http.Header{}
+	for _, f := range frame.Headers {
+		switch f.Name {
+		case ":method":
+			method = f.Value
+		case ":scheme":
+			scheme = f.Value
+		case ":authority":
+			authority = f.Value
+		case ":path":
+			path = f.Value
+		default:
+			header.Add(f.Name, f.Value)
+		}
+	}
+	if method == "" || path == "" {
+		return nil, errMissingPseudoHeader
+	}
+	if scheme == "" {
+		scheme = "https"
+	}
+	if authority == "" {
+		authority = header.Get("Host")
+	}
+	u, err := url.ParseRequestURI(path)
+	if err != nil {
+		return nil, err
+	}
+	u.Scheme = scheme
+	u.Host = authority
+
+	var body io.ReadCloser = http.NoBody
+	contentLength := int64(0)
+	if !frame.Fin {
+		body = io.NopCloser(frame.Stream)
+		contentLength = -1
+		if cl := header.Get("Content-Length"); cl != "" {
+			if contentLength, err = strconv.ParseInt(cl, 10, 64); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return &http.Request{
+		Method:        method,
+		URL:           u,
+		Proto:         "HTTP/2.0",
+		ProtoMajor:    2,
+		Header:        header,
+		Body:          body,
+		ContentLength: contentLength,
+		Host:          authority,
+		RemoteAddr:    session.RemoteAddr().String(),
+		RequestURI:    path,
+		// TLS is left nil: the QUIC crypto handshake isn't TLS and there is
+		// no ConnectionState that would describe it truthfully.
+	}, nil
+}
+
+// responseWriter is the http.ResponseWriter of a request. The status and
+// headers are sent on the headers stream and the body on the data stream.
+type responseWriter struct {
+	server  *Server
+	headers *HeadersStream
+	stream  *Stream
+	header  http.Header
+
+	wroteHeader bool
+	wroteBody   bool
+}
+
+func (w *responseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *responseWriter) WriteHeader(status int) {
+	w.writeHeader(status, false)
+}
+
+// writeHeader sends the status and headers, with fin set if there is no
+// body.
+func (w *responseWriter) writeHeader(status int, fin bool) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	fields := []hpack.HeaderField{{Name: ":status", Value: strconv.Itoa(status)}}
+	for name, values := range w.header {
+		name = strings.ToLower(name)
+		for _, v := range values {
+			fields = append(fields, hpack.HeaderField{Name: name, Value: v})
+		}
+	}
+	if err := w.headers.WriteHeaders(&HeadersFrame{StreamID: w.stream.StreamID(), Headers: fields, Fin: fin}); err != nil {
+		w.server.logf("quic: writing headers of stream %d: %v", w.stream.StreamID(), err)
+	}
+}
+
+func (w *responseWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		if w.header.Get("Content-Type") == "" {
+			w.header.Set("Content-Type", http.DetectContentType(p))
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+	if len(p) > 0 {
+		w.wroteBody = true
+	}
+	return w.stream.Write(p)
+}
+
+// Flush implements http.Flusher. Writes are sent as soon as congestion
+// control allows, so there is nothing to flush.
+func (w *responseWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// finish sends the headers if the handler didn't write anything, and closes
+// the data stream.
+func (w *responseWriter) finish() {
+	w.writeHeader(http.StatusOK, !w.wroteBody)
+	w.stream.Close()
+}
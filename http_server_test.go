@@ -0,0 +1,80 @@
+package quic
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServerUnreadBody(t *testing.T) {
+	server, url := startTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ignored your body")
+	}))
+	client := &http.Client{Transport: testRoundTripper(t), Timeout: 5 * time.Second}
+
+	// The body never ends, and is larger than the flow control windows.
+	body, bodyWriter := io.Pipe()
+	defer bodyWriter.Close()
+	go bodyWriter.Write(make([]byte, 2*defaultConnectionWindow))
+
+	resp, err := client.Post(url, "application/octet-stream", body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	got, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "ignored your body" {
+		t.Errorf("response body = %q", got)
+	}
+
+	// The request stream finishes on the server without the rest of the
+	// body, before the client gives up on it.
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown() = %v", err)
+	}
+}
+
+// chanWriter sends what is written to it on the channel.
+type chanWriter chan string
+
+func (w chanWriter) Write(p []byte) (int, error) {
+	w <- string(p)
+	return len(p), nil
+}
+
+func TestServerErrorLog(t *testing.T) {
+	logged := make(chanWriter, 10)
+	url := serveTest(t, &Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			panic("handler failed")
+		}),
+		ErrorLog: log.New(logged, "", 0),
+	})
+	client := &http.Client{Transport: testRoundTripper(t), Timeout: 5 * time.Second}
+
+	resp, err := client.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("got %s, want 500", resp.Status)
+	}
+	select {
+	case msg := <-logged:
+		if !strings.Contains(msg, "handler failed") {
+			t.Errorf("ErrorLog got %q, want the panic", msg)
+		}
+	case <-time.After(time.Second):
+		t.Error("the panic wasn't logged to ErrorLog")
+	}
+}
@@ -0,0 +1,92 @@
+package quic
+
+import "net"
+
+// amplificationFactor limits how much we send to an unvalidated peer
+// address, relative to what we received from it, so a spoofed source address
+// can't be used to flood a third party.
+const amplificationFactor = 3
+
+// receivedPacket is a packet along with the address it came from. packet
+// only holds the public header until the payload is decrypted.
+type receivedPacket struct {
+	packet    *Packet
+	addr      net.Addr
+	size      int
+	data      []byte
+	headerLen int
+}
+
+// maybeMigrate switches the session to the address the packet came from, if
+// the peer moved. Servers identify sessions by ConnID, so a client that
+// changes networks keeps its session. Until the new path is validated by an
+// ACK of a packet sent on it, only a few times the data received from the new
+// address is sent to it.
+func (s *Session) maybeMigrate(p receivedPacket) error {
+	if sameAddr(p.addr, s.addr) {
+		s.pathBytesReceived += uint64(p.size)
+		return nil
+	}
+	if s.isClient || p.packet.SequenceNumber <= s.receivedEntropy.largestObserved {
+		// Only clients migrate, and reordered packets sent from the old
+		// address don't move the session back.
+		return nil
+	}
+	if s.config.disableMigration() {
+		return qerr(QUIC_ERROR_MIGRATING_ADDRESS, "peer moved from %s to %s", s.addr, p.addr)
+	}
+	if !sameIP(p.addr, s.addr) {
+		// A new network, so nothing we learned about the old path applies.
+		// A new port only is NAT rebinding on the same path.
+		s.rtt.reset()
+		s.congestion = s.config.newCongestionControl(s.rtt)
+		s.pacer = newPacer(s.congestion, s.config.initialBurst())
+		s.mtu.reset()
+		// The new controller hasn't seen the packets sent on the old path,
+		// so they are taken out of flight and their data is sent again on
+		// the new one.
+		for _, sent := range s.outstandingPackets(false) {
+			s.retransmit(sent)
+		}
+		s.setRetransmissionAlarm()
+	}
+	s.addrMu.Lock()
+	s.addr = p.addr
+	s.addrMu.Unlock()
+	s.pathValidated = false
+	s.pathValidationSequenceNumber = s.sequenceNumber
+	s.pathBytesReceived = uint64(p.size)
+	s.pathBytesSent = 0
+	// The ACK of the PING validates the path.
+	s.queueFrame(FramePing{})
+	return nil
+}
+
+// onPathAck validates the peer's address once a packet sent to it was
+// acknowledged.
+func (s *Session) onPathAck(largestObserved uint64) {
+	if largestObserved > s.pathValidationSequenceNumber {
+		s.pathValidated = true
+	}
+}
+
+// pathLimited reports whether nothing more may be sent to an unvalidated
+// peer address.
+func (s *Session) pathLimited() bool {
+	return !s.pathValidated && s.pathBytesSent >= amplificationFactor*s.pathBytesReceived
+}
+
+// sameAddr reports whether a and b are the same address.
+func sameAddr(a, b net.Addr) bool {
+	return a.Network() == b.Network() && a.String() == b.String()
+}
+
+// sameIP reports whether a and b have the same IP address, ignoring the port.
+func sameIP(a, b net.Addr) bool {
+	ua, ok := a.(*net.UDPAddr)
+	if !ok {
+		return sameAddr(a, b)
+	}
+	ub, ok := b.(*net.UDPAddr)
+	return ok && ua.IP.Equal(ub.IP)
+}
@@ -0,0 +1,67 @@
+package quic
+
+import (
+	"net"
+	"testing"
+)
+
+func TestMigrationResetsBytesInFlight(t *testing.T) {
+	// newTestSession talks to 192.0.2.1:4433.
+	oldAddr := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 4433}
+	tests := []struct {
+		name    string
+		addr    *net.UDPAddr
+		resends bool
+	}{
+		{"new network", &net.UDPAddr{IP: net.IPv4(198, 51, 100, 1), Port: 4433}, true},
+		{"NAT rebinding", &net.UDPAddr{IP: oldAddr.IP, Port: 5000}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The session goroutine is stopped when the test ends.
+			s, _ := newTestSession(t)
+			data := FrameStream{StreamID: 5, Data: "data"}
+			var err error
+			var replaced bool
+			var bytesInFlight uint64
+			var outstanding bool
+			var retransmissions []Frame
+			doSync(s, func() {
+				congestion := s.congestion
+				s.sentPackets[1] = &sentPacket{sequenceNumber: 1, length: 100, retransmittable: true, frames: []Frame{data}}
+				s.sentPackets[2] = &sentPacket{sequenceNumber: 2, length: 50}
+				s.bytesInFlight = 100
+
+				p := receivedPacket{packet: &Packet{SequenceNumber: 1}, addr: tt.addr, size: 100}
+				err = s.maybeMigrate(p)
+				replaced = s.congestion != congestion
+				bytesInFlight = s.bytesInFlight
+				_, outstanding = s.sentPackets[1]
+				retransmissions = s.retransmissions
+				// Keep the session from sending them.
+				s.retransmissions = nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if replaced != tt.resends {
+				t.Fatalf("congestion controller replaced = %t, want %t", replaced, tt.resends)
+			}
+			if !tt.resends {
+				if bytesInFlight != 100 || len(retransmissions) != 0 {
+					t.Errorf("bytesInFlight = %d with %d retransmissions, want 100 and none", bytesInFlight, len(retransmissions))
+				}
+				return
+			}
+			if bytesInFlight != 0 {
+				t.Errorf("bytesInFlight = %d, want 0", bytesInFlight)
+			}
+			if outstanding {
+				t.Errorf("packet sent on the old path still outstanding")
+			}
+			if len(retransmissions) != 1 || retransmissions[0] != Frame(data) {
+				t.Errorf("retransmissions = %v, want the data sent on the old path", retransmissions)
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package quic
+
+import "sync"
+
+// MTU discovery constants
+const (
+	// initialPacketSize is the packet size sessions start with. It fits the
+	// minimum IPv6 MTU of 1280 bytes after the IP and UDP headers.
+	initialPacketSize = 1252
+	// defaultMaxPacketSize is the largest packet size probed for by default,
+	// an Ethernet MTU of 1500 bytes less the IPv6 and UDP headers.
+	defaultMaxPacketSize = 1452
+	// maxUDPPayloadSize is the largest payload a UDP datagram can carry.
+	maxUDPPayloadSize = 65527
+	// mtuProbeGranularity ends the search once the discovered size is this
+	// close to the largest size that may still work.
+	mtuProbeGranularity = 16
+	// packetsBetweenMTUProbes is the number of packets sent between probes.
+	packetsBetweenMTUProbes = 100
+	// maxLostLargePackets is the number of consecutive packets larger than
+	// initialPacketSize that may be lost before falling back to it.
+	maxLostLargePackets = 3
+)
+
+// mtuDiscoverer searches for the largest packet size the path supports by
+// sending padded PING packets. The search is a binary search between the
+// largest size known to work and the smallest size known not to.
+type mtuDiscoverer struct {
+	mu         sync.Mutex
+	packetSize int
+
+	// The fields below are only accessed from the run goroutine.
+	maxPacketSize     int
+	upper             int
+	probeInFlight     bool
+	packetsSinceProbe int
+	lostLargePackets  int
+}
+
+func newMTUDiscoverer(maxPacketSize int) *mtuDiscoverer {
+	return &mtuDiscoverer{
+		packetSize:    initialPacketSize,
+		maxPacketSize: maxPacketSize,
+		upper:         maxPacketSize + 1,
+	}
+}
+
+// reset starts the search over, e.g. when the peer moved to a new network.
+func (m *mtuDiscoverer) reset() {
+	m.setPacketSize(initialPacketSize)
+	m.upper = m.maxPacketSize + 1
+	m.probeInFlight = false
+	m.packetsSinceProbe = 0
+	m.lostLargePackets = 0
+}
+
+// PacketSize returns the largest packet size known to work.
+func (m *mtuDiscoverer) PacketSize() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.packetSize
+}
+
+func (m *mtuDiscoverer) setPacketSize(size int) {
+	m.mu.Lock()
+	m.packetSize = size
+	m.mu.Unlock()
+}
+
+// ProbeSize returns the size of the next probe packet, or false if it isn't
+// time for one.
+func (m *mtuDiscoverer) ProbeSize() (int, bool) {
+	size := m.PacketSize()
+	if m.probeInFlight || m.packetsSinceProbe < packetsBetweenMTUProbes ||
+		m.upper-size <= mtuProbeGranularity {
+		return 0, false
+	}
+	return (size + m.upper) / 2, true
+}
+
+// OnPacketSent records a sent packet.
+func (m *mtuDiscoverer) OnPacketSent(probe bool) {
+	if probe {
+		m.probeInFlight = true
+		m.packetsSinceProbe = 0
+		return
+	}
+	m.packetsSinceProbe++
+}
+
+// OnPacketAcked raises the packet size when a probe got through.
+func (m *mtuDiscoverer) OnPacketAcked(size int, probe bool) {
+	if size > initialPacketSize {
+		m.lostLargePackets = 0
+	}
+	if !probe {
+		return
+	}
+	m.probeInFlight = false
+	if size > m.PacketSize() {
+		m.setPacketSize(size)
+	}
+}
+
+// OnPacketLost lowers the size to search up to when a probe was lost. If
+// large packets keep getting lost, e.g. because the path changed, the packet
+// size falls back to initialPacketSize and probing stops.
+func (m *mtuDiscoverer) OnPacketLost(size int, probe bool) {
+	if probe {
+		m.probeInFlight = false
+		if size < m.upper {
+			m.upper = size
+		}
+		return
+	}
+	if size <= initialPacketSize {
+		return
+	}
+	m.lostLargePackets++
+	if m.lostLargePackets >= maxLostLargePackets {
+		m.lostLargePackets = 0
+		m.setPacketSize(initialPacketSize)
+		m.upper = initialPacketSize
+	}
+}
@@ -0,0 +1,159 @@
+package quic
+
+import "testing"
+
+// sendRegularPackets records n packets that aren't probes as sent.
+func sendRegularPackets(m *mtuDiscoverer, n int) {
+	for i := 0; i < n; i++ {
+		m.OnPacketSent(false)
+	}
+}
+
+func TestMTUProbeSchedule(t *testing.T) {
+	m := newMTUDiscoverer(defaultMaxPacketSize)
+	if got := m.PacketSize(); got != initialPacketSize {
+		t.Errorf("PacketSize() = %d, want %d", got, initialPacketSize)
+	}
+	sendRegularPackets(m, packetsBetweenMTUProbes-1)
+	if size, ok := m.ProbeSize(); ok {
+		t.Errorf("ProbeSize() = %d before %d packets were sent", size, packetsBetweenMTUProbes)
+	}
+	sendRegularPackets(m, 1)
+	size, ok := m.ProbeSize()
+	if want := (initialPacketSize + defaultMaxPacketSize + 1) / 2; !ok || size != want {
+		t.Errorf("ProbeSize() = %d, %t, want %d, true", size, ok, want)
+	}
+
+	// Only one probe is in flight at a time.
+	m.OnPacketSent(true)
+	sendRegularPackets(m, packetsBetweenMTUProbes)
+	if size, ok := m.ProbeSize(); ok {
+		t.Errorf("ProbeSize() = %d with a probe in flight", size)
+	}
+}
+
+func TestMTUProbeSuccess(t *testing.T) {
+	m := newMTUDiscoverer(defaultMaxPacketSize)
+	var sizes []int
+	for {
+		sendRegularPackets(m, packetsBetweenMTUProbes)
+		size, ok := m.ProbeSize()
+		if !ok {
+			break
+		}
+		sizes = append(sizes, size)
+		m.OnPacketSent(true)
+		m.OnPacketAcked(size, true)
+		if got := m.PacketSize(); got != size {
+			t.Fatalf("PacketSize() = %d after probe of %d bytes was acked", got, size)
+		}
+	}
+	// The search converges on the maximum.
+	if got := m.PacketSize(); defaultMaxPacketSize-got > mtuProbeGranularity {
+		t.Errorf("PacketSize() = %d after probes %v, want within %d of %d", got, sizes, mtuProbeGranularity, defaultMaxPacketSize)
+	}
+	if len(sizes) > 4 {
+		t.Errorf("%d probes sent, want a binary search", len(sizes))
+	}
+
+	// Acks of regular packets never raise the size.
+	m.OnPacketAcked(maxUDPPayloadSize, false)
+	if got := m.PacketSize(); got > defaultMaxPacketSize {
+		t.Errorf("PacketSize() = %d after a regular packet was acked", got)
+	}
+}
+
+func TestMTUProbeLoss(t *testing.T) {
+	m := newMTUDiscoverer(defaultMaxPacketSize)
+	// The path supports 1350 bytes.
+	const pathMTU = 1350
+	for {
+		sendRegularPackets(m, packetsBetweenMTUProbes)
+		size, ok := m.ProbeSize()
+		if !ok {
+			break
+		}
+		m.OnPacketSent(true)
+		if size <= pathMTU {
+			m.OnPacketAcked(size, true)
+		} else {
+			m.OnPacketLost(size, true)
+			if got := m.PacketSize(); got > pathMTU {
+				t.Fatalf("PacketSize() = %d after a probe of %d bytes was lost", got, size)
+			}
+		}
+	}
+	if got := m.PacketSize(); got > pathMTU || pathMTU-got > mtuProbeGranularity {
+		t.Errorf("PacketSize() = %d, want within %d below %d", got, mtuProbeGranularity, pathMTU)
+	}
+}
+
+func TestMTULargePacketsLost(t *testing.T) {
+	m := newMTUDiscoverer(defaultMaxPacketSize)
+	sendRegularPackets(m, packetsBetweenMTUProbes)
+	size, _ := m.ProbeSize()
+	m.OnPacketSent(true)
+	m.OnPacketAcked(size, true)
+
+	// Losing small packets, or fewer than maxLostLargePackets large ones in a
+	// row, is ordinary congestion.
+	for i := 0; i < 2*maxLostLargePackets; i++ {
+		m.OnPacketLost(initialPacketSize, false)
+	}
+	for i := 0; i < maxLostLargePackets-1; i++ {
+		m.OnPacketLost(size, false)
+	}
+	m.OnPacketAcked(size, false)
+	for i := 0; i < maxLostLargePackets-1; i++ {
+		m.OnPacketLost(size, false)
+	}
+	if got := m.PacketSize(); got != size {
+		t.Fatalf("PacketSize() = %d, want %d", got, size)
+	}
+
+	// After that the path has changed: fall back and stop probing.
+	m.OnPacketLost(size, false)
+	if got := m.PacketSize(); got != initialPacketSize {
+		t.Errorf("PacketSize() = %d after %d large packets were lost, want %d", got, maxLostLargePackets, initialPacketSize)
+	}
+	sendRegularPackets(m, packetsBetweenMTUProbes)
+	if size, ok := m.ProbeSize(); ok {
+		t.Errorf("ProbeSize() = %d after falling back", size)
+	}
+
+	// Until the search starts over.
+	m.reset()
+	sendRegularPackets(m, packetsBetweenMTUProbes)
+	if _, ok := m.ProbeSize(); !ok {
+		t.Error("no probe after reset")
+	}
+}
+
+func TestMTUProbeRetransmissionTimeout(t *testing.T) {
+	s, _ := newTestSession(t)
+	var size int
+	var probeOutstanding, probing bool
+	doSync(s, func() {
+		sendRegularPackets(s.mtu, packetsBetweenMTUProbes)
+		probeSize, _ := s.mtu.ProbeSize()
+		s.mtu.OnPacketSent(true)
+		s.sentPackets[1] = &sentPacket{sequenceNumber: 1, retransmittable: true, mtuProbe: true, length: uint64(probeSize)}
+		s.bytesInFlight = uint64(probeSize)
+		// Past the tail loss probes, the RTO declares the probe lost.
+		s.consecutiveTLPCount = maxTailLossProbes
+		s.onRetransmissionTimeout()
+		_, probeOutstanding = s.sentPackets[1]
+		size = s.mtu.PacketSize()
+		sendRegularPackets(s.mtu, packetsBetweenMTUProbes)
+		_, probing = s.mtu.ProbeSize()
+	})
+	if probeOutstanding {
+		t.Error("the lost probe is still outstanding")
+	}
+	if size != initialPacketSize {
+		t.Errorf("PacketSize() = %d after the probe was lost, want %d", size, initialPacketSize)
+	}
+	if !probing {
+		t.Error("no smaller probe after the first was lost")
+	}
+}
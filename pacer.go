@@ -0,0 +1,88 @@
+package quic
+
+import "time"
+
+// Pacing constants
+const (
+	// defaultInitialBurst is the number of packets that may be sent without
+	// pacing when a session starts or after it has been idle.
+	defaultInitialBurst = 10
+	// pacingGranularity is how early a packet may be sent, so that the timer
+	// isn't armed for tiny delays.
+	pacingGranularity = time.Millisecond
+	// pacingGain is the multiple of the bandwidth estimate packets are paced
+	// at if the congestion controller doesn't provide its own pacing rate.
+	pacingGain = 1.25
+)
+
+// pacingRater is implemented by congestion controllers that compute their
+// own pacing rate, e.g. BBR.
+type pacingRater interface {
+	PacingRate() Bandwidth
+}
+
+// pacer spaces retransmittable packets out according to the congestion
+// controller's bandwidth estimate so a full congestion window isn't sent as
+// one burst.
+type pacer struct {
+	congestion   CongestionControl
+	initialBurst int
+
+	burstTokens  int
+	nextSendTime time.Time
+}
+
+func newPacer(congestion CongestionControl, initialBurst int) *pacer {
+	if initialBurst <= 0 {
+		initialBurst = defaultInitialBurst
+	}
+	return &pacer{
+		congestion:   congestion,
+		initialBurst: initialBurst,
+		burstTokens:  initialBurst,
+	}
+}
+
+// pacingRate returns the rate packets are currently paced at.
+func (p *pacer) pacingRate() Bandwidth {
+	if r, ok := p.congestion.(pacingRater); ok {
+		return r.PacingRate()
+	}
+	return Bandwidth(pacingGain * float64(p.congestion.BandwidthEstimate()))
+}
+
+// OnPacketSent schedules the earliest time the next packet may be sent.
+func (p *pacer) OnPacketSent(sentTime time.Time, bytesInFlight, bytes uint64, retransmittable bool) {
+	if !retransmittable {
+		return
+	}
+	if bytesInFlight == 0 {
+		// Leaving quiescence, allow another burst.
+		p.burstTokens = p.initialBurst
+	}
+	if p.burstTokens > 0 {
+		p.burstTokens--
+		p.nextSendTime = time.Time{}
+		return
+	}
+	rate := p.pacingRate()
+	if rate == 0 {
+		return
+	}
+	if p.nextSendTime.Before(sentTime.Add(-pacingGranularity)) {
+		// We fell behind schedule, don't try to catch up with a burst.
+		p.nextSendTime = sentTime
+	}
+	p.nextSendTime = p.nextSendTime.Add(rate.TransferTime(bytes))
+}
+
+// TimeUntilSend returns how long to wait before sending the next packet.
+func (p *pacer) TimeUntilSend(now time.Time, bytesInFlight uint64) time.Duration {
+	if p.burstTokens > 0 || bytesInFlight == 0 {
+		return 0
+	}
+	if delay := p.nextSendTime.Sub(now); delay > pacingGranularity {
+		return delay
+	}
+	return 0
+}
@@ -0,0 +1,114 @@
+package quic
+
+import (
+	"testing"
+	"time"
+)
+
+// fixedBandwidth is a congestion controller with a constant bandwidth
+// estimate that never blocks sending.
+type fixedBandwidth struct {
+	bandwidth Bandwidth
+}
+
+func (c *fixedBandwidth) OnPacketSent(time.Time, uint64, uint64, uint64, bool) {}
+func (c *fixedBandwidth) OnPacketAcked(time.Time, uint64, uint64, uint64)      {}
+func (c *fixedBandwidth) OnPacketLost(uint64, uint64, uint64)                  {}
+func (c *fixedBandwidth) OnRetransmissionTimeout(bool)                         {}
+func (c *fixedBandwidth) CanSend(uint64) bool                                  { return true }
+func (c *fixedBandwidth) BandwidthEstimate() Bandwidth                         { return c.bandwidth }
+
+// fixedPacingRate is a fixedBandwidth with its own pacing rate.
+type fixedPacingRate struct {
+	fixedBandwidth
+	rate Bandwidth
+}
+
+func (c *fixedPacingRate) PacingRate() Bandwidth { return c.rate }
+
+func TestPacingRate(t *testing.T) {
+	p := newPacer(&fixedBandwidth{bandwidth: 8 * MBitsPerSecond}, 0)
+	if got, want := p.pacingRate(), 10*MBitsPerSecond; got != want {
+		t.Errorf("pacingRate() = %d, want %d", got, want)
+	}
+	p = newPacer(&fixedPacingRate{fixedBandwidth{8 * MBitsPerSecond}, 3 * MBitsPerSecond}, 0)
+	if got, want := p.pacingRate(), 3*MBitsPerSecond; got != want {
+		t.Errorf("pacingRate() with a pacing rater = %d, want %d", got, want)
+	}
+}
+
+func TestPacerBurst(t *testing.T) {
+	const packetSize = 1000
+	// 1000 bytes take 10ms at the pacing rate.
+	p := newPacer(&fixedPacingRate{rate: 800 * KBitsPerSecond}, 3)
+	now := time.Now()
+
+	var inFlight uint64
+	for i := 0; i < 3; i++ {
+		if d := p.TimeUntilSend(now, inFlight); d != 0 {
+			t.Fatalf("packet %d of the burst waits %s", i, d)
+		}
+		p.OnPacketSent(now, inFlight, packetSize, true)
+		inFlight += packetSize
+	}
+	// The burst is used up, later packets are paced.
+	p.OnPacketSent(now, inFlight, packetSize, true)
+	inFlight += packetSize
+	if got, want := p.TimeUntilSend(now, inFlight), 10*time.Millisecond; got != want {
+		t.Errorf("TimeUntilSend() after the burst = %s, want %s", got, want)
+	}
+	p.OnPacketSent(now.Add(10*time.Millisecond), inFlight, packetSize, true)
+	inFlight += packetSize
+	if got, want := p.TimeUntilSend(now.Add(10*time.Millisecond), inFlight), 10*time.Millisecond; got != want {
+		t.Errorf("TimeUntilSend() = %s, want %s", got, want)
+	}
+
+	// Non-retransmittable packets aren't paced.
+	p.OnPacketSent(now.Add(10*time.Millisecond), inFlight, packetSize, false)
+	if got, want := p.TimeUntilSend(now.Add(10*time.Millisecond), inFlight), 10*time.Millisecond; got != want {
+		t.Errorf("TimeUntilSend() after an ACK-only packet = %s, want %s", got, want)
+	}
+
+	// Delays within the granularity aren't waited for.
+	if got := p.TimeUntilSend(now.Add(19500*time.Microsecond), inFlight); got != 0 {
+		t.Errorf("TimeUntilSend() half a millisecond early = %s, want 0", got)
+	}
+
+	// Once nothing is in flight another burst is allowed.
+	if got := p.TimeUntilSend(now.Add(10*time.Millisecond), 0); got != 0 {
+		t.Errorf("TimeUntilSend() when quiescent = %s, want 0", got)
+	}
+	later := now.Add(time.Second)
+	for i := 0; i < 3; i++ {
+		p.OnPacketSent(later, uint64(i)*packetSize, packetSize, true)
+		if d := p.TimeUntilSend(later, uint64(i+1)*packetSize); d != 0 {
+			t.Errorf("packet %d of the second burst waits %s", i+1, d)
+		}
+	}
+}
+
+func TestPacerFallsBehind(t *testing.T) {
+	const packetSize = 1000
+	p := newPacer(&fixedPacingRate{rate: 800 * KBitsPerSecond}, 1)
+	now := time.Now()
+	p.OnPacketSent(now, 0, packetSize, true)
+	p.OnPacketSent(now, packetSize, packetSize, true)
+
+	// Sending late doesn't earn a burst to catch up.
+	late := now.Add(time.Second)
+	p.OnPacketSent(late, 2*packetSize, packetSize, true)
+	if got, want := p.TimeUntilSend(late, 3*packetSize), 10*time.Millisecond; got != want {
+		t.Errorf("TimeUntilSend() = %s, want %s", got, want)
+	}
+}
+
+func TestPacerNoBandwidthEstimate(t *testing.T) {
+	p := newPacer(&fixedBandwidth{}, 1)
+	now := time.Now()
+	for i := uint64(0); i < 5; i++ {
+		p.OnPacketSent(now, i*1000, 1000, true)
+	}
+	if got := p.TimeUntilSend(now, 5000); got != 0 {
+		t.Errorf("TimeUntilSend() without a bandwidth estimate = %s, want 0", got)
+	}
+}
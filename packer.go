@@ -0,0 +1,179 @@
+package quic
+
+import "time"
+
+// packPacket assembles the frames of the next packet: an ACK and STOP_WAITING
+// if one is owed, then queued control frames, then stream data in scheduling
+// order, splitting stream frames that don't fit. Packets carrying handshake
+// data are padded to the full packet size. If ackOnly is set only the ACK is
+// packed. It returns the encryption level the packet must be sealed at:
+// handshake data goes out at the level it was first sent at, and packets
+// carrying it hold nothing else of a higher level.
+func (s *Session) packPacket(ackOnly bool) ([]Frame, encryptionLevel, error) {
+	var frames []Frame
+	level := s.sealLevel
+	if !ackOnly {
+		level = s.packLevel()
+	}
+	remaining := s.mtu.PacketSize() - maxPacketHeaderSize - s.sealers[level].Overhead()
+	add := func(f Frame) (bool, error) {
+		buf, err := f.ToBuf()
+		if err != nil {
+			return false, err
+		}
+		if len(buf) > remaining {
+			return false, nil
+		}
+		frames = append(frames, f)
+		remaining -= len(buf)
+		return true, nil
+	}
+
+	if s.ackPending {
+		ack := s.receivedEntropy.AckFrame(remaining - stopWaitingFrameSize)
+		ack.LargestObservedDeltaTime = uint64(time.Since(s.largestReceivedTime) / time.Microsecond)
+		stopWaiting := s.sentEntropy.StopWaitingFrame(s.sequenceNumber+1, s.leastUnacked())
+		for _, f := range []Frame{ack, stopWaiting} {
+			if ok, err := add(f); err != nil || !ok {
+				return nil, level, s.packetTooLarge(f, err)
+			}
+		}
+		s.ackPending = false
+	}
+	if ackOnly {
+		return frames, level, nil
+	}
+
+	// Frames that can't go out at this level wait for the next packet.
+	var deferred []Frame
+	defer func() {
+		s.pendingFrames = append(deferred, s.pendingFrames...)
+	}()
+	for len(s.pendingFrames) > 0 {
+		f := s.pendingFrames[0]
+		if !s.canPackAt(f, level) {
+			deferred = append(deferred, f)
+			s.pendingFrames = s.pendingFrames[1:]
+			continue
+		}
+		if stream, ok := f.(FrameStream); ok {
+			// Retransmitted and crypto stream data can be split.
+			frame, rest, ok := splitStreamFrame(stream, remaining)
+			if !ok {
+				break
+			}
+			frames = append(frames, frame)
+			remaining -= streamFrameHeaderSize + len(frame.Data)
+			if rest == nil {
+				s.pendingFrames = s.pendingFrames[1:]
+			} else {
+				s.pendingFrames[0] = *rest
+			}
+			continue
+		}
+		ok, err := add(f)
+		if err != nil {
+			return nil, level, err
+		}
+		if !ok {
+			if len(frames) == 0 {
+				return nil, level, s.packetTooLarge(f, nil)
+			}
+			break
+		}
+		s.pendingFrames = s.pendingFrames[1:]
+	}
+
+	if level != s.sealLevel || !s.canSendStreamData() {
+		return s.padHandshakePacket(frames, remaining), level, nil
+	}
+	for _, stream := range s.streamsWithData() {
+		if remaining <= streamFrameHeaderSize {
+			break
+		}
+		if frame, ok := stream.popStreamFrame(remaining - streamFrameHeaderSize); ok {
+			s.scheduler.onSent(stream)
+			frames = append(frames, frame)
+			remaining -= streamFrameHeaderSize + len(frame.Data)
+		}
+	}
+
+	return s.padHandshakePacket(frames, remaining), level, nil
+}
+
+// padHandshakePacket pads the frames of a packet carrying handshake data with
+// remaining bytes.
+func (s *Session) padHandshakePacket(frames []Frame, remaining int) []Frame {
+	for _, f := range frames {
+		if isHandshakeFrame(f) {
+			if remaining > 0 {
+				frames = append(frames, FramePadding{Size: remaining})
+			}
+			break
+		}
+	}
+	return frames
+}
+
+// packLevel returns the encryption level of the next packet: the lowest
+// level queued handshake data was first sent at, or the current level.
+func (s *Session) packLevel() encryptionLevel {
+	level := s.sealLevel
+	for _, f := range s.pendingFrames {
+		if frame, ok := f.(FrameStream); ok && frame.StreamID == cryptoStreamID {
+			if l := s.cryptoLevel(frame.Offset); l < level {
+				level = l
+			}
+		}
+	}
+	return level
+}
+
+// canPackAt reports whether a queued frame may be sent in a packet sealed at
+// level.
+func (s *Session) canPackAt(f Frame, level encryptionLevel) bool {
+	frame, ok := f.(FrameStream)
+	if ok && frame.StreamID == cryptoStreamID {
+		return s.cryptoLevel(frame.Offset) == level
+	}
+	if level != s.sealLevel {
+		return false
+	}
+	return !ok || s.canSendStreamData()
+}
+
+// canSendStreamData reports whether stream data may be sent yet. Clients
+// send 0-RTT data under the initial keys unless 0-RTT is disabled.
+func (s *Session) canSendStreamData() bool {
+	if s.handshakeComplete {
+		return true
+	}
+	return s.isClient && s.sealLevel == encryptionInitial && !s.config.disable0RTT()
+}
+
+// splitStreamFrame returns as much of the frame as fits in size bytes, and
+// the rest of it if it didn't fit completely. The FIN stays with the rest.
+func splitStreamFrame(frame FrameStream, size int) (FrameStream, *FrameStream, bool) {
+	n := size - streamFrameHeaderSize
+	if n >= len(frame.Data) {
+		return frame, nil, true
+	}
+	if n <= 0 {
+		return FrameStream{}, nil, false
+	}
+	rest := frame
+	rest.Offset += uint64(n)
+	rest.Data = frame.Data[n:]
+	frame.Data = frame.Data[:n]
+	frame.Fin = false
+	return frame, &rest, true
+}
+
+// packetTooLarge returns the error for a frame that doesn't fit in an empty
+// packet.
+func (s *Session) packetTooLarge(f Frame, err error) error {
+	if err != nil {
+		return err
+	}
+	return qerr(QUIC_PACKET_TOO_LARGE, "%T doesn't fit in a %d byte packet", f, s.mtu.PacketSize())
+}
@@ -0,0 +1,228 @@
+package quic
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// packetBudget returns the bytes of frames that fit in the session's next
+// packet.
+func packetBudget(s *Session) int {
+	return s.mtu.PacketSize() - maxPacketHeaderSize - s.sealers[s.sealLevel].Overhead()
+}
+
+// framesSize returns the serialized size of frames.
+func framesSize(t *testing.T, frames []Frame) int {
+	n := 0
+	for _, f := range frames {
+		buf, err := f.ToBuf()
+		if err != nil {
+			t.Fatal(err)
+		}
+		n += len(buf)
+	}
+	return n
+}
+
+func TestSplitStreamFrame(t *testing.T) {
+	frame := FrameStream{StreamID: 5, Offset: 100, Data: "hello world", Fin: true}
+
+	got, rest, ok := splitStreamFrame(frame, streamFrameHeaderSize+len(frame.Data))
+	if !ok || rest != nil || got != frame {
+		t.Errorf("splitStreamFrame() with room = %+v, %+v, %t, want the whole frame", got, rest, ok)
+	}
+
+	got, rest, ok = splitStreamFrame(frame, streamFrameHeaderSize+5)
+	if !ok || rest == nil {
+		t.Fatalf("splitStreamFrame() = %+v, %+v, %t, want a split", got, rest, ok)
+	}
+	if want := (FrameStream{StreamID: 5, Offset: 100, Data: "hello"}); got != want {
+		t.Errorf("first part = %+v, want %+v", got, want)
+	}
+	// The FIN stays with the rest.
+	if want := (FrameStream{StreamID: 5, Offset: 105, Data: " world", Fin: true}); *rest != want {
+		t.Errorf("rest = %+v, want %+v", *rest, want)
+	}
+
+	if _, _, ok := splitStreamFrame(frame, streamFrameHeaderSize); ok {
+		t.Error("splitStreamFrame() without room for data succeeded")
+	}
+}
+
+func TestPackPacketStreamData(t *testing.T) {
+	s, _ := newTestSession(t)
+	stream, err := s.OpenStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := strings.Repeat("x", 3000)
+	if _, err := stream.Write([]byte(data)); err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var packets [][]Frame
+	var budget int
+	doSync(s, func() {
+		// Nothing but handshake data goes out before the handshake.
+		frames, _, err := s.packPacket(false)
+		if err != nil || len(frames) != 0 {
+			t.Errorf("packPacket() before the handshake = %+v, %v, want nothing", frames, err)
+		}
+		s.handshakeComplete = true
+		budget = packetBudget(s)
+		for i := 0; i < 4 && err == nil; i++ {
+			frames, _, err = s.packPacket(false)
+			if len(frames) > 0 {
+				packets = append(packets, frames)
+			}
+		}
+		if err != nil {
+			t.Error(err)
+		}
+	})
+
+	var received string
+	for i, frames := range packets {
+		if size := framesSize(t, frames); size > budget {
+			t.Errorf("packet %d has %d bytes of frames, more than %d", i, size, budget)
+		}
+		for _, f := range frames {
+			frame := f.(FrameStream)
+			if frame.Offset != uint64(len(received)) {
+				t.Errorf("packet %d has data at offset %d, want %d", i, frame.Offset, len(received))
+			}
+			received += frame.Data
+			if frame.Fin != (len(received) == len(data)) {
+				t.Errorf("packet %d: FIN = %t with %d of %d bytes sent", i, frame.Fin, len(received), len(data))
+			}
+		}
+	}
+	if len(packets) != 3 || received != data {
+		t.Fatalf("%d bytes sent in %d packets, want %d in 3", len(received), len(packets), len(data))
+	}
+	// Full packets are filled to the byte.
+	if first := packets[0][0].(FrameStream); len(first.Data) != budget-streamFrameHeaderSize {
+		t.Errorf("first packet carries %d bytes of data, want %d", len(first.Data), budget-streamFrameHeaderSize)
+	}
+}
+
+func TestPackPacketOrder(t *testing.T) {
+	s, _ := newTestSession(t)
+	stream, err := s.OpenStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.Write([]byte("data")); err != nil {
+		t.Fatal(err)
+	}
+	var frames []Frame
+	doSync(s, func() {
+		if _, err = s.receivedEntropy.RecordPacket(1, false); err != nil {
+			return
+		}
+		s.ackPending = true
+		s.handshakeComplete = true
+		s.queueFrame(FrameWindowUpdate{StreamID: 5, ByteOffset: 100})
+		frames, _, err = s.packPacket(false)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// ACK and STOP_WAITING, then control frames, then stream data.
+	want := []string{"quic.FrameAck", "quic.FrameStopWaiting", "quic.FrameWindowUpdate", "quic.FrameStream"}
+	if len(frames) != len(want) {
+		t.Fatalf("packed %+v, want %v", frames, want)
+	}
+	for i, f := range frames {
+		if got := fmt.Sprintf("%T", f); got != want[i] {
+			t.Errorf("frame %d is a %s, want %s", i, got, want[i])
+		}
+	}
+}
+
+func TestPackPacketAckOnly(t *testing.T) {
+	s, _ := newTestSession(t)
+	var frames []Frame
+	var pending int
+	var err error
+	doSync(s, func() {
+		if _, err = s.receivedEntropy.RecordPacket(1, false); err != nil {
+			return
+		}
+		s.ackPending = true
+		s.queueFrame(FrameWindowUpdate{StreamID: 5, ByteOffset: 100})
+		frames, _, err = s.packPacket(true)
+		pending = len(s.pendingFrames)
+		s.pendingFrames = nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(frames) != 2 {
+		t.Errorf("ACK-only packet has %+v, want an ACK and a STOP_WAITING", frames)
+	}
+	if pending != 1 {
+		t.Errorf("%d frames queued after an ACK-only packet, want 1", pending)
+	}
+}
+
+func TestPackPacketHandshakePadding(t *testing.T) {
+	s, _ := newTestSession(t)
+	var frames []Frame
+	var budget int
+	var err error
+	doSync(s, func() {
+		s.sendHandshakeMessage(NewHandshakeMessage(TagREJ))
+		budget = packetBudget(s)
+		frames, _, err = s.packPacket(false)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(frames) != 2 || fmt.Sprintf("%T", frames[1]) != "quic.FramePadding" {
+		t.Fatalf("packed %+v, want crypto data and padding", frames)
+	}
+	if size := framesSize(t, frames); size != budget {
+		t.Errorf("handshake packet has %d bytes of frames, want %d", size, budget)
+	}
+}
+
+func TestPackPacketFrameLimits(t *testing.T) {
+	s, _ := newTestSession(t)
+	var budget int
+	doSync(s, func() { budget = packetBudget(s) })
+	half := FrameGoAway{ErrorCode: QUIC_PEER_GOING_AWAY, Reason: strings.Repeat("x", budget/2)}
+
+	// A frame that doesn't fit waits for the next packet.
+	var first, second []Frame
+	var err error
+	doSync(s, func() {
+		s.queueFrame(half)
+		s.queueFrame(half)
+		if first, _, err = s.packPacket(false); err != nil {
+			return
+		}
+		second, _, err = s.packPacket(false)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(first) != 1 || len(second) != 1 {
+		t.Errorf("packed %d and %d frames, want one per packet", len(first), len(second))
+	}
+
+	// A frame that doesn't fit in an empty packet is an error.
+	doSync(s, func() {
+		s.queueFrame(FrameGoAway{ErrorCode: QUIC_PEER_GOING_AWAY, Reason: strings.Repeat("x", budget)})
+		_, _, err = s.packPacket(false)
+		s.pendingFrames = nil
+	})
+	if !errors.Is(err, QUIC_PACKET_TOO_LARGE) {
+		t.Errorf("packPacket() with an oversized frame = %v, want QUIC_PACKET_TOO_LARGE", err)
+	}
+}
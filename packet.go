@@ -1,9 +1,6 @@
 package quic
 
-import (
-	"encoding/binary"
-	"log"
-)
+import "encoding/binary"
 
 // Public Flags
 const (
@@ -49,27 +46,42 @@ type Packet struct {
 	Frames                              []Frame
 }
 
-// ParsePacket parses a byte array and returns the corresponding packet
+// ParsePacket parses an unencrypted packet and returns the corresponding packet
 func ParsePacket(buf []byte) (*Packet, error) {
+	p, n, err := ParsePublicHeader(buf)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.ParsePayload(buf[n:]); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// ParsePublicHeader parses the unencrypted public header of a packet and
+// returns it along with the header length.
+func ParsePublicHeader(buf []byte) (*Packet, int, error) {
+	if len(buf) == 0 {
+		return nil, 0, qerr(QUIC_INVALID_PACKET_HEADER, "empty packet")
+	}
 	p := Packet{}
 	i := 0
 	p.PublicFlags = buf[i]
 	i++
+	headerLen := 1 + connIDLength(p.PublicFlags) + sequenceNumberLength(p.PublicFlags)
+	if p.PublicFlags&QuicVersion == QuicVersion {
+		headerLen += 4
+	}
+	if len(buf) < headerLen {
+		return nil, 0, qerr(QUIC_INVALID_PACKET_HEADER, "packet too short for its %d byte header", headerLen)
+	}
 
 	// Connection ID
-	connIDLen := 0
-	switch p.PublicFlags & ConnIDBitMask {
-	case ConnID8Bytes:
-		connIDLen = 8
-	case ConnID4Bytes:
-		connIDLen = 4
-	case ConnID1Byte:
-		connIDLen = 1
-	}
+	connIDLen := connIDLength(p.PublicFlags)
 	n := 0
 	p.ConnID, n = binary.Uvarint(buf[i : i+connIDLen])
 	if n <= 0 {
-		log.Println("n", n)
+		return nil, 0, qerr(QUIC_INVALID_PACKET_HEADER, "invalid connection ID")
 	}
 	i += connIDLen
 
@@ -77,7 +89,7 @@ func ParsePacket(buf []byte) (*Packet, error) {
 	if p.PublicFlags&QuicVersion == QuicVersion {
 		p.QuicVersion, n = binary.Uvarint(buf[i : i+4])
 		if n <= 0 {
-			log.Println("n", n)
+			return nil, 0, qerr(QUIC_INVALID_PACKET_HEADER, "invalid version")
 		}
 		i += 4
 	}
@@ -85,75 +97,90 @@ func ParsePacket(buf []byte) (*Packet, error) {
 	p.Type = p.PublicFlags & DataPacket
 
 	// Sequence Number
-	sequenceNumberLen := 1
-	switch p.PublicFlags & ConnIDBitMask {
-	case SequenceNumber6Bytes:
-		sequenceNumberLen = 6
-	case SequenceNumber4Bytes:
-		sequenceNumberLen = 4
-	case SequenceNumber2Bytes:
-		sequenceNumberLen = 2
-	}
+	sequenceNumberLen := sequenceNumberLength(p.PublicFlags)
 	p.SequenceNumber, n = binary.Uvarint(buf[i : i+sequenceNumberLen])
 	if n <= 0 {
-		log.Println("n", n)
+		return nil, 0, qerr(QUIC_INVALID_PACKET_HEADER, "invalid packet number")
 	}
 	i += sequenceNumberLen
+	return &p, i, nil
+}
 
+// ParsePayload parses the private flags and frames of a packet, after the
+// public header and once decrypted.
+func (p *Packet) ParsePayload(buf []byte) error {
+	if len(buf) == 0 {
+		return qerr(QUIC_MISSING_PAYLOAD, "packet without a payload")
+	}
+	i := 0
+	n := 0
+	sequenceNumberLen := sequenceNumberLength(p.PublicFlags)
 	p.PrivateFlags = buf[i]
 	i++
 	if p.PrivateFlags&FlagFECGroup > 0 {
+		if len(buf) < i+1 {
+			return qerr(QUIC_INVALID_PACKET_HEADER, "packet too short for its FEC group")
+		}
 		offset := uint64(buf[i])
 		p.FECGroupNumber = p.SequenceNumber - offset
 		i++
 	}
-	// DataPacket
-	if p.Type == 0x0 {
-		log.Println("DATA PACKET")
-	} else if p.PrivateFlags&FlagFEC > 0 {
-		log.Println("TODO: FEC PACKETS")
-		return &p, nil
-	} else {
-		//log.Println("unknown packet type", p.Type)
+	if p.Type != 0x0 && p.PrivateFlags&FlagFEC > 0 {
+		// FEC packets aren't supported, their payload is ignored.
+		return nil
 	}
 	// Frames
 	for i < len(buf) {
 		typeField := buf[i]
 		i++
 		if typeField&StreamFrame > 0 {
-			log.Println("StreamFrame")
 			frame := FrameStream{}
 
 			// Stream ID
 			streamIDLen := int(typeField&StreamIDMask) + 1
+			offsetLen := int(typeField & OffsetMask >> 2)
+			if offsetLen > 0 {
+				offsetLen++
+			}
+			dataLenPresent := typeField&DataLenMask > 0
+			headerLen := streamIDLen + offsetLen
+			if dataLenPresent {
+				headerLen += 2
+			}
+			if len(buf) < i+headerLen {
+				return qerr(QUIC_INVALID_STREAM_DATA, "truncated stream frame")
+			}
 			frame.StreamID, n = binary.Uvarint(buf[i : i+streamIDLen])
 			i += streamIDLen
 			if n <= 0 {
-				log.Println("n", n)
+				return qerr(QUIC_INVALID_STREAM_DATA, "invalid stream ID")
 			}
 
 			// Offset
-			offsetLen := int(typeField & OffsetMask >> 2)
 			if offsetLen > 0 {
-				offsetLen++
 				frame.Offset, n = binary.Uvarint(buf[i : i+offsetLen])
 				i += offsetLen
 				if n <= 0 {
-					log.Println("n", n)
+					return qerr(QUIC_INVALID_STREAM_DATA, "invalid stream offset")
 				}
 			}
 
 			// DataLen
-			dataLenPresent := typeField&DataLenMask > 0
 			if dataLenPresent {
 				frame.DataLen, n = binary.Uvarint(buf[i : i+2])
 				i += 2
+				if n <= 0 {
+					return qerr(QUIC_INVALID_STREAM_DATA, "invalid stream data length")
+				}
 			}
 
 			// Fin
 			frame.Fin = typeField&FinMask > 0
 
 			if dataLenPresent {
+				if len(buf) < i+int(frame.DataLen) {
+					return qerr(QUIC_INVALID_STREAM_DATA, "stream frame data runs past the packet")
+				}
 				frame.Data = string(buf[i : i+int(frame.DataLen)])
 				i += int(frame.DataLen)
 			} else if !frame.Fin {
@@ -163,10 +190,13 @@ func ParsePacket(buf []byte) (*Packet, error) {
 			p.Frames = append(p.Frames, frame)
 			continue
 		} else if typeField&AckFrameMask == AckFrame {
-			log.Println("AckFrame")
-			frame := FrameAck{}
-
+			frame, n, err := parseAckFrame(typeField, buf[i:])
+			if err != nil {
+				return err
+			}
+			i += n
 			p.Frames = append(p.Frames, frame)
+			continue
 		} else if typeField&CongestionFeedbackFrameMask == CongestionFeedbackFrame {
 			/*log.Println("CongestionFeedbackFrame")
 			frame := FrameCongestionFeedback{}
@@ -176,113 +206,308 @@ func ParsePacket(buf []byte) (*Packet, error) {
 		} else {
 			switch typeField {
 			case PaddingFrame:
-				log.Println("PaddingFrame")
-				p.Frames = append(p.Frames, &FramePadding{})
-				// reset of packet is padding, nothing needs to happen
-				break
+				p.Frames = append(p.Frames, &FramePadding{Size: len(buf) - i + 1})
+				// The rest of the packet is padding.
+				i = len(buf)
+				continue
 			case ResetStreamFrame:
-				log.Println("ResetStreamFrame")
+				if len(buf) < i+4+8+4 {
+					return qerr(QUIC_INVALID_RST_STREAM_DATA, "truncated RST_STREAM frame")
+				}
 				frame := FrameResetStream{}
 				frame.StreamID, n = binary.Uvarint(buf[i : i+4])
 				i += 4
 				if n <= 0 {
-					log.Println("n", n)
+					return qerr(QUIC_INVALID_RST_STREAM_DATA, "invalid stream ID")
+				}
+				frame.ByteOffset, n = binary.Uvarint(buf[i : i+8])
+				i += 8
+				if n <= 0 {
+					return qerr(QUIC_INVALID_RST_STREAM_DATA, "invalid byte offset")
 				}
-				frame.ErrorCode, n = binary.Uvarint(buf[i : i+4])
+				code, n := binary.Uvarint(buf[i : i+4])
+				frame.ErrorCode = StreamErrorCode(code)
 				i += 4
 				if n <= 0 {
-					log.Println("n", n)
+					return qerr(QUIC_INVALID_RST_STREAM_DATA, "invalid error code")
 				}
 				p.Frames = append(p.Frames, frame)
 				continue
 			case ConnectionCloseFrame:
-				log.Println("ConnectionCloseFrame")
+				if len(buf) < i+4+2 {
+					return qerr(QUIC_INVALID_CONNECTION_CLOSE_DATA, "truncated CONNECTION_CLOSE frame")
+				}
 				frame := FrameConnectionClose{}
-				frame.ErrorCode, n = binary.Uvarint(buf[i : i+4])
+				code, n := binary.Uvarint(buf[i : i+4])
+				frame.ErrorCode = ErrorCode(code)
 				i += 4
 				if n <= 0 {
-					log.Println("n", n)
+					return qerr(QUIC_INVALID_CONNECTION_CLOSE_DATA, "invalid error code")
 				}
-				length, n2 := binary.Uvarint(buf[i : i+2])
+				length, n := binary.Uvarint(buf[i : i+2])
 				i += 2
-				if n2 <= 0 {
-					log.Println("n", n)
+				if n <= 0 {
+					return qerr(QUIC_INVALID_CONNECTION_CLOSE_DATA, "invalid reason length")
+				}
+				if uint64(len(buf)-i) < length {
+					return qerr(QUIC_INVALID_CONNECTION_CLOSE_DATA, "CONNECTION_CLOSE reason runs past the packet")
 				}
 				frame.Reason = string(buf[i : i+int(length)])
 				i += int(length)
 				p.Frames = append(p.Frames, frame)
 				continue
 			case GoAwayFrame:
-				log.Println("GoAwayFrame")
+				if len(buf) < i+4+4+2 {
+					return qerr(QUIC_INVALID_GOAWAY_DATA, "truncated GOAWAY frame")
+				}
 				frame := FrameGoAway{}
-				frame.ErrorCode, n = binary.Uvarint(buf[i : i+4])
+				code, n := binary.Uvarint(buf[i : i+4])
+				frame.ErrorCode = ErrorCode(code)
 				i += 4
 				if n <= 0 {
-					log.Println("n", n)
+					return qerr(QUIC_INVALID_GOAWAY_DATA, "invalid error code")
 				}
 				frame.LastGoodStreamID, n = binary.Uvarint(buf[i : i+4])
 				i += 4
 				if n <= 0 {
-					log.Println("n", n)
+					return qerr(QUIC_INVALID_GOAWAY_DATA, "invalid last good stream ID")
 				}
-				length, n2 := binary.Uvarint(buf[i : i+2])
+				length, n := binary.Uvarint(buf[i : i+2])
 				i += 2
-				if n2 <= 0 {
-					log.Println("n", n)
+				if n <= 0 {
+					return qerr(QUIC_INVALID_GOAWAY_DATA, "invalid reason length")
+				}
+				if uint64(len(buf)-i) < length {
+					return qerr(QUIC_INVALID_GOAWAY_DATA, "GOAWAY reason runs past the packet")
 				}
 				frame.Reason = string(buf[i : i+int(length)])
 				i += int(length)
 				p.Frames = append(p.Frames, frame)
 				continue
 			case WindowUpdateFrame:
-				log.Println("WindowUpdateFrame")
+				if len(buf) < i+4+8 {
+					return qerr(QUIC_INVALID_WINDOW_UPDATE_DATA, "truncated WINDOW_UPDATE frame")
+				}
 				frame := FrameWindowUpdate{}
 				frame.StreamID, n = binary.Uvarint(buf[i : i+4])
 				i += 4
 				if n <= 0 {
-					log.Println("n", n)
+					return qerr(QUIC_INVALID_WINDOW_UPDATE_DATA, "invalid stream ID")
 				}
 				frame.ByteOffset, n = binary.Uvarint(buf[i : i+8])
 				i += 8
 				if n <= 0 {
-					log.Println("n", n)
+					return qerr(QUIC_INVALID_WINDOW_UPDATE_DATA, "invalid byte offset")
 				}
 				p.Frames = append(p.Frames, frame)
 				continue
 			case BlockedFrame:
-				log.Println("BlockedFrame")
+				if len(buf) < i+4 {
+					return qerr(QUIC_INVALID_BLOCKED_DATA, "truncated BLOCKED frame")
+				}
 				frame := FrameBlocked{}
 				frame.StreamID, n = binary.Uvarint(buf[i : i+4])
 				i += 4
 				if n <= 0 {
-					log.Println("n", n)
+					return qerr(QUIC_INVALID_BLOCKED_DATA, "invalid stream ID")
 				}
 				p.Frames = append(p.Frames, frame)
 				continue
 			case StopWaitingFrame:
-				log.Println("StopWaitingFrame")
+				if len(buf) < i+1+sequenceNumberLen {
+					return qerr(QUIC_INVALID_STOP_WAITING_DATA, "truncated STOP_WAITING frame")
+				}
 				frame := FrameStopWaiting{}
 				frame.SentEntropy = buf[i]
 				i++
 				frame.LeastUnackedDelta, n = binary.Uvarint(buf[i : i+sequenceNumberLen])
 				i += sequenceNumberLen
 				if n <= 0 {
-					log.Println("n", n)
+					return qerr(QUIC_INVALID_STOP_WAITING_DATA, "invalid least unacked delta")
 				}
 				p.Frames = append(p.Frames, frame)
 				continue
 			case PingFrame:
-				log.Println("PingFrame")
 				p.Frames = append(p.Frames, &FramePing{})
 				continue
 			default:
-				log.Println("UnknownFrame", typeField)
+				return qerr(QUIC_INVALID_FRAME_DATA, "unknown frame type %#x", typeField)
 			}
 		}
-		log.Println("UNHANDLED FRAME BREAKING!", typeField)
-		break
 	}
-	//log.Println("Remainder", string(buf[i:]))
+	return nil
+}
+
+// parseAckFrame parses the body of an ACK frame following its type byte. It
+// returns the frame and the number of bytes it took up.
+func parseAckFrame(typeField byte, buf []byte) (FrameAck, int, error) {
+	frame := FrameAck{Truncated: typeField&TruncatedMask > 0}
+	largestObservedLen := packetNumberLength(typeField & LargestObservedSequenceNumberLenMask >> 2)
+	missingDeltaLen := packetNumberLength(typeField & SequenceNumberDeltaLenMask)
+	i := 0
+	need := func(n int) error {
+		if len(buf)-i < n {
+			return qerr(QUIC_INVALID_ACK_DATA, "truncated ack frame")
+		}
+		return nil
+	}
+	uvarint := func(n int) (uint64, error) {
+		if err := need(n); err != nil {
+			return 0, err
+		}
+		v, m := binary.Uvarint(buf[i : i+n])
+		if m <= 0 {
+			return 0, qerr(QUIC_INVALID_ACK_DATA, "invalid packet number in ack frame")
+		}
+		i += n
+		return v, nil
+	}
+
+	if err := need(1); err != nil {
+		return frame, 0, err
+	}
+	frame.ReceivedEntropy = buf[i]
+	i++
+	var err error
+	if frame.LargestObserved, err = uvarint(largestObservedLen); err != nil {
+		return frame, 0, err
+	}
+	if err := need(2 + 1); err != nil {
+		return frame, 0, err
+	}
+	frame.LargestObservedDeltaTime = ufloat16ToUint64(binary.LittleEndian.Uint16(buf[i : i+2]))
+	i += 2
+
+	// Timestamps aren't sent, skip over any the peer included.
+	numTimestamps := int(buf[i])
+	i++
+	if numTimestamps > 0 {
+		n := 1 + 4 + (numTimestamps-1)*(1+2)
+		if err := need(n); err != nil {
+			return frame, 0, err
+		}
+		i += n
+	}
+	if typeField&NackMask == 0 {
+		return frame, i, nil
+	}
+
+	// Missing Packet Ranges
+	if err := need(1); err != nil {
+		return frame, 0, err
+	}
+	numRanges := int(buf[i])
+	i++
+	next := frame.LargestObserved
+	for r := 0; r < numRanges; r++ {
+		delta, err := uvarint(missingDeltaLen)
+		if err != nil {
+			return frame, 0, err
+		}
+		if err := need(1); err != nil {
+			return frame, 0, err
+		}
+		rangeLength := uint64(buf[i])
+		i++
+		// Missing packets lie between 1 and the largest observed one.
+		if delta == 0 || delta > next || rangeLength >= next-delta {
+			return frame, 0, qerr(QUIC_INVALID_ACK_DATA, "missing packet range out of bounds")
+		}
+		next -= delta
+		for j := uint64(0); j <= rangeLength; j++ {
+			frame.MissingPackets = append(frame.MissingPackets, next-j)
+		}
+		next -= rangeLength
+	}
+	// Revived packets are an FEC feature, skip over them.
+	if err := need(1); err != nil {
+		return frame, 0, err
+	}
+	numRevived := int(buf[i])
+	i++
+	if err := need(numRevived * largestObservedLen); err != nil {
+		return frame, 0, err
+	}
+	return frame, i + numRevived*largestObservedLen, nil
+}
 
-	return &p, nil
+// ToBuf serializes an unencrypted packet into a byte array
+func (p *Packet) ToBuf() ([]byte, error) {
+	payload, err := p.PayloadToBuf()
+	if err != nil {
+		return nil, err
+	}
+	return append(p.PublicHeaderToBuf(), payload...), nil
+}
+
+// PublicHeaderToBuf serializes the public header of a packet.
+func (p *Packet) PublicHeaderToBuf() []byte {
+	connIDLen := connIDLength(p.PublicFlags)
+	sequenceNumberLen := sequenceNumberLength(p.PublicFlags)
+	headerLen := 1 + connIDLen + sequenceNumberLen
+	if p.PublicFlags&QuicVersion == QuicVersion {
+		headerLen += 4
+	}
+	buf := make([]byte, headerLen)
+	i := 0
+	buf[i] = p.PublicFlags
+	i++
+	if connIDLen > 0 {
+		binary.PutUvarint(buf[i:i+connIDLen], p.ConnID)
+		i += connIDLen
+	}
+	if p.PublicFlags&QuicVersion == QuicVersion {
+		binary.PutUvarint(buf[i:i+4], p.QuicVersion)
+		i += 4
+	}
+	binary.PutUvarint(buf[i:i+sequenceNumberLen], p.SequenceNumber)
+	return buf
+}
+
+// PayloadToBuf serializes the private flags and frames of a packet, the part
+// that gets encrypted.
+func (p *Packet) PayloadToBuf() ([]byte, error) {
+	buf := []byte{p.PrivateFlags}
+	if p.PrivateFlags&FlagFECGroup > 0 {
+		buf = append(buf, byte(p.SequenceNumber-p.FECGroupNumber))
+	}
+	for _, f := range p.Frames {
+		frameBuf, err := f.ToBuf()
+		if err != nil {
+			return nil, err
+		}
+		buf = append(buf, frameBuf...)
+	}
+	return buf, nil
+}
+
+// connIDLength returns the number of connection ID bytes indicated by the public flags.
+func connIDLength(publicFlags byte) int {
+	switch publicFlags & ConnIDBitMask {
+	case ConnID8Bytes:
+		return 8
+	case ConnID4Bytes:
+		return 4
+	case ConnID1Byte:
+		return 1
+	}
+	return 0
+}
+
+// sequenceNumberLength returns the number of sequence number bytes indicated by the public flags.
+func sequenceNumberLength(publicFlags byte) int {
+	return packetNumberLength(publicFlags & SequenceNumberBitMask >> 4)
+}
+
+// packetNumberLength converts a 2 bit length field into the number of bytes it represents.
+func packetNumberLength(bits byte) int {
+	switch bits {
+	case 3:
+		return 6
+	case 2:
+		return 4
+	case 1:
+		return 2
+	}
+	return 1
 }
@@ -0,0 +1,143 @@
+package quic
+
+import (
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// ackHeader returns the type byte and fixed fields of an ACK frame with 6
+// byte packet numbers.
+func ackHeader(nack bool, largestObserved uint64) []byte {
+	typeField := byte(AckFrame | LargestObservedSequenceNumberLenMask | SequenceNumberDeltaLenMask)
+	if nack {
+		typeField |= NackMask
+	}
+	buf := []byte{typeField, 0x42}
+	buf = append(buf, make([]byte, 6)...)
+	binary.PutUvarint(buf[2:8], largestObserved)
+	// Delta time and no timestamps.
+	return append(buf, 0, 0, 0)
+}
+
+func TestParseAckFrame(t *testing.T) {
+	valid, err := FrameAck{ReceivedEntropy: 0x42, LargestObserved: 10, MissingPackets: []uint64{9, 5, 4}}.ToBuf()
+	if err != nil {
+		t.Fatal(err)
+	}
+	truncatedRanges := append(ackHeader(true, 10), 2)
+	truncatedRanges = append(truncatedRanges, 1, 0, 0, 0, 0, 0, 0)
+	oversizedRange := append(ackHeader(true, 10), 1)
+	oversizedRange = append(oversizedRange, 1, 0, 0, 0, 0, 0, 200, 0)
+	deltaPastZero := append(ackHeader(true, 10), 1)
+	deltaPastZero = append(deltaPastZero, 11, 0, 0, 0, 0, 0, 0, 0)
+	zeroDelta := append(ackHeader(true, 10), 1)
+	zeroDelta = append(zeroDelta, 0, 0, 0, 0, 0, 0, 0, 0)
+	manyRevived := append(ackHeader(true, 10), 0, 0xff)
+	manyTimestamps := ackHeader(false, 10)
+	manyTimestamps[len(manyTimestamps)-1] = 0xff
+
+	tests := []struct {
+		name    string
+		payload []byte
+		missing []uint64
+		wantErr bool
+	}{
+		{"valid", valid, []uint64{9, 5, 4}, false},
+		{"no missing packets", ackHeader(false, 10), nil, false},
+		{"type byte only", []byte{0x4c}, nil, true},
+		{"truncated largest observed", []byte{0x4c, 0x00}, nil, true},
+		{"truncated delta time", ackHeader(false, 10)[:9], nil, true},
+		{"truncated timestamps", manyTimestamps, nil, true},
+		{"missing range count", ackHeader(true, 10), nil, true},
+		{"truncated ranges", truncatedRanges, nil, true},
+		{"missing revived count", valid[:len(valid)-1], nil, true},
+		{"truncated revived packets", manyRevived, nil, true},
+		{"range below packet 1", oversizedRange, nil, true},
+		{"delta past largest observed", deltaPastZero, nil, true},
+		{"zero delta", zeroDelta, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &Packet{PublicFlags: SequenceNumber6Bytes}
+			err := p.ParsePayload(append([]byte{0}, tt.payload...))
+			if tt.wantErr {
+				if !errors.Is(err, QUIC_INVALID_ACK_DATA) {
+					t.Fatalf("ParsePayload() = %v, want QUIC_INVALID_ACK_DATA", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(p.Frames) != 1 {
+				t.Fatalf("got %d frames, want 1", len(p.Frames))
+			}
+			ack := p.Frames[0].(FrameAck)
+			if ack.LargestObserved != 10 || ack.ReceivedEntropy != 0x42 {
+				t.Errorf("got %+v", ack)
+			}
+			if !reflect.DeepEqual(ack.MissingPackets, tt.missing) {
+				t.Errorf("MissingPackets = %v, want %v", ack.MissingPackets, tt.missing)
+			}
+		})
+	}
+}
+
+func TestParsePayloadTruncatedFrames(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload []byte
+		code    ErrorCode
+	}{
+		{"stream header", []byte{StreamFrame | 0x3f, 1, 0}, QUIC_INVALID_STREAM_DATA},
+		{"stream data", []byte{StreamFrame | DataLenMask, 5, 10, 0, 'a'}, QUIC_INVALID_STREAM_DATA},
+		{"rst_stream", []byte{ResetStreamFrame, 1, 0, 0}, QUIC_INVALID_RST_STREAM_DATA},
+		{"connection_close", []byte{ConnectionCloseFrame, 0, 0}, QUIC_INVALID_CONNECTION_CLOSE_DATA},
+		{"connection_close reason", []byte{ConnectionCloseFrame, 0, 0, 0, 0, 50, 0, 'a'}, QUIC_INVALID_CONNECTION_CLOSE_DATA},
+		{"goaway", []byte{GoAwayFrame, 0, 0, 0, 0, 0}, QUIC_INVALID_GOAWAY_DATA},
+		{"goaway reason", []byte{GoAwayFrame, 0, 0, 0, 0, 0, 0, 0, 0, 50, 0}, QUIC_INVALID_GOAWAY_DATA},
+		{"window_update", []byte{WindowUpdateFrame, 0, 0, 0, 0, 0}, QUIC_INVALID_WINDOW_UPDATE_DATA},
+		{"blocked", []byte{BlockedFrame, 0}, QUIC_INVALID_BLOCKED_DATA},
+		{"stop_waiting", []byte{StopWaitingFrame, 0, 0}, QUIC_INVALID_STOP_WAITING_DATA},
+		{"unterminated stream ID", []byte{StreamFrame, 0x80, 'a'}, QUIC_INVALID_STREAM_DATA},
+		{"unterminated window_update stream ID", []byte{WindowUpdateFrame, 0x80, 0x80, 0x80, 0x80, 0, 0, 0, 0, 0, 0, 0, 0}, QUIC_INVALID_WINDOW_UPDATE_DATA},
+		{"unknown frame type", []byte{PingFrame + 1}, QUIC_INVALID_FRAME_DATA},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &Packet{PublicFlags: SequenceNumber6Bytes}
+			if err := p.ParsePayload(append([]byte{0}, tt.payload...)); !errors.Is(err, tt.code) {
+				t.Fatalf("ParsePayload() = %v, want %s", err, tt.code)
+			}
+		})
+	}
+}
+
+func TestParsePacketTooShort(t *testing.T) {
+	tests := []struct {
+		name string
+		buf  []byte
+		code ErrorCode
+	}{
+		{"empty", nil, QUIC_INVALID_PACKET_HEADER},
+		{"truncated header", []byte{ConnID8Bytes | SequenceNumber6Bytes, 1, 0, 0}, QUIC_INVALID_PACKET_HEADER},
+		{"no payload", []byte{ConnID8Bytes | SequenceNumber6Bytes, 1, 0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0}, QUIC_MISSING_PAYLOAD},
+		{"truncated FEC group", []byte{ConnID8Bytes | SequenceNumber6Bytes, 1, 0, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0, 0, 0, FlagFECGroup}, QUIC_INVALID_PACKET_HEADER},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParsePacket(tt.buf); !errors.Is(err, tt.code) {
+				t.Fatalf("ParsePacket() = %v, want %s", err, tt.code)
+			}
+		})
+	}
+}
+
+func TestParsePublicHeaderInvalidConnID(t *testing.T) {
+	buf := []byte{ConnID8Bytes, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 1}
+	if _, _, err := ParsePublicHeader(buf); !errors.Is(err, QUIC_INVALID_PACKET_HEADER) {
+		t.Fatalf("ParsePublicHeader() = %v, want QUIC_INVALID_PACKET_HEADER", err)
+	}
+}
@@ -0,0 +1,159 @@
+package quic
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+)
+
+// proofSignatureLabel prefixes the server config a proof signs.
+var proofSignatureLabel = []byte("QUIC server config signature\x00")
+
+// errNoCertificate is returned by the default ProofSource when it has no
+// certificates.
+var errNoCertificate = errors.New("quic: no certificate configured")
+
+// ProofSource provides the certificates a server proves its identity with.
+// The signing key doesn't have to be local, Sign may call out to a key
+// service.
+type ProofSource interface {
+	// GetCertChain returns the DER certificate chain for the server name
+	// the client asked for, leaf first.
+	GetCertChain(sni string) ([][]byte, error)
+	// Sign signs a serialized server config with the key of the chain
+	// returned for sni. RSA keys sign with PSS and ECDSA keys with ASN.1
+	// signatures, both over SHA-256, Ed25519 keys sign the message itself.
+	Sign(sni string, serverConfig []byte) ([]byte, error)
+}
+
+// tlsProofSource is a ProofSource picking certificates like crypto/tls does.
+type tlsProofSource struct {
+	config *tls.Config
+}
+
+// NewTLSProofSource returns a ProofSource serving the certificates of a TLS
+// config. The certificate for a server name is picked by GetCertificate if
+// set, and otherwise is the first of Certificates valid for the name.
+func NewTLSProofSource(config *tls.Config) ProofSource {
+	return &tlsProofSource{config: config}
+}
+
+// certificate returns the certificate for sni.
+func (p *tlsProofSource) certificate(sni string) (*tls.Certificate, error) {
+	if p.config.GetCertificate != nil {
+		cert, err := p.config.GetCertificate(&tls.ClientHelloInfo{ServerName: sni})
+		if err != nil || cert != nil {
+			return cert, err
+		}
+	}
+	if len(p.config.Certificates) == 0 {
+		return nil, errNoCertificate
+	}
+	for i := range p.config.Certificates {
+		cert := &p.config.Certificates[i]
+		leaf := cert.Leaf
+		if leaf == nil {
+			var err error
+			if leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+				continue
+			}
+		}
+		if leaf.VerifyHostname(sni) == nil {
+			return cert, nil
+		}
+	}
+	return &p.config.Certificates[0], nil
+}
+
+func (p *tlsProofSource) GetCertChain(sni string) ([][]byte, error) {
+	cert, err := p.certificate(sni)
+	if err != nil {
+		return nil, err
+	}
+	return cert.Certificate, nil
+}
+
+func (p *tlsProofSource) Sign(sni string, serverConfig []byte) ([]byte, error) {
+	cert, err := p.certificate(sni)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := cert.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("quic: certificate key can't sign")
+	}
+	return signProof(signer, serverConfig)
+}
+
+// signProof signs a server config with key.
+func signProof(key crypto.Signer, serverConfig []byte) ([]byte, error) {
+	msg := append(append([]byte(nil), proofSignatureLabel...), serverConfig...)
+	if _, ok := key.Public().(ed25519.PublicKey); ok {
+		return key.Sign(rand.Reader, msg, crypto.Hash(0))
+	}
+	digest := sha256.Sum256(msg)
+	var opts crypto.SignerOpts = crypto.SHA256
+	if _, ok := key.Public().(*rsa.PublicKey); ok {
+		opts = &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: crypto.SHA256}
+	}
+	return key.Sign(rand.Reader, digest[:], opts)
+}
+
+// verifyProof checks that the certificate chain is valid for serverName and
+// that its leaf signed the server config. Chain verification uses the roots
+// of config and is skipped if it sets InsecureSkipVerify, the signature is
+// always checked.
+func verifyProof(chain [][]byte, serverConfig, signature []byte, serverName string, config *tls.Config) error {
+	if len(chain) == 0 {
+		return qerr(QUIC_PROOF_INVALID, "no certificate chain")
+	}
+	certs := make([]*x509.Certificate, len(chain))
+	for i, der := range chain {
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return qerr(QUIC_PROOF_INVALID, "invalid certificate: %v", err)
+		}
+		certs[i] = cert
+	}
+	if config == nil || !config.InsecureSkipVerify {
+		opts := x509.VerifyOptions{
+			DNSName:       serverName,
+			Intermediates: x509.NewCertPool(),
+		}
+		if config != nil {
+			opts.Roots = config.RootCAs
+			if config.ServerName != "" {
+				opts.DNSName = config.ServerName
+			}
+		}
+		for _, cert := range certs[1:] {
+			opts.Intermediates.AddCert(cert)
+		}
+		if _, err := certs[0].Verify(opts); err != nil {
+			return qerr(QUIC_PROOF_INVALID, "%v", err)
+		}
+	}
+
+	msg := append(append([]byte(nil), proofSignatureLabel...), serverConfig...)
+	digest := sha256.Sum256(msg)
+	valid := false
+	switch pub := certs[0].PublicKey.(type) {
+	case *rsa.PublicKey:
+		opts := &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: crypto.SHA256}
+		valid = rsa.VerifyPSS(pub, crypto.SHA256, digest[:], signature, opts) == nil
+	case *ecdsa.PublicKey:
+		valid = ecdsa.VerifyASN1(pub, digest[:], signature)
+	case ed25519.PublicKey:
+		valid = ed25519.Verify(pub, msg, signature)
+	}
+	if !valid {
+		return qerr(QUIC_PROOF_INVALID, "invalid server config signature")
+	}
+	return nil
+}
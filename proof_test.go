@@ -0,0 +1,184 @@
+package quic
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+// selfSignedCert returns a certificate for name signed by its own key.
+func selfSignedCert(t *testing.T, key crypto.Signer, name string) tls.Certificate {
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		DNSNames:              []string{name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestProofSignVerify(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, ed25519Key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverConfig := []byte("server config")
+	for _, tt := range []struct {
+		name string
+		key  crypto.Signer
+	}{
+		{"RSA", rsaKey},
+		{"ECDSA", ecdsaKey},
+		{"Ed25519", ed25519Key},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			cert := selfSignedCert(t, tt.key, "example.com")
+			roots := x509.NewCertPool()
+			leaf, err := x509.ParseCertificate(cert.Certificate[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			roots.AddCert(leaf)
+			proofs := NewTLSProofSource(&tls.Config{Certificates: []tls.Certificate{cert}})
+			chain, err := proofs.GetCertChain("example.com")
+			if err != nil {
+				t.Fatal(err)
+			}
+			signature, err := proofs.Sign("example.com", serverConfig)
+			if err != nil {
+				t.Fatal(err)
+			}
+			trusted := &tls.Config{RootCAs: roots}
+			if err := verifyProof(chain, serverConfig, signature, "example.com", trusted); err != nil {
+				t.Errorf("verifyProof() = %v", err)
+			}
+
+			tampered := append([]byte(nil), serverConfig...)
+			tampered[0] ^= 0xff
+			badSignature := append([]byte(nil), signature...)
+			badSignature[len(badSignature)-1] ^= 0xff
+			for _, bad := range []struct {
+				name         string
+				serverConfig []byte
+				signature    []byte
+				serverName   string
+				config       *tls.Config
+			}{
+				{"tampered server config", tampered, signature, "example.com", trusted},
+				{"tampered signature", serverConfig, badSignature, "example.com", trusted},
+				{"no signature", serverConfig, nil, "example.com", trusted},
+				{"wrong server name", serverConfig, signature, "example.org", trusted},
+				{"untrusted root", serverConfig, signature, "example.com", &tls.Config{RootCAs: x509.NewCertPool()}},
+				{"tampered, skipping chain verification", tampered, signature, "example.com", &tls.Config{InsecureSkipVerify: true}},
+			} {
+				err := verifyProof(chain, bad.serverConfig, bad.signature, bad.serverName, bad.config)
+				if !errors.Is(err, QUIC_PROOF_INVALID) {
+					t.Errorf("verifyProof() with %s = %v, want QUIC_PROOF_INVALID", bad.name, err)
+				}
+			}
+		})
+	}
+}
+
+func TestVerifyProofInvalidChain(t *testing.T) {
+	config := &tls.Config{InsecureSkipVerify: true}
+	for _, chain := range [][][]byte{nil, {[]byte("not a certificate")}} {
+		if err := verifyProof(chain, []byte("server config"), []byte("signature"), "example.com", config); !errors.Is(err, QUIC_PROOF_INVALID) {
+			t.Errorf("verifyProof(%q) = %v, want QUIC_PROOF_INVALID", chain, err)
+		}
+	}
+}
+
+func TestTLSProofSourceServerName(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	com := selfSignedCert(t, key, "example.com")
+	org := selfSignedCert(t, key, "example.org")
+	proofs := NewTLSProofSource(&tls.Config{Certificates: []tls.Certificate{com, org}})
+	tests := []struct {
+		sni  string
+		want tls.Certificate
+	}{
+		{"example.com", com},
+		{"example.org", org},
+		// Unknown names get the first certificate.
+		{"example.net", com},
+		{"", com},
+	}
+	for _, tt := range tests {
+		chain, err := proofs.GetCertChain(tt.sni)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(chain[0]) != string(tt.want.Certificate[0]) {
+			t.Errorf("GetCertChain(%q) returned the wrong certificate", tt.sni)
+		}
+	}
+
+	if _, err := NewTLSProofSource(&tls.Config{}).GetCertChain("example.com"); err != errNoCertificate {
+		t.Errorf("GetCertChain() without certificates = %v, want %v", err, errNoCertificate)
+	}
+}
+
+func TestListenerProofSource(t *testing.T) {
+	custom := NewTLSProofSource(testTLSConfig(t))
+	tests := []struct {
+		name   string
+		config *Config
+		want   func(ProofSource) bool
+	}{
+		{"none", nil, func(p ProofSource) bool { return p == nil }},
+		{"ProofSource", &Config{ProofSource: custom}, func(p ProofSource) bool { return p == custom }},
+		{"TLSConfig", &Config{TLSConfig: testTLSConfig(t)}, func(p ProofSource) bool {
+			_, ok := p.(*tlsProofSource)
+			return ok
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			l, err := NewListener(conn, tt.config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer l.Close()
+			// Sessions share the listener's proof source.
+			if !tt.want(l.crypto.proofs) {
+				t.Errorf("listener proof source = %#v", l.crypto.proofs)
+			}
+		})
+	}
+}
@@ -1,56 +1,187 @@
 package quic
 
 import (
+	"context"
+	"errors"
 	"log"
 	"net"
+	"sync"
+	"time"
 )
 
+// errListenerClosed is returned by Accept once the listener is closed.
+var errListenerClosed = errors.New("quic: listener closed")
+
 // Listener represents a QUIC connection
 type Listener struct {
-	udp *net.UDPConn
+	conn   net.PacketConn
+	config *Config
+	crypto *serverCrypto
+
+	mu           sync.Mutex
+	sessions     map[uint64]*Session
+	newSessions  chan *Session
+	shuttingDown bool
+	// drained is closed when the last session closes during Shutdown.
+	drained   chan struct{}
+	closeOnce sync.Once
+	closeChan chan struct{}
 }
 
 // Close closes the QUIC Listener
 func (l *Listener) Close() {
-	l.udp.Close()
+	l.closeOnce.Do(func() {
+		close(l.closeChan)
+	})
+	l.conn.Close()
+}
+
+// Accept waits for a new session.
+func (l *Listener) Accept() (*Session, error) {
+	select {
+	case s := <-l.newSessions:
+		return s, nil
+	case <-l.closeChan:
+		return nil, errListenerClosed
+	}
+}
+
+// Shutdown gracefully shuts down the listener. Every session is sent a
+// GOAWAY so the peer stops opening streams, and each session is closed once
+// its in-flight streams finish. The socket is closed after all sessions are.
+// If ctx expires first, the remaining sessions are closed immediately and
+// ctx's error is returned.
+func (l *Listener) Shutdown(ctx context.Context) error {
+	drained := make(chan struct{})
+	l.mu.Lock()
+	l.shuttingDown = true
+	if len(l.sessions) == 0 {
+		close(drained)
+	} else {
+		l.drained = drained
+	}
+	l.mu.Unlock()
+	for _, s := range l.openSessions() {
+		s.shutdown()
+	}
+
+	select {
+	case <-drained:
+		l.Close()
+		return nil
+	case <-ctx.Done():
+		for _, s := range l.openSessions() {
+			s.CloseWithError(QUIC_PEER_GOING_AWAY, "server shutting down")
+		}
+		l.Close()
+		return ctx.Err()
+	}
+}
+
+// openSessions returns the sessions that haven't closed yet.
+func (l *Listener) openSessions() []*Session {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	sessions := make([]*Session, 0, len(l.sessions))
+	for _, s := range l.sessions {
+		sessions = append(sessions, s)
+	}
+	return sessions
+}
+
+// removeSession forgets about a closed session, and signals Shutdown once
+// the last one is gone.
+func (l *Listener) removeSession(connID uint64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.sessions, connID)
+	if l.drained != nil && len(l.sessions) == 0 {
+		close(l.drained)
+		l.drained = nil
+	}
 }
 
 // Handle is an internal goroutine that handles input.
 func (l *Listener) Handle() {
-	i := 0
+	buf := make([]byte, maxUDPPayloadSize)
 	for {
-		i++
-		buf := make([]byte, 4096)
-		log.Println("Reading")
-		rlen, _, err := l.udp.ReadFromUDP(buf)
+		rlen, addr, err := l.conn.ReadFrom(buf)
 		if err != nil {
 			log.Println(err)
+			return
 		}
-		p, err := ParsePacket(buf[0:rlen])
+		p, headerLen, err := ParsePublicHeader(buf[0:rlen])
 		if err != nil {
-			log.Println(err)
+			// Not a QUIC packet, or not one we can route.
 			continue
 		}
-		log.Printf("%#v\n", string(buf[0:rlen]))
-		log.Printf("%d %#v\n", i, p)
+		if s := l.session(p.ConnID, addr); s != nil {
+			data := append([]byte(nil), buf[0:rlen]...)
+			s.queuePacket(receivedPacket{packet: p, addr: addr, size: rlen, data: data, headerLen: headerLen})
+		}
+	}
+}
+
+// session returns the session for connID, creating it if it doesn't exist.
+// It returns nil if the listener is shutting down and doesn't accept new
+// sessions.
+func (l *Listener) session(connID uint64, addr net.Addr) *Session {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	s, ok := l.sessions[connID]
+	if !ok {
+		if l.shuttingDown {
+			return nil
+		}
+		s = newSession(l.conn, addr, connID, l.config, l.crypto, func() {
+			l.removeSession(connID)
+		})
+		l.sessions[connID] = s
+		select {
+		case l.newSessions <- s:
+		default:
+			log.Println("accept queue full, dropping session", connID)
+			go s.Close()
+		}
 	}
+	return s
 }
 
 // Listen to a specific address
 func Listen(port int) (*Listener, error) {
+	return ListenConfig(port, nil)
+}
+
+// ListenConfig is like Listen, with a config for the listener's sessions.
+// config may be nil to use the defaults.
+func ListenConfig(port int, config *Config) (*Listener, error) {
 
 	addr := net.UDPAddr{
 		Port: port,
 		IP:   net.ParseIP("127.0.0.1"),
 	}
-	log.Println("Port", port)
 	conn, err := net.ListenUDP("udp", &addr)
 	if err != nil {
 		return nil, err
 	}
-	c := Listener{
-		udp: conn,
+	return NewListener(conn, config)
+}
+
+// NewListener returns a listener accepting sessions on conn. config may be
+// nil to use the defaults.
+func NewListener(conn net.PacketConn, config *Config) (*Listener, error) {
+	crypto, err := newServerCrypto(time.Now, config.proofSource())
+	if err != nil {
+		return nil, err
+	}
+	l := &Listener{
+		conn:        conn,
+		config:      config,
+		crypto:      crypto,
+		sessions:    map[uint64]*Session{},
+		newSessions: make(chan *Session, 100),
+		closeChan:   make(chan struct{}),
 	}
-	go c.Handle()
-	return &c, nil
+	go l.Handle()
+	return l, nil
 }
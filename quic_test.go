@@ -0,0 +1,120 @@
+package quic
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestListen(t *testing.T) {
+	config := &Config{IdleTimeout: time.Minute}
+	tests := []struct {
+		name   string
+		listen func() (*Listener, error)
+		config *Config
+	}{
+		{"Listen", func() (*Listener, error) { return Listen(0) }, nil},
+		{"ListenConfig", func() (*Listener, error) { return ListenConfig(0, config) }, config},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, err := tt.listen()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer l.Close()
+			if l.config != tt.config {
+				t.Errorf("listener config = %v, want %v", l.config, tt.config)
+			}
+		})
+	}
+}
+
+func TestShutdownWithoutSessions(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := NewListener(conn, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown() = %v", err)
+	}
+	if _, err := l.Accept(); err != errListenerClosed {
+		t.Errorf("Accept() after Shutdown() = %v, want %v", err, errListenerClosed)
+	}
+}
+
+// startSlowRequest starts a request the handler doesn't answer until
+// release is closed. It returns once the handler is running.
+func startSlowRequest(t *testing.T) (*Server, <-chan error, chan struct{}) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	server, url := startTestServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		io.WriteString(w, "done")
+	}))
+	client := &http.Client{Transport: testRoundTripper(t), Timeout: 5 * time.Second}
+	errc := make(chan error, 1)
+	go func() {
+		resp, err := client.Get(url)
+		if err == nil {
+			_, err = io.ReadAll(resp.Body)
+			resp.Body.Close()
+		}
+		errc <- err
+	}()
+	select {
+	case <-started:
+	case err := <-errc:
+		t.Fatalf("request failed before reaching the handler: %v", err)
+	}
+	return server, errc, release
+}
+
+func TestShutdownWaitsForStreams(t *testing.T) {
+	server, errc, release := startSlowRequest(t)
+
+	shutdown := make(chan error, 1)
+	go func() {
+		shutdown <- server.Shutdown(context.Background())
+	}()
+	select {
+	case err := <-shutdown:
+		t.Fatalf("Shutdown() = %v with a request in flight", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(release)
+	if err := <-errc; err != nil {
+		t.Errorf("request in flight during Shutdown() failed: %v", err)
+	}
+	select {
+	case err := <-shutdown:
+		if err != nil {
+			t.Errorf("Shutdown() = %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Shutdown() didn't return after the last request finished")
+	}
+}
+
+func TestShutdownDeadline(t *testing.T) {
+	server, errc, release := startSlowRequest(t)
+	defer close(release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Shutdown() = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if err := <-errc; err == nil {
+		t.Error("request cut off by Shutdown() succeeded")
+	}
+}
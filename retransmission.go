@@ -0,0 +1,210 @@
+package quic
+
+import (
+	"sort"
+	"time"
+)
+
+// cryptoStreamID is the stream the crypto handshake is carried on.
+const cryptoStreamID = 1
+
+// Retransmission constants
+const (
+	// maxTailLossProbes is the number of tail loss probes sent before falling
+	// back to a retransmission timeout.
+	maxTailLossProbes = 2
+	// defaultMinTLPTimeout is the smallest tail loss probe timeout.
+	defaultMinTLPTimeout = 10 * time.Millisecond
+	// minHandshakeTimeout is the smallest handshake retransmission timeout.
+	minHandshakeTimeout = 10 * time.Millisecond
+	// delayedAckTime is the longest a peer is expected to delay an ACK.
+	delayedAckTime = 25 * time.Millisecond
+	// maxRetransmissionTimeout caps the exponential backoff.
+	maxRetransmissionTimeout = 60 * time.Second
+	// rtoProbePackets is the number of packets sent when the RTO fires.
+	rtoProbePackets = 2
+)
+
+// retransmissionMode is what happens when the retransmission timer fires.
+type retransmissionMode int
+
+// Retransmission modes
+const (
+	handshakeMode retransmissionMode = iota
+	tailLossProbeMode
+	rtoMode
+)
+
+// sentPacket holds what we need to know about a packet until the peer
+// acknowledges it.
+type sentPacket struct {
+	sequenceNumber  uint64
+	sentTime        time.Time
+	length          uint64
+	retransmittable bool
+	// handshake is set if the packet carries crypto stream data.
+	handshake bool
+	// mtuProbe is set for path MTU discovery probes, which aren't
+	// retransmitted.
+	mtuProbe bool
+	// level is the encryption level the packet was sealed at.
+	level encryptionLevel
+	// frames are the retransmittable frames the packet carried.
+	frames []Frame
+	// stopWaiting is the least unacked packet of the STOP_WAITING the packet
+	// carried, or zero.
+	stopWaiting uint64
+}
+
+// isRetransmittable reports whether a frame has to be retransmitted if the
+// packet carrying it is lost.
+func isRetransmittable(f Frame) bool {
+	switch f.(type) {
+	case FrameAck, FrameStopWaiting, *FramePadding, FramePadding:
+		return false
+	}
+	return true
+}
+
+// isHandshakeFrame reports whether a frame carries crypto stream data.
+func isHandshakeFrame(f Frame) bool {
+	frame, ok := f.(FrameStream)
+	return ok && frame.StreamID == cryptoStreamID
+}
+
+// retransmit declares a sent packet lost and queues its frames to be sent again.
+func (s *Session) retransmit(sent *sentPacket) {
+	delete(s.sentPackets, sent.sequenceNumber)
+	if !sent.retransmittable {
+		return
+	}
+	s.bytesInFlight -= sent.length
+	if sent.mtuProbe {
+		s.mtu.OnPacketLost(int(sent.length), true)
+		return
+	}
+	s.retransmissions = append(s.retransmissions, sent.frames...)
+}
+
+// outstandingPackets returns the retransmittable packets that haven't been
+// acknowledged, oldest first. If handshake is set, only handshake packets
+// are returned.
+func (s *Session) outstandingPackets(handshake bool) []*sentPacket {
+	var packets []*sentPacket
+	for _, sent := range s.sentPackets {
+		if sent.retransmittable && (!handshake || sent.handshake) {
+			packets = append(packets, sent)
+		}
+	}
+	sort.Slice(packets, func(i, j int) bool {
+		return packets[i].sequenceNumber < packets[j].sequenceNumber
+	})
+	return packets
+}
+
+// newestOutstandingPacket returns the newest outstanding packet with frames
+// to send again, or nil if there is none. MTU probes are skipped.
+func (s *Session) newestOutstandingPacket() *sentPacket {
+	packets := s.outstandingPackets(false)
+	for i := len(packets) - 1; i >= 0; i-- {
+		if !packets[i].mtuProbe {
+			return packets[i]
+		}
+	}
+	return nil
+}
+
+// retransmissionMode returns what the retransmission timer should do next.
+func (s *Session) retransmissionMode() retransmissionMode {
+	if len(s.outstandingPackets(true)) > 0 {
+		return handshakeMode
+	}
+	if s.consecutiveTLPCount < maxTailLossProbes {
+		return tailLossProbeMode
+	}
+	return rtoMode
+}
+
+// retransmissionDelay returns how long after the last retransmittable packet
+// was sent the retransmission timer fires.
+func (s *Session) retransmissionDelay(mode retransmissionMode) time.Duration {
+	srtt := s.rtt.SmoothedRTT()
+	switch mode {
+	case handshakeMode:
+		delay := srtt * 3 / 2
+		if delay < minHandshakeTimeout {
+			delay = minHandshakeTimeout
+		}
+		return backoff(delay, s.consecutiveCryptoRetransmits)
+	case tailLossProbeMode:
+		delay := 2 * srtt
+		if len(s.outstandingPackets(false)) == 1 {
+			// The peer may be delaying the ACK of a lone packet.
+			if d := srtt*3/2 + delayedAckTime; d > delay {
+				delay = d
+			}
+		}
+		if min := s.config.minTLP(); delay < min {
+			delay = min
+		}
+		return delay
+	}
+	return backoff(s.rtt.RetransmissionTimeout(), s.consecutiveRTOCount)
+}
+
+// backoff doubles delay count times, up to maxRetransmissionTimeout.
+func backoff(delay time.Duration, count uint) time.Duration {
+	for i := uint(0); i < count && delay < maxRetransmissionTimeout; i++ {
+		delay *= 2
+	}
+	if delay > maxRetransmissionTimeout {
+		delay = maxRetransmissionTimeout
+	}
+	return delay
+}
+
+// setRetransmissionAlarm arms the retransmission timer for the current
+// outstanding packets, or stops it if there are none.
+func (s *Session) setRetransmissionAlarm() {
+	if s.bytesInFlight == 0 {
+		s.retransmissionTimer.Stop()
+		return
+	}
+	deadline := s.lastRetransmittableSentTime.Add(s.retransmissionDelay(s.retransmissionMode()))
+	s.retransmissionTimer.Reset(time.Until(deadline))
+}
+
+// onRetransmissionTimeout handles the retransmission timer firing.
+func (s *Session) onRetransmissionTimeout() {
+	switch s.retransmissionMode() {
+	case handshakeMode:
+		// Resend all crypto stream data, the handshake can't make progress
+		// without it.
+		for _, sent := range s.outstandingPackets(true) {
+			s.retransmit(sent)
+		}
+		s.consecutiveCryptoRetransmits++
+	case tailLossProbeMode:
+		// Send one packet to provoke an ACK, new data if there is some,
+		// otherwise the newest outstanding packet again. Like on RTO, the
+		// original is no longer in flight once its frames are queued.
+		s.consecutiveTLPCount++
+		s.forceSendPackets = 1
+		if len(s.pendingFrames) == 0 && len(s.retransmissions) == 0 {
+			if sent := s.newestOutstandingPacket(); sent != nil {
+				s.retransmit(sent)
+			} else {
+				s.queueFrame(FramePing{})
+			}
+		}
+	case rtoMode:
+		packets := s.outstandingPackets(false)
+		for _, sent := range packets {
+			s.retransmit(sent)
+		}
+		s.congestion.OnRetransmissionTimeout(len(packets) > 0)
+		s.consecutiveRTOCount++
+		s.forceSendPackets = rtoProbePackets
+	}
+	s.setRetransmissionAlarm()
+}
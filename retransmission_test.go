@@ -0,0 +1,176 @@
+package quic
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		delay time.Duration
+		count uint
+		want  time.Duration
+	}{
+		{200 * time.Millisecond, 0, 200 * time.Millisecond},
+		{200 * time.Millisecond, 1, 400 * time.Millisecond},
+		{200 * time.Millisecond, 3, 1600 * time.Millisecond},
+		{200 * time.Millisecond, 100, maxRetransmissionTimeout},
+		{2 * maxRetransmissionTimeout, 0, maxRetransmissionTimeout},
+	}
+	for _, tt := range tests {
+		if got := backoff(tt.delay, tt.count); got != tt.want {
+			t.Errorf("backoff(%s, %d) = %s, want %s", tt.delay, tt.count, got, tt.want)
+		}
+	}
+}
+
+// sendTestPackets sends n retransmittable packets from the session
+// goroutine, to a peer whose address is validated. They carry WINDOW_UPDATE
+// frames, which can be sent before the handshake completes.
+func sendTestPackets(t *testing.T, s *Session, n int) {
+	var err error
+	doSync(s, func() {
+		s.pathValidated = true
+		for i := 0; i < n && err == nil; i++ {
+			err = s.writePacket([]Frame{FrameWindowUpdate{StreamID: 5, ByteOffset: uint64(i)}}, encryptionUnencrypted, false)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRetransmissionDelay(t *testing.T) {
+	s, _ := newTestSession(t)
+	var delays []time.Duration
+	doSync(s, func() {
+		s.rtt.UpdateRTT(20*time.Millisecond, 0, time.Now())
+		s.sentPackets[1] = &sentPacket{sequenceNumber: 1, retransmittable: true, handshake: true}
+		delays = append(delays, s.retransmissionDelay(handshakeMode))
+		s.consecutiveCryptoRetransmits = 2
+		delays = append(delays, s.retransmissionDelay(handshakeMode))
+
+		// A lone packet may have its ACK delayed.
+		s.sentPackets[1].handshake = false
+		delays = append(delays, s.retransmissionDelay(tailLossProbeMode))
+		s.sentPackets[2] = &sentPacket{sequenceNumber: 2, retransmittable: true}
+		delays = append(delays, s.retransmissionDelay(tailLossProbeMode))
+
+		delays = append(delays, s.retransmissionDelay(rtoMode))
+		s.consecutiveRTOCount = 2
+		delays = append(delays, s.retransmissionDelay(rtoMode))
+		s.sentPackets = map[uint64]*sentPacket{}
+	})
+	want := []time.Duration{
+		30 * time.Millisecond,  // handshake: 1.5 srtt
+		120 * time.Millisecond, // handshake, backed off twice
+		55 * time.Millisecond,  // TLP: 1.5 srtt + delayed ACK
+		40 * time.Millisecond,  // TLP: 2 srtt
+		defaultMinRTO,          // RTO: srtt + 4 deviations is below the minimum
+		4 * defaultMinRTO,      // RTO, backed off twice
+	}
+	for i := range want {
+		if delays[i] != want[i] {
+			t.Errorf("delay %d = %s, want %s", i, delays[i], want[i])
+		}
+	}
+}
+
+func TestTailLossProbe(t *testing.T) {
+	s, _ := newTestSession(t)
+	sendTestPackets(t, s, 3)
+
+	var inFlight, afterProbe, afterSend uint64
+	var newest uint64
+	var originalSent bool
+	var queued int
+	doSync(s, func() {
+		inFlight = s.bytesInFlight
+		newest = s.sequenceNumber
+		s.onRetransmissionTimeout()
+		afterProbe = s.bytesInFlight
+		_, originalSent = s.sentPackets[newest]
+		queued = len(s.retransmissions)
+	})
+	if originalSent {
+		t.Error("the probed packet is still outstanding")
+	}
+	if queued != 1 {
+		t.Errorf("%d frames queued for the probe, want 1", queued)
+	}
+	if afterProbe >= inFlight {
+		t.Errorf("bytes in flight = %d after the probe was queued, want less than %d", afterProbe, inFlight)
+	}
+	doSync(s, func() { afterSend = s.bytesInFlight })
+	if afterSend != inFlight {
+		t.Errorf("bytes in flight = %d after the probe was sent, want %d", afterSend, inFlight)
+	}
+
+	// After the last probe an RTO retransmits each frame once.
+	var mode retransmissionMode
+	var retransmissions int
+	doSync(s, func() {
+		s.consecutiveTLPCount = maxTailLossProbes
+		mode = s.retransmissionMode()
+		s.onRetransmissionTimeout()
+		retransmissions = len(s.retransmissions)
+		afterProbe = s.bytesInFlight
+	})
+	if mode != rtoMode {
+		t.Fatalf("retransmission mode = %d after %d probes, want RTO", mode, maxTailLossProbes)
+	}
+	if retransmissions != 3 {
+		t.Errorf("RTO queued %d frames, want 3", retransmissions)
+	}
+	if afterProbe != 0 {
+		t.Errorf("bytes in flight = %d after RTO, want 0", afterProbe)
+	}
+}
+
+func TestTailLossProbeOnlyMTUProbe(t *testing.T) {
+	s, _ := newTestSession(t)
+	var ping, probeOutstanding bool
+	doSync(s, func() {
+		s.sentPackets[1] = &sentPacket{sequenceNumber: 1, retransmittable: true, mtuProbe: true, length: 1400}
+		s.bytesInFlight = 1400
+		s.onRetransmissionTimeout()
+		for _, f := range s.pendingFrames {
+			if _, ok := f.(FramePing); ok {
+				ping = true
+			}
+		}
+		_, probeOutstanding = s.sentPackets[1]
+	})
+	if !probeOutstanding {
+		t.Error("the tail loss probe declared the MTU probe lost")
+	}
+	if !ping {
+		t.Error("the tail loss probe didn't send a PING")
+	}
+}
+
+func TestRetransmissionTimer(t *testing.T) {
+	s, conn := newTestSession(t)
+	doSync(s, func() { s.rtt.UpdateRTT(5*time.Millisecond, 0, time.Now()) })
+	sendTestPackets(t, s, 1)
+
+	// Two tail loss probes, then an RTO with exponential backoff.
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		var tlps, rtos uint
+		doSync(s, func() { tlps, rtos = s.consecutiveTLPCount, s.consecutiveRTOCount })
+		if tlps == maxTailLossProbes && rtos >= 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("after 2s: %d tail loss probes and %d RTOs", tlps, rtos)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	conn.mu.Lock()
+	sent := len(conn.packets)
+	conn.mu.Unlock()
+	if sent < 1+maxTailLossProbes+1 {
+		t.Errorf("%d packets sent, want the packet, %d probes and an RTO", sent, maxTailLossProbes)
+	}
+}
@@ -0,0 +1,181 @@
+package quic
+
+import (
+	"sync"
+	"time"
+)
+
+// RTT constants
+const (
+	// initialRTT is the RTT assumed before any samples have been taken.
+	initialRTT = 100 * time.Millisecond
+	// minRTTWindow is how long a min RTT sample is kept before it ages out,
+	// so route changes are picked up.
+	minRTTWindow = 10 * time.Second
+	// defaultMinRTO is the smallest retransmission timeout that will be used.
+	defaultMinRTO = 200 * time.Millisecond
+)
+
+// RTTStats tracks the round trip time to the peer. Samples come from ACKs of
+// the largest observed packet, with the peer's reported ack delay removed.
+type RTTStats struct {
+	mu sync.Mutex
+
+	latestRTT     time.Duration
+	smoothedRTT   time.Duration
+	meanDeviation time.Duration
+
+	minRTT windowedMinFilter
+
+	// minRTO is the smallest retransmission timeout, defaultMinRTO if unset.
+	minRTO time.Duration
+}
+
+// reset forgets all samples, e.g. when the peer moved to a new network.
+func (r *RTTStats) reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.latestRTT = 0
+	r.smoothedRTT = 0
+	r.meanDeviation = 0
+	r.minRTT = windowedMinFilter{}
+}
+
+// UpdateRTT adds a sample. sendDelta is the time between sending the largest
+// observed packet and receiving its ACK, ackDelay is the time the peer
+// reported holding on to the ACK.
+func (r *RTTStats) UpdateRTT(sendDelta, ackDelay time.Duration, now time.Time) {
+	if sendDelta <= 0 {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// The min RTT ignores the ack delay since the peer can't be trusted to
+	// report it accurately.
+	r.minRTT.Update(sendDelta, now)
+
+	sample := sendDelta
+	if sample > ackDelay {
+		sample -= ackDelay
+	}
+	r.latestRTT = sample
+
+	if r.smoothedRTT == 0 {
+		r.smoothedRTT = sample
+		r.meanDeviation = sample / 2
+		return
+	}
+	deviation := r.smoothedRTT - sample
+	if deviation < 0 {
+		deviation = -deviation
+	}
+	r.meanDeviation = r.meanDeviation*3/4 + deviation/4
+	r.smoothedRTT = r.smoothedRTT*7/8 + sample/8
+}
+
+// LatestRTT returns the most recent RTT sample.
+func (r *RTTStats) LatestRTT() time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.latestRTT
+}
+
+// SmoothedRTT returns the exponentially weighted moving average of the RTT,
+// or the initial RTT if there are no samples yet.
+func (r *RTTStats) SmoothedRTT() time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.smoothedRTT == 0 {
+		return initialRTT
+	}
+	return r.smoothedRTT
+}
+
+// MeanDeviation returns the mean deviation of the RTT samples.
+func (r *RTTStats) MeanDeviation() time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.meanDeviation
+}
+
+// MinRTT returns the smallest RTT seen within the last minRTTWindow.
+func (r *RTTStats) MinRTT() time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.minRTT.Best()
+}
+
+// RetransmissionTimeout returns how long to wait for an ACK before
+// considering outstanding packets lost.
+func (r *RTTStats) RetransmissionTimeout() time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.smoothedRTT == 0 {
+		return 2 * initialRTT
+	}
+	minRTO := r.minRTO
+	if minRTO == 0 {
+		minRTO = defaultMinRTO
+	}
+	rto := r.smoothedRTT + 4*r.meanDeviation
+	if rto < minRTO {
+		rto = minRTO
+	}
+	return rto
+}
+
+// windowedMinFilter tracks the minimum of a series of RTT samples over
+// minRTTWindow. Like windowedMaxFilter it keeps the best three samples, so
+// that once the best one ages out the min rises to the smallest recent
+// sample instead of to whatever the next sample happens to be.
+type windowedMinFilter struct {
+	estimates [3]struct {
+		value time.Duration
+		time  time.Time
+	}
+}
+
+// Best returns the current minimum, or 0 if there are no samples.
+func (f *windowedMinFilter) Best() time.Duration {
+	return f.estimates[0].value
+}
+
+// Update adds a sample taken at now.
+func (f *windowedMinFilter) Update(sample time.Duration, now time.Time) {
+	e := &f.estimates
+	if e[0].value == 0 || sample <= e[0].value || now.Sub(e[2].time) > minRTTWindow {
+		for i := range e {
+			e[i].value, e[i].time = sample, now
+		}
+		return
+	}
+	if sample <= e[1].value {
+		e[1].value, e[1].time = sample, now
+		e[2] = e[1]
+	} else if sample <= e[2].value {
+		e[2].value, e[2].time = sample, now
+	}
+
+	if now.Sub(e[0].time) > minRTTWindow {
+		// The best sample expired, promote the others.
+		e[0] = e[1]
+		e[1] = e[2]
+		e[2].value, e[2].time = sample, now
+		if now.Sub(e[0].time) > minRTTWindow {
+			e[0] = e[1]
+			e[1] = e[2]
+		}
+		return
+	}
+	if e[1].value == e[0].value && now.Sub(e[1].time) > minRTTWindow/4 {
+		// Replace the second best if it's been the same as the best for a
+		// quarter of the window.
+		e[1].value, e[1].time = sample, now
+		e[2] = e[1]
+		return
+	}
+	if e[2].value == e[1].value && now.Sub(e[2].time) > minRTTWindow/2 {
+		e[2].value, e[2].time = sample, now
+	}
+}
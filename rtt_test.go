@@ -0,0 +1,109 @@
+package quic
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRTTStatsInitial(t *testing.T) {
+	var r RTTStats
+	if got := r.SmoothedRTT(); got != initialRTT {
+		t.Errorf("SmoothedRTT() = %s, want %s", got, initialRTT)
+	}
+	if got := r.RetransmissionTimeout(); got != 2*initialRTT {
+		t.Errorf("RetransmissionTimeout() = %s, want %s", got, 2*initialRTT)
+	}
+	if got := r.MinRTT(); got != 0 {
+		t.Errorf("MinRTT() = %s, want 0", got)
+	}
+}
+
+func TestRTTStatsSmoothing(t *testing.T) {
+	var r RTTStats
+	now := time.Now()
+	r.UpdateRTT(100*time.Millisecond, 0, now)
+	if got, want := r.SmoothedRTT(), 100*time.Millisecond; got != want {
+		t.Errorf("SmoothedRTT() after one sample = %s, want %s", got, want)
+	}
+	if got, want := r.MeanDeviation(), 50*time.Millisecond; got != want {
+		t.Errorf("MeanDeviation() after one sample = %s, want %s", got, want)
+	}
+
+	// The ack delay is removed from the sample.
+	r.UpdateRTT(250*time.Millisecond, 50*time.Millisecond, now)
+	if got, want := r.LatestRTT(), 200*time.Millisecond; got != want {
+		t.Errorf("LatestRTT() = %s, want %s", got, want)
+	}
+	// 7/8 * 100ms + 1/8 * 200ms
+	if got, want := r.SmoothedRTT(), 112500*time.Microsecond; got != want {
+		t.Errorf("SmoothedRTT() = %s, want %s", got, want)
+	}
+	// 3/4 * 50ms + 1/4 * |100ms - 200ms|
+	if got, want := r.MeanDeviation(), 62500*time.Microsecond; got != want {
+		t.Errorf("MeanDeviation() = %s, want %s", got, want)
+	}
+	if got, want := r.RetransmissionTimeout(), 362500*time.Microsecond; got != want {
+		t.Errorf("RetransmissionTimeout() = %s, want %s", got, want)
+	}
+
+	// An ack delay larger than the sample is ignored.
+	r.UpdateRTT(10*time.Millisecond, 20*time.Millisecond, now)
+	if got, want := r.LatestRTT(), 10*time.Millisecond; got != want {
+		t.Errorf("LatestRTT() with a large ack delay = %s, want %s", got, want)
+	}
+
+	r.reset()
+	if got := r.SmoothedRTT(); got != initialRTT {
+		t.Errorf("SmoothedRTT() after reset = %s, want %s", got, initialRTT)
+	}
+	if got := r.MinRTT(); got != 0 {
+		t.Errorf("MinRTT() after reset = %s, want 0", got)
+	}
+}
+
+func TestRTTStatsMinRTO(t *testing.T) {
+	var r RTTStats
+	r.UpdateRTT(10*time.Millisecond, 0, time.Now())
+	if got := r.RetransmissionTimeout(); got != defaultMinRTO {
+		t.Errorf("RetransmissionTimeout() = %s, want %s", got, defaultMinRTO)
+	}
+	r.minRTO = 50 * time.Millisecond
+	if got := r.RetransmissionTimeout(); got != r.minRTO {
+		t.Errorf("RetransmissionTimeout() = %s, want %s", got, r.minRTO)
+	}
+}
+
+func TestRTTStatsMinRTT(t *testing.T) {
+	var r RTTStats
+	start := time.Now()
+	at := func(d time.Duration) time.Time { return start.Add(d) }
+
+	// The ack delay doesn't lower the min RTT.
+	r.UpdateRTT(50*time.Millisecond, 10*time.Millisecond, at(0))
+	r.UpdateRTT(80*time.Millisecond, 0, at(time.Second))
+	if got, want := r.MinRTT(), 50*time.Millisecond; got != want {
+		t.Errorf("MinRTT() = %s, want %s", got, want)
+	}
+
+	// Within the window a lower sample replaces it, a higher one doesn't but
+	// is kept as a later candidate.
+	r.UpdateRTT(40*time.Millisecond, 0, at(2*time.Second))
+	r.UpdateRTT(60*time.Millisecond, 0, at(5*time.Second))
+	r.UpdateRTT(70*time.Millisecond, 0, at(8*time.Second))
+	if got, want := r.MinRTT(), 40*time.Millisecond; got != want {
+		t.Errorf("MinRTT() = %s, want %s", got, want)
+	}
+
+	// Once the min ages out, the smallest sample from the rest of the window
+	// takes its place rather than the latest one.
+	r.UpdateRTT(90*time.Millisecond, 0, at(2*time.Second+minRTTWindow+time.Millisecond))
+	if got, want := r.MinRTT(), 60*time.Millisecond; got != want {
+		t.Errorf("MinRTT() after the min expired = %s, want %s", got, want)
+	}
+
+	// Without samples for a whole window, the next one is the min.
+	r.UpdateRTT(100*time.Millisecond, 0, at(3*minRTTWindow))
+	if got, want := r.MinRTT(), 100*time.Millisecond; got != want {
+		t.Errorf("MinRTT() after an idle window = %s, want %s", got, want)
+	}
+}
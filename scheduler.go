@@ -0,0 +1,44 @@
+package quic
+
+import "sort"
+
+// Stream priorities, as in SPDY: 0 is the highest and 7 the lowest.
+const (
+	highestPriority = 0
+	lowestPriority  = 7
+	defaultPriority = 3
+)
+
+// streamScheduler decides the order in which streams get to send data. Streams
+// with a higher priority always go first, and streams of the same priority
+// take turns.
+type streamScheduler struct {
+	// lastSent is the ID of the stream of each priority that sent last.
+	lastSent [lowestPriority + 1]uint64
+}
+
+// schedule sorts the streams in the order they should send data.
+func (s *streamScheduler) schedule(streams []*Stream) {
+	priorities := make(map[uint64]uint8, len(streams))
+	for _, stream := range streams {
+		priorities[stream.id] = stream.Priority()
+	}
+	sort.Slice(streams, func(i, j int) bool {
+		a, b := streams[i].id, streams[j].id
+		pa, pb := priorities[a], priorities[b]
+		if pa != pb {
+			return pa < pb
+		}
+		// Streams after the one that sent last come first.
+		last := s.lastSent[pa]
+		if (a > last) != (b > last) {
+			return a > last
+		}
+		return a < b
+	})
+}
+
+// onSent records that the stream sent data.
+func (s *streamScheduler) onSent(stream *Stream) {
+	s.lastSent[stream.Priority()] = stream.id
+}
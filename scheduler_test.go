@@ -0,0 +1,104 @@
+package quic
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// testStreams opens a stream with each of the given priorities.
+func testStreams(t *testing.T, s *Session, priorities ...uint8) []*Stream {
+	var streams []*Stream
+	for _, p := range priorities {
+		stream, err := s.OpenStream()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := stream.SetPriority(p); err != nil {
+			t.Fatal(err)
+		}
+		streams = append(streams, stream)
+	}
+	return streams
+}
+
+func streamIDs(streams []*Stream) []uint64 {
+	ids := make([]uint64, len(streams))
+	for i, stream := range streams {
+		ids[i] = stream.StreamID()
+	}
+	return ids
+}
+
+func TestSchedulerPriorityOrder(t *testing.T) {
+	s, _ := newTestSession(t)
+	// Streams 2 to 12.
+	streams := testStreams(t, s, lowestPriority, defaultPriority, highestPriority, defaultPriority, highestPriority, lowestPriority)
+
+	var sched streamScheduler
+	sched.schedule(streams)
+	if got, want := streamIDs(streams), []uint64{6, 10, 4, 8, 2, 12}; !reflect.DeepEqual(got, want) {
+		t.Errorf("schedule() = %v, want %v", got, want)
+	}
+}
+
+func TestSchedulerRoundRobin(t *testing.T) {
+	s, _ := newTestSession(t)
+	// Streams 2, 4 and 6 at the same priority, 8 above them.
+	streams := testStreams(t, s, defaultPriority, defaultPriority, defaultPriority, highestPriority)
+
+	var sched streamScheduler
+	var order []uint64
+	for i := 0; i < 4; i++ {
+		sched.schedule(streams)
+		order = append(order, streams[1].StreamID())
+		sched.onSent(streams[1])
+	}
+	// Stream 8 always comes first, the others take turns behind it.
+	if got := streams[0].StreamID(); got != 8 {
+		t.Errorf("stream %d scheduled first, want 8", got)
+	}
+	if want := []uint64{2, 4, 6, 2}; !reflect.DeepEqual(order, want) {
+		t.Errorf("second place went to %v, want %v", order, want)
+	}
+
+	// Taking turns at one priority doesn't affect another.
+	sched.onSent(streams[0])
+	sched.schedule(streams)
+	if got, want := streamIDs(streams), []uint64{8, 4, 6, 2}; !reflect.DeepEqual(got, want) {
+		t.Errorf("schedule() = %v, want %v", got, want)
+	}
+}
+
+func TestSetPriority(t *testing.T) {
+	s, _ := newTestSession(t)
+	stream, err := s.OpenStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := stream.Priority(); got != defaultPriority {
+		t.Errorf("Priority() = %d, want %d", got, defaultPriority)
+	}
+	if err := stream.SetPriority(lowestPriority + 1); !errors.Is(err, QUIC_INVALID_PRIORITY) {
+		t.Errorf("SetPriority(%d) = %v, want QUIC_INVALID_PRIORITY", lowestPriority+1, err)
+	}
+	if got := stream.Priority(); got != defaultPriority {
+		t.Errorf("Priority() after an invalid SetPriority = %d, want %d", got, defaultPriority)
+	}
+}
+
+func TestStreamsWithDataPriority(t *testing.T) {
+	s, _ := newTestSession(t)
+	streams := testStreams(t, s, lowestPriority, defaultPriority, highestPriority)
+	for _, stream := range streams[:2] {
+		if _, err := stream.Write([]byte("data")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Stream 6 has nothing to send.
+	var got []uint64
+	doSync(s, func() { got = streamIDs(s.streamsWithData()) })
+	if want := []uint64{4, 2}; !reflect.DeepEqual(got, want) {
+		t.Errorf("streamsWithData() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,203 @@
+package quic
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"sync"
+	"time"
+)
+
+// Server config constants
+const (
+	// serverConfigIDSize is the size of a server config ID (SCID).
+	serverConfigIDSize = 16
+	// serverConfigLifetime is how long clients may use a server config.
+	serverConfigLifetime = 7 * 24 * time.Hour
+	// serverConfigRotation is how often a new primary server config, with a
+	// new key, is promoted. Older configs are accepted until they expire.
+	serverConfigRotation = 24 * time.Hour
+	// clientNonceSize is the size of the client nonce (NONC).
+	clientNonceSize = 32
+	// orbitSize is the size of a server config's orbit (ORBT).
+	orbitSize = 8
+)
+
+// Key exchange and AEAD algorithm tags
+const (
+	// TagC255 is Curve25519 key exchange.
+	TagC255 Tag = 'C' | '2'<<8 | '5'<<16 | '5'<<24
+	// TagAESG is AES-128-GCM with a 12 byte tag.
+	TagAESG Tag = 'A' | 'E'<<8 | 'S'<<16 | 'G'<<24
+)
+
+// serverConfig is a server config (SCFG): the long lived key exchange key a
+// server hands out in REJ, which clients cache to start later sessions with
+// 0-RTT.
+type serverConfig struct {
+	id     []byte
+	key    *ecdh.PrivateKey
+	expiry time.Time
+	// orbit identifies the strike register client nonces are checked
+	// against.
+	orbit []byte
+	// serialized is the SCFG message, as sent to clients.
+	serialized []byte
+}
+
+// newServerConfig returns a server config with a new key that expires at
+// expiry.
+func newServerConfig(expiry time.Time, orbit []byte) (*serverConfig, error) {
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	id := make([]byte, serverConfigIDSize)
+	if _, err := io.ReadFull(rand.Reader, id); err != nil {
+		return nil, err
+	}
+	msg := NewHandshakeMessage(TagSCFG)
+	msg.Values[TagSCID] = id
+	msg.SetUint32(TagKEXS, uint32(TagC255))
+	msg.SetUint32(TagAEAD, uint32(TagAESG))
+	msg.Values[TagPUBS] = key.PublicKey().Bytes()
+	expy := make([]byte, 8)
+	binary.LittleEndian.PutUint64(expy, uint64(expiry.Unix()))
+	msg.Values[TagEXPY] = expy
+	msg.Values[TagORBT] = orbit
+	return &serverConfig{
+		id:         id,
+		key:        key,
+		expiry:     expiry,
+		orbit:      orbit,
+		serialized: msg.ToBuf(),
+	}, nil
+}
+
+// serverConfigStore holds the server configs a server accepts CHLOs for. The
+// primary config is handed out in REJs and is replaced every
+// serverConfigRotation, so a compromised key only exposes the sessions of one
+// rotation period. Clients holding an older config can keep using it until
+// it expires.
+type serverConfigStore struct {
+	now func() time.Time
+	// orbit is shared by every config, since they share a strike register.
+	orbit []byte
+
+	mu       sync.Mutex
+	configs  []*serverConfig
+	primary  *serverConfig
+	rotation time.Time
+}
+
+// newServerConfigStore returns a store with a new primary config, reading
+// the time from now. The configs carry orbit.
+func newServerConfigStore(now func() time.Time, orbit []byte) (*serverConfigStore, error) {
+	s := &serverConfigStore{now: now, orbit: orbit}
+	if _, err := s.Primary(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Primary returns the config to hand out to clients, promoting a new one if
+// it's time to.
+func (s *serverConfigStore) Primary() (*serverConfig, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	if s.primary != nil && now.Before(s.rotation) {
+		return s.primary, nil
+	}
+	config, err := newServerConfig(now.Add(serverConfigLifetime), s.orbit)
+	if err != nil {
+		return nil, err
+	}
+	// Expired configs are remembered for another lifetime, so CHLOs
+	// referencing them can be told apart from ones with unknown IDs.
+	configs := s.configs[:0]
+	for _, c := range s.configs {
+		if now.Sub(c.expiry) < serverConfigLifetime {
+			configs = append(configs, c)
+		}
+	}
+	s.configs = append(configs, config)
+	s.primary = config
+	s.rotation = now.Add(serverConfigRotation)
+	return config, nil
+}
+
+// Get returns the config with the given ID, or nil if we don't know it.
+// Expired configs return a QUIC_CRYPTO_SERVER_CONFIG_EXPIRED error.
+func (s *serverConfigStore) Get(id []byte) (*serverConfig, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, c := range s.configs {
+		if !bytes.Equal(c.id, id) {
+			continue
+		}
+		if !s.now().Before(c.expiry) {
+			return nil, qerr(QUIC_CRYPTO_SERVER_CONFIG_EXPIRED, "server config %x expired", id)
+		}
+		return c, nil
+	}
+	return nil, nil
+}
+
+// cachedServerConfig is a server config as seen by a client.
+type cachedServerConfig struct {
+	id         []byte
+	publicKey  *ecdh.PublicKey
+	expiry     time.Time
+	orbit      []byte
+	serialized []byte
+}
+
+// parseServerConfig parses a serialized SCFG and checks that we support its
+// key exchange and AEAD.
+func parseServerConfig(buf []byte) (*cachedServerConfig, error) {
+	msg, n, err := ParseHandshakeMessage(buf)
+	if err != nil || n != len(buf) || msg.Tag != TagSCFG {
+		return nil, qerr(QUIC_INVALID_CRYPTO_MESSAGE_PARAMETER, "invalid server config")
+	}
+	id, ok := msg.Values[TagSCID]
+	if !ok || len(id) != serverConfigIDSize {
+		return nil, qerr(QUIC_CRYPTO_MESSAGE_PARAMETER_NOT_FOUND, "server config without SCID")
+	}
+	if !msg.hasTag(TagKEXS, TagC255) || !msg.hasTag(TagAEAD, TagAESG) {
+		return nil, qerr(QUIC_CRYPTO_NO_SUPPORT, "no supported key exchange or AEAD in server config")
+	}
+	pub, err := ecdh.X25519().NewPublicKey(msg.Values[TagPUBS])
+	if err != nil {
+		return nil, qerr(QUIC_INVALID_CRYPTO_MESSAGE_PARAMETER, "invalid server config public key")
+	}
+	expy, ok := msg.Values[TagEXPY]
+	if !ok || len(expy) != 8 {
+		return nil, qerr(QUIC_CRYPTO_MESSAGE_PARAMETER_NOT_FOUND, "server config without EXPY")
+	}
+	orbit, ok := msg.Values[TagORBT]
+	if !ok || len(orbit) != orbitSize {
+		return nil, qerr(QUIC_CRYPTO_MESSAGE_PARAMETER_NOT_FOUND, "server config without ORBT")
+	}
+	return &cachedServerConfig{
+		id:         id,
+		publicKey:  pub,
+		expiry:     time.Unix(int64(binary.LittleEndian.Uint64(expy)), 0),
+		orbit:      orbit,
+		serialized: buf,
+	}, nil
+}
+
+// newClientNonce returns a client nonce (NONC): the time, the orbit of the
+// server config and random bytes.
+func newClientNonce(now time.Time, orbit []byte) ([]byte, error) {
+	nonce := make([]byte, clientNonceSize)
+	binary.BigEndian.PutUint32(nonce, uint32(now.Unix()))
+	copy(nonce[4:4+orbitSize], orbit)
+	if _, err := io.ReadFull(rand.Reader, nonce[4+orbitSize:]); err != nil {
+		return nil, err
+	}
+	return nonce, nil
+}
@@ -0,0 +1,968 @@
+package quic
+
+import (
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"errors"
+	"log"
+	"net"
+	"sort"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// errInvalidAckEntropy is returned when a peer ACKs with an entropy hash that
+// doesn't match the packets we sent, e.g. an optimistic ACK attack.
+var errInvalidAckEntropy = &QuicError{
+	ErrorCode:    QUIC_INVALID_ACK_DATA,
+	ErrorMessage: "ack entropy doesn't match sent packets",
+}
+
+// errPeerGoingAway is returned by OpenStream after the peer sent a GOAWAY.
+var errPeerGoingAway = &QuicError{
+	ErrorCode:    QUIC_PEER_GOING_AWAY,
+	ErrorMessage: "peer is going away",
+}
+
+// errSessionClosed is returned by CloseWithError if the session was already
+// closed.
+var errSessionClosed = errors.New("quic: session already closed")
+
+// errTooManyOpenStreams is returned by OpenStream when the peer's stream
+// limit has been reached.
+var errTooManyOpenStreams = &QuicError{
+	ErrorCode:    QUIC_TOO_MANY_OPEN_STREAMS,
+	ErrorMessage: "too many open streams",
+}
+
+// maxIncomingStreamQueue is the number of peer streams that may wait for
+// AcceptStream before new ones are refused.
+const maxIncomingStreamQueue = 100
+
+// defaultMaxStreams is the default number of concurrent streams each side may
+// have open.
+const defaultMaxStreams = 100
+
+// headersStreamID is the stream HTTP headers are carried on. Like the crypto
+// stream it is reserved, so the streams opened by clients start at 5.
+const headersStreamID = 3
+
+// maxPacketHeaderSize is the size of the header of the packets we send: public
+// flags, 8 byte connection ID, 6 byte sequence number and private flags.
+const maxPacketHeaderSize = 1 + 8 + 6 + 1
+
+// streamFrameHeaderSize is the size of the header FrameStream.ToBuf writes.
+const streamFrameHeaderSize = 1 + 4 + 8 + 2
+
+// Session represents a QUIC connection with a single peer
+type Session struct {
+	ConnID uint64
+
+	isClient bool
+	conn     net.PacketConn
+	addr     net.Addr
+	// addrMu guards writes to addr, and reads outside the run goroutine.
+	addrMu sync.Mutex
+	config *Config
+
+	receivedPackets chan receivedPacket
+	closeChan       chan struct{}
+	closeOnce       sync.Once
+	closeErr        error
+	onClose         func()
+	commands        chan func()
+	sendingNotify   chan struct{}
+
+	streamsMu        sync.Mutex
+	streams          map[uint64]*Stream
+	incomingStreams  chan *Stream
+	nextStreamID     uint64
+	lastPeerStreamID uint64
+	// maxStreams is the negotiated number of concurrent streams each side
+	// may open.
+	maxStreams    uint32
+	goingAway     bool
+	peerGoingAway bool
+	// closeWhenIdle is set when the listener shuts down. The session closes
+	// once its streams finish and the peer has acked everything.
+	closeWhenIdle bool
+
+	connFlowController *flowController
+	scheduler          streamScheduler
+	headers            *HeadersStream
+
+	// The fields below are only accessed from the run goroutine.
+	sequenceNumber        uint64
+	largestObservedByPeer uint64
+	sentEntropy           *sentEntropyManager
+	receivedEntropy       *receivedEntropyManager
+	sentPackets           map[uint64]*sentPacket
+	largestReceivedTime   time.Time
+	bytesInFlight         uint64
+	pendingFrames         []Frame
+	ackPending            bool
+	retransmissions       []Frame
+
+	rtt        *RTTStats
+	congestion CongestionControl
+	pacer      *pacer
+	mtu        *mtuDiscoverer
+	sendTimer  *time.Timer
+
+	retransmissionTimer          *time.Timer
+	lastRetransmittableSentTime  time.Time
+	consecutiveRTOCount          uint
+	consecutiveTLPCount          uint
+	consecutiveCryptoRetransmits uint
+	forceSendPackets             int
+
+	createdTime               time.Time
+	lastReceivedTime          time.Time
+	firstSentAfterReceiveTime time.Time
+	idleTimeout               time.Duration
+	timeoutTimer              *time.Timer
+
+	// The peer's address is validated once a packet sent to it is acked.
+	pathValidated                bool
+	pathValidationSequenceNumber uint64
+	pathBytesReceived            uint64
+	pathBytesSent                uint64
+
+	handshakeComplete bool
+	handshakeDone     chan struct{}
+	server            *serverCrypto
+	cryptoBuffer      []byte
+	cryptoReadOffset  uint64
+	cryptoWriteOffset uint64
+	// cryptoLevels records the encryption level each handshake message was
+	// sent at, so retransmissions use the same keys.
+	cryptoLevels []cryptoLevelRange
+	// rejectSent is set once the server sent a REJ.
+	rejectSent bool
+	// channelID is the client's verified channel ID key.
+	channelID *ecdsa.PublicKey
+
+	// Client handshake state
+	serverName   string
+	serverConfig *cachedServerConfig
+	// stk is the source-address token the server gave the client.
+	stk         []byte
+	serverCerts [][]byte
+	clientKey   *ecdh.PrivateKey
+	clientNonce []byte
+	clientHello []byte
+	rejects     int
+
+	// Packet protection. Packets are sealed with the highest level we have
+	// keys for, and opened with any level we have keys for.
+	sealLevel            encryptionLevel
+	sealers              [encryptionForwardSecure + 1]packetAEAD
+	openers              [encryptionForwardSecure + 1]packetAEAD
+	keysChanged          bool
+	undecryptablePackets []receivedPacket
+	// receivedLevel is the level the packet being handled was sealed at.
+	receivedLevel encryptionLevel
+}
+
+// newSession returns a session with the peer at addr. server holds the
+// listener's handshake state, it is nil for client sessions.
+func newSession(conn net.PacketConn, addr net.Addr, connID uint64, config *Config, server *serverCrypto, onClose func()) *Session {
+	s := &Session{
+		ConnID:          connID,
+		isClient:        server == nil,
+		server:          server,
+		conn:            conn,
+		addr:            addr,
+		config:          config,
+		receivedPackets: make(chan receivedPacket, 100),
+		closeChan:       make(chan struct{}),
+		onClose:         onClose,
+		commands:        make(chan func()),
+		sendingNotify:   make(chan struct{}, 1),
+		streams:         map[uint64]*Stream{},
+		incomingStreams: make(chan *Stream, maxIncomingStreamQueue),
+		maxStreams:      config.maxIncomingStreams(),
+
+		connFlowController: newFlowController(defaultConnectionWindow),
+		sentEntropy:        newSentEntropyManager(),
+		receivedEntropy:    newReceivedEntropyManager(),
+		sentPackets:        map[uint64]*sentPacket{},
+		rtt:                &RTTStats{minRTO: config.minRTO()},
+		handshakeDone:      make(chan struct{}),
+	}
+	s.sealers[encryptionUnencrypted] = nullAEAD{}
+	s.openers[encryptionUnencrypted] = nullAEAD{}
+	if s.isClient {
+		// The server sent the packets to our address.
+		s.pathValidated = true
+		s.nextStreamID = headersStreamID + 2
+	} else {
+		s.nextStreamID = 2
+		s.lastPeerStreamID = headersStreamID
+	}
+	headers := newStream(headersStreamID, s)
+	headers.priority = highestPriority
+	s.streams[headersStreamID] = headers
+	s.headers = newHeadersStream(s, headers)
+	s.congestion = config.newCongestionControl(s.rtt)
+	s.pacer = newPacer(s.congestion, config.initialBurst())
+	s.mtu = newMTUDiscoverer(config.maxPacketSize())
+	s.sendTimer = time.NewTimer(time.Hour)
+	s.sendTimer.Stop()
+	s.retransmissionTimer = time.NewTimer(time.Hour)
+	s.retransmissionTimer.Stop()
+	s.createdTime = time.Now()
+	s.lastReceivedTime = s.createdTime
+	s.idleTimeout = s.config.idleTimeout()
+	s.timeoutTimer = time.NewTimer(time.Hour)
+	s.setTimeoutAlarm()
+	go s.run()
+	return s
+}
+
+// run is the goroutine that owns the session state.
+func (s *Session) run() {
+	for {
+		var err error
+		select {
+		case p := <-s.receivedPackets:
+			err = s.handleReceivedPacket(p)
+		case f := <-s.commands:
+			f()
+		case <-s.sendingNotify:
+		case <-s.sendTimer.C:
+		case <-s.retransmissionTimer.C:
+			s.onRetransmissionTimeout()
+		case <-s.timeoutTimer.C:
+			s.onTimeout()
+		case <-s.closeChan:
+			s.sendTimer.Stop()
+			s.retransmissionTimer.Stop()
+			s.timeoutTimer.Stop()
+			s.streamsMu.Lock()
+			for _, stream := range s.streams {
+				stream.cancel(s.closeErr)
+			}
+			s.streamsMu.Unlock()
+			if s.onClose != nil {
+				s.onClose()
+			}
+			return
+		}
+		if s.Err() != nil {
+			continue
+		}
+		if err == nil {
+			err = s.maybeSend()
+		}
+		if err != nil {
+			if qe, ok := err.(*QuicError); ok {
+				s.closeWithError(qe.ErrorCode, qe.ErrorMessage)
+			} else {
+				s.closeWithError(QUIC_INTERNAL_ERROR, err.Error())
+			}
+			continue
+		}
+		s.garbageCollectStreams()
+		if s.closeWhenIdle && s.idle() {
+			s.closeWithError(QUIC_PEER_GOING_AWAY, "server shutting down")
+			continue
+		}
+		s.setTimeoutAlarm()
+	}
+}
+
+// do runs f on the session goroutine.
+func (s *Session) do(f func()) {
+	select {
+	case s.commands <- f:
+	case <-s.closeChan:
+	}
+}
+
+// scheduleSending wakes up the session goroutine to send stream data.
+func (s *Session) scheduleSending() {
+	select {
+	case s.sendingNotify <- struct{}{}:
+	default:
+	}
+}
+
+// Err returns the error the session was closed with, if any.
+func (s *Session) Err() error {
+	select {
+	case <-s.closeChan:
+		return s.closeErr
+	default:
+		return nil
+	}
+}
+
+// MaxPacketSize returns the largest packet size path MTU discovery has found
+// to work.
+func (s *Session) MaxPacketSize() int {
+	return s.mtu.PacketSize()
+}
+
+// RemoteAddr returns the peer's current address.
+func (s *Session) RemoteAddr() net.Addr {
+	s.addrMu.Lock()
+	defer s.addrMu.Unlock()
+	return s.addr
+}
+
+// RTTStats returns the round trip time statistics of the session.
+func (s *Session) RTTStats() *RTTStats {
+	return s.rtt
+}
+
+// close tears down the session.
+func (s *Session) close(err error) {
+	s.closeOnce.Do(func() {
+		s.closeErr = err
+		close(s.closeChan)
+	})
+}
+
+// CloseWithError closes the session, sending the peer a CONNECTION_CLOSE with
+// the error code and reason. It returns once the session is closed, with the
+// error sending the CONNECTION_CLOSE failed with, or errSessionClosed if the
+// session was already closed.
+func (s *Session) CloseWithError(code ErrorCode, reason string) error {
+	errc := make(chan error, 1)
+	select {
+	case s.commands <- func() {
+		if s.Err() != nil {
+			errc <- errSessionClosed
+			return
+		}
+		errc <- s.closeWithError(code, reason)
+	}:
+		return <-errc
+	case <-s.closeChan:
+		return errSessionClosed
+	}
+}
+
+// Close closes the session without an error.
+func (s *Session) Close() error {
+	return s.CloseWithError(QUIC_NO_ERROR, "connection closed")
+}
+
+// GoAway tells the peer that no new streams will be accepted. Streams that
+// are already open carry on as normal.
+func (s *Session) GoAway() {
+	s.do(s.goAway)
+}
+
+// goAway queues a GOAWAY unless one was already sent.
+func (s *Session) goAway() {
+	s.streamsMu.Lock()
+	defer s.streamsMu.Unlock()
+	if s.goingAway {
+		return
+	}
+	s.goingAway = true
+	s.queueFrame(FrameGoAway{
+		ErrorCode:        QUIC_PEER_GOING_AWAY,
+		LastGoodStreamID: s.lastPeerStreamID,
+		Reason:           "going away",
+	})
+}
+
+// shutdown sends a GOAWAY and closes the session once it is idle.
+func (s *Session) shutdown() {
+	s.do(func() {
+		s.goAway()
+		s.closeWhenIdle = true
+	})
+}
+
+// OpenStream opens a new stream to the peer.
+func (s *Session) OpenStream() (*Stream, error) {
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	s.streamsMu.Lock()
+	defer s.streamsMu.Unlock()
+	if s.peerGoingAway {
+		return nil, errPeerGoingAway
+	}
+	if s.numStreams(s.isOwnStream) >= int(s.maxStreams) {
+		return nil, errTooManyOpenStreams
+	}
+	stream := newStream(s.nextStreamID, s)
+	s.streams[stream.id] = stream
+	s.nextStreamID += 2
+	return stream, nil
+}
+
+// AcceptStream waits for the peer to open a stream.
+func (s *Session) AcceptStream() (*Stream, error) {
+	select {
+	case stream := <-s.incomingStreams:
+		return stream, nil
+	case <-s.closeChan:
+		return nil, s.closeErr
+	}
+}
+
+// idle reports whether all streams have finished and the peer has
+// acknowledged everything we sent.
+func (s *Session) idle() bool {
+	return s.activeStreams() == 0 && s.bytesInFlight == 0 && len(s.pendingFrames) == 0 && len(s.retransmissions) == 0
+}
+
+// activeStreams returns the number of streams that haven't finished.
+func (s *Session) activeStreams() int {
+	s.streamsMu.Lock()
+	defer s.streamsMu.Unlock()
+	n := 0
+	for id, stream := range s.streams {
+		if id != headersStreamID && !stream.finished() {
+			n++
+		}
+	}
+	return n
+}
+
+// garbageCollectStreams forgets about streams that have finished.
+func (s *Session) garbageCollectStreams() {
+	s.streamsMu.Lock()
+	defer s.streamsMu.Unlock()
+	for id, stream := range s.streams {
+		if id != headersStreamID && stream.finished() {
+			delete(s.streams, id)
+		}
+	}
+}
+
+// handleStreamFrame hands stream data to its stream, opening it if the peer
+// started a new one.
+func (s *Session) handleStreamFrame(frame FrameStream) error {
+	stream, err := s.getOrOpenPeerStream(frame.StreamID)
+	if stream == nil || err != nil {
+		return err
+	}
+	increment, unread, err := stream.addFrame(frame)
+	if err != nil {
+		return err
+	}
+	if !s.connFlowController.AddHighestReceived(increment) {
+		return qerr(QUIC_FLOW_CONTROL_RECEIVED_TOO_MUCH_DATA, "connection flow control violation")
+	}
+	s.connFlowController.AddBytesRead(unread)
+	return nil
+}
+
+// isOwnStream reports whether the stream ID is one we open.
+func (s *Session) isOwnStream(id uint64) bool {
+	return id%2 == s.nextStreamID%2
+}
+
+// isPeerStream reports whether the stream ID is one the peer opens.
+func (s *Session) isPeerStream(id uint64) bool {
+	return !s.isOwnStream(id)
+}
+
+// numStreams returns the number of open streams whose IDs match, not counting
+// the headers stream. s.streamsMu must be held.
+func (s *Session) numStreams(match func(id uint64) bool) int {
+	n := 0
+	for id := range s.streams {
+		if id != headersStreamID && match(id) {
+			n++
+		}
+	}
+	return n
+}
+
+// setMaxStreams sets the negotiated stream limit.
+func (s *Session) setMaxStreams(n uint32) {
+	s.streamsMu.Lock()
+	s.maxStreams = n
+	s.streamsMu.Unlock()
+}
+
+// getOrOpenPeerStream returns the stream with the given ID. If the peer
+// started a new one, it and any lower numbered streams the peer skipped are
+// opened. It returns nil if the stream has already finished or was refused.
+func (s *Session) getOrOpenPeerStream(id uint64) (*Stream, error) {
+	s.streamsMu.Lock()
+	defer s.streamsMu.Unlock()
+	if stream, ok := s.streams[id]; ok {
+		return stream, nil
+	}
+	if s.isOwnStream(id) {
+		if id == 0 || id >= s.nextStreamID {
+			return nil, qerr(QUIC_INVALID_STREAM_ID, "peer sent data on stream %d which we haven't opened", id)
+		}
+		// One of our streams that has already finished.
+		return nil, nil
+	}
+	if id <= s.lastPeerStreamID {
+		// A stream that has already finished, or a reserved one.
+		return nil, nil
+	}
+	if s.goingAway {
+		s.queueFrame(FrameResetStream{StreamID: id, ErrorCode: QUIC_STREAM_PEER_GOING_AWAY})
+		return nil, nil
+	}
+	newStreams := int(id-s.lastPeerStreamID) / 2
+	if s.numStreams(s.isPeerStream)+newStreams > int(s.maxStreams) {
+		return nil, qerr(QUIC_TOO_MANY_OPEN_STREAMS, "peer opened stream %d, more than %d streams", id, s.maxStreams)
+	}
+	var stream *Stream
+	for next := s.lastPeerStreamID + 2; next <= id; next += 2 {
+		stream = newStream(next, s)
+		select {
+		case s.incomingStreams <- stream:
+			s.streams[next] = stream
+		default:
+			s.queueFrame(FrameResetStream{StreamID: next, ErrorCode: QUIC_REFUSED_STREAM})
+			stream = nil
+		}
+		s.lastPeerStreamID = next
+	}
+	return stream, nil
+}
+
+// handleResetStream aborts a stream the peer reset and acknowledges the reset.
+func (s *Session) handleResetStream(frame FrameResetStream) error {
+	s.streamsMu.Lock()
+	stream, ok := s.streams[frame.StreamID]
+	s.streamsMu.Unlock()
+	if !ok {
+		return nil
+	}
+	offset, finSent := stream.writeState()
+	increment, unread, err := stream.handleResetStream(frame)
+	if err != nil {
+		return err
+	}
+	if !s.connFlowController.AddHighestReceived(increment) {
+		return qerr(QUIC_FLOW_CONTROL_RECEIVED_TOO_MUCH_DATA, "connection flow control violation")
+	}
+	// The data up to the final offset will never be read, so it stops
+	// counting against the connection window.
+	s.connFlowController.AddBytesRead(unread)
+	if !finSent {
+		s.queueFrame(FrameResetStream{
+			StreamID:   frame.StreamID,
+			ErrorCode:  QUIC_RST_ACKNOWLEDGEMENT,
+			ByteOffset: offset,
+		})
+	}
+	return nil
+}
+
+// handleGoAway stops opening streams after the peer sent a GOAWAY. Our
+// streams above the last one the peer processed are aborted.
+func (s *Session) handleGoAway(frame FrameGoAway) {
+	s.streamsMu.Lock()
+	defer s.streamsMu.Unlock()
+	s.peerGoingAway = true
+	for id, stream := range s.streams {
+		if s.isOwnStream(id) && id > frame.LastGoodStreamID {
+			stream.cancel(&ApplicationError{StreamID: id, ErrorCode: QUIC_STREAM_PEER_GOING_AWAY, Remote: true})
+		}
+	}
+}
+
+// handleWindowUpdate raises a send window after a WINDOW_UPDATE from the
+// peer. Stream ID 0 refers to the connection.
+func (s *Session) handleWindowUpdate(frame FrameWindowUpdate) {
+	if frame.StreamID == 0 {
+		s.connFlowController.UpdateSendWindow(frame.ByteOffset)
+		return
+	}
+	s.streamsMu.Lock()
+	stream, ok := s.streams[frame.StreamID]
+	s.streamsMu.Unlock()
+	if ok {
+		stream.flowController.UpdateSendWindow(frame.ByteOffset)
+	}
+}
+
+// queueWindowUpdates queues WINDOW_UPDATE frames for the connection and the
+// streams whose receive windows are running low.
+func (s *Session) queueWindowUpdates() {
+	if offset, ok := s.connFlowController.MaybeWindowUpdate(); ok {
+		s.queueFrame(FrameWindowUpdate{StreamID: 0, ByteOffset: offset})
+	}
+	s.streamsMu.Lock()
+	defer s.streamsMu.Unlock()
+	for id, stream := range s.streams {
+		if offset, ok := stream.flowController.MaybeWindowUpdate(); ok {
+			s.queueFrame(FrameWindowUpdate{StreamID: id, ByteOffset: offset})
+		}
+	}
+}
+
+// streamsWithData returns the streams that have data to send, in ID order.
+func (s *Session) streamsWithData() []*Stream {
+	s.streamsMu.Lock()
+	defer s.streamsMu.Unlock()
+	var streams []*Stream
+	for _, stream := range s.streams {
+		if stream.hasDataForWriting() {
+			streams = append(streams, stream)
+		}
+	}
+	s.scheduler.schedule(streams)
+	return streams
+}
+
+// closeWithError sends a CONNECTION_CLOSE to the peer and tears down the
+// session. The session is closed even if sending fails, and the error is
+// returned.
+func (s *Session) closeWithError(code ErrorCode, reason string) error {
+	maxLength := s.mtu.PacketSize() - maxPacketHeaderSize - s.sealers[s.sealLevel].Overhead() - connectionCloseFrameHeaderSize
+	reason = truncateReason(reason, maxLength)
+	err := s.sendPacket([]Frame{FrameConnectionClose{ErrorCode: code, Reason: reason}})
+	s.close(&QuicError{ErrorCode: code, ErrorMessage: reason})
+	return err
+}
+
+// truncateReason shortens reason to at most n bytes without splitting a
+// UTF-8 character.
+func truncateReason(reason string, n int) string {
+	if len(reason) <= n {
+		return reason
+	}
+	for n > 0 && !utf8.RuneStart(reason[n]) {
+		n--
+	}
+	return reason[:n]
+}
+
+// queuePacket hands a received packet to the session goroutine.
+func (s *Session) queuePacket(p receivedPacket) {
+	select {
+	case s.receivedPackets <- p:
+	case <-s.closeChan:
+	default:
+		log.Println("session receive queue full, dropping packet", p.packet.SequenceNumber)
+	}
+}
+
+// handleReceivedPacket opens a packet and handles its frames. Packets we
+// don't have the keys for yet, such as 0-RTT data that overtook the CHLO, are
+// kept until the handshake provides them.
+func (s *Session) handleReceivedPacket(p receivedPacket) error {
+	level, err := s.openPacket(p)
+	if err == errDecryptionFailed {
+		if !s.handshakeComplete && len(s.undecryptablePackets) < maxUndecryptablePackets {
+			s.undecryptablePackets = append(s.undecryptablePackets, p)
+		}
+		return nil
+	}
+	if err != nil {
+		if level == encryptionUnencrypted {
+			// Unauthenticated packets are dropped, they can't close the
+			// session.
+			return nil
+		}
+		return err
+	}
+	s.lastReceivedTime = time.Now()
+	if err := s.maybeMigrate(p); err != nil {
+		return err
+	}
+	s.receivedLevel = level
+	if err := s.handlePacket(p.packet); err != nil {
+		return err
+	}
+	if s.keysChanged {
+		s.keysChanged = false
+		packets := s.undecryptablePackets
+		s.undecryptablePackets = nil
+		for _, p := range packets {
+			if err := s.handleReceivedPacket(p); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// handlePacket processes a packet received from the peer.
+func (s *Session) handlePacket(p *Packet) error {
+	if ok, err := s.receivedEntropy.RecordPacket(p.SequenceNumber, p.PrivateFlags&FlagEntropy > 0); !ok {
+		return err
+	}
+	if p.SequenceNumber == s.receivedEntropy.largestObserved {
+		s.largestReceivedTime = time.Now()
+	}
+	ackNeeded := false
+	for _, f := range p.Frames {
+		switch frame := f.(type) {
+		case FrameAck:
+			if err := s.handleAckFrame(frame); err != nil {
+				return err
+			}
+		case FrameStream:
+			if frame.StreamID == cryptoStreamID {
+				if err := s.handleCryptoFrame(frame); err != nil {
+					return err
+				}
+			} else if err := s.handleStreamFrame(frame); err != nil {
+				return err
+			}
+			ackNeeded = true
+		case FrameConnectionClose:
+			s.close(&QuicError{ErrorCode: frame.ErrorCode, ErrorMessage: frame.Reason, Remote: true})
+			return nil
+		case FrameGoAway:
+			s.handleGoAway(frame)
+			ackNeeded = true
+		case FrameResetStream:
+			if err := s.handleResetStream(frame); err != nil {
+				return err
+			}
+			ackNeeded = true
+		case FrameWindowUpdate:
+			s.handleWindowUpdate(frame)
+			ackNeeded = true
+		case FrameStopWaiting:
+			leastUnacked := p.SequenceNumber - frame.LeastUnackedDelta
+			s.receivedEntropy.SetCumulativeEntropyUpTo(leastUnacked, frame.SentEntropy)
+		case *FramePadding:
+		default:
+			ackNeeded = true
+		}
+	}
+	if ackNeeded {
+		s.ackPending = true
+	}
+	return nil
+}
+
+// handleAckFrame validates an ACK from the peer.
+func (s *Session) handleAckFrame(frame FrameAck) error {
+	if frame.LargestObserved < s.largestObservedByPeer {
+		// Stale ACK that arrived out of order.
+		return nil
+	}
+	if frame.LargestObserved > s.sequenceNumber ||
+		!s.sentEntropy.IsValidEntropy(frame.LargestObserved, frame.MissingPackets, frame.ReceivedEntropy) {
+		return errInvalidAckEntropy
+	}
+	s.largestObservedByPeer = frame.LargestObserved
+	s.onPathAck(frame.LargestObserved)
+
+	now := time.Now()
+	// The delay of a truncated ACK is for a packet above LargestObserved.
+	if sent, ok := s.sentPackets[frame.LargestObserved]; ok && !frame.Truncated {
+		ackDelay := time.Duration(frame.LargestObservedDeltaTime) * time.Microsecond
+		s.rtt.UpdateRTT(now.Sub(sent.sentTime), ackDelay, now)
+	}
+	missing := map[uint64]bool{}
+	for _, seq := range frame.MissingPackets {
+		missing[seq] = true
+	}
+	var acked, lost []uint64
+	// The peer has processed the STOP_WAITING in any packet it ACKs.
+	var peerLeastUnacked uint64
+	for seq := range s.sentPackets {
+		if seq > frame.LargestObserved {
+			continue
+		}
+		if !missing[seq] {
+			acked = append(acked, seq)
+		} else if frame.LargestObserved-seq >= nackThreshold {
+			lost = append(lost, seq)
+		}
+	}
+	sort.Sort(uint64Slice(acked))
+	for _, seq := range acked {
+		sent := s.sentPackets[seq]
+		delete(s.sentPackets, seq)
+		if sent.stopWaiting > peerLeastUnacked {
+			peerLeastUnacked = sent.stopWaiting
+		}
+		s.mtu.OnPacketAcked(int(sent.length), sent.mtuProbe)
+		if sent.retransmittable {
+			s.bytesInFlight -= sent.length
+			s.congestion.OnPacketAcked(now, seq, sent.length, s.bytesInFlight)
+			// Something got through, so the path is working again.
+			s.consecutiveRTOCount = 0
+			s.consecutiveTLPCount = 0
+			s.consecutiveCryptoRetransmits = 0
+		}
+	}
+	sort.Sort(uint64Slice(lost))
+	for _, seq := range lost {
+		sent := s.sentPackets[seq]
+		s.retransmit(sent)
+		if sent.mtuProbe {
+			// Probes are lost because they're too large, not because of
+			// congestion.
+			continue
+		}
+		s.mtu.OnPacketLost(int(sent.length), false)
+		if sent.retransmittable {
+			s.congestion.OnPacketLost(seq, sent.length, s.bytesInFlight)
+		}
+	}
+	s.setRetransmissionAlarm()
+
+	// The peer stopped waiting for the packets below the STOP_WAITING it
+	// received, so it can't report them as missing again and their entropy
+	// is no longer needed.
+	s.sentEntropy.ClearBefore(peerLeastUnacked)
+	return nil
+}
+
+// leastUnacked returns the lowest sequence number that hasn't been acked or
+// declared lost, which is what our STOP_WAITING tells the peer to wait for.
+func (s *Session) leastUnacked() uint64 {
+	least := s.sequenceNumber + 1
+	for seq := range s.sentPackets {
+		if seq < least {
+			least = seq
+		}
+	}
+	return least
+}
+
+// canSend reports whether the congestion controller allows sending another
+// retransmittable packet.
+func (s *Session) canSend() bool {
+	return s.congestion.CanSend(s.bytesInFlight)
+}
+
+// queueFrame queues a frame to be sent once congestion control and pacing
+// allow it.
+func (s *Session) queueFrame(f Frame) {
+	s.pendingFrames = append(s.pendingFrames, f)
+}
+
+// maybeSend sends queued frames and stream data for as long as the
+// congestion controller and pacer allow. If the pacer asks us to wait, the
+// send timer is armed instead.
+func (s *Session) maybeSend() error {
+	s.queueWindowUpdates()
+	if len(s.retransmissions) > 0 {
+		// Lost data goes out before anything new.
+		s.pendingFrames = append(s.retransmissions, s.pendingFrames...)
+		s.retransmissions = nil
+	}
+	for len(s.pendingFrames) > 0 || len(s.streamsWithData()) > 0 {
+		if s.pathLimited() {
+			// Wait for the new peer address to be validated.
+			break
+		} else if s.forceSendPackets > 0 {
+			// Probes are sent regardless of the congestion window.
+			s.forceSendPackets--
+		} else if !s.canSend() {
+			// An ACK will open the window again.
+			break
+		} else if delay := s.pacer.TimeUntilSend(time.Now(), s.bytesInFlight); delay > 0 {
+			s.sendTimer.Reset(delay)
+			break
+		}
+		frames, level, err := s.packPacket(false)
+		if err != nil {
+			return err
+		}
+		if len(frames) == 0 {
+			break
+		}
+		if err := s.writePacket(frames, level, false); err != nil {
+			return err
+		}
+	}
+	if size, ok := s.mtu.ProbeSize(); ok && s.handshakeComplete && s.canSend() && !s.pathLimited() {
+		if err := s.sendMTUProbe(size); err != nil {
+			return err
+		}
+	}
+	if s.ackPending {
+		// Nothing else could be sent, ACKs aren't congestion controlled.
+		frames, level, err := s.packPacket(true)
+		if err != nil {
+			return err
+		}
+		return s.writePacket(frames, level, false)
+	}
+	return nil
+}
+
+// sendPacket serializes the frames into a new packet and writes it to the peer.
+func (s *Session) sendPacket(frames []Frame) error {
+	return s.writePacket(frames, s.sealLevel, false)
+}
+
+// sendMTUProbe sends a PING padded to size bytes to find out whether the
+// path supports packets that large.
+func (s *Session) sendMTUProbe(size int) error {
+	padding := size - maxPacketHeaderSize - s.sealers[s.sealLevel].Overhead() - 1
+	return s.writePacket([]Frame{FramePing{}, FramePadding{Size: padding}}, s.sealLevel, true)
+}
+
+// writePacket serializes the frames into a new packet and writes it to the
+// peer. Only MTU probes may exceed the current packet size.
+func (s *Session) writePacket(frames []Frame, level encryptionLevel, mtuProbe bool) error {
+	s.sequenceNumber++
+	p := Packet{
+		PublicFlags:    ConnID8Bytes | SequenceNumber6Bytes,
+		ConnID:         s.ConnID,
+		SequenceNumber: s.sequenceNumber,
+		Frames:         frames,
+	}
+	entropy := randomEntropy()
+	if entropy {
+		p.PrivateFlags |= FlagEntropy
+	}
+	s.sentEntropy.RecordPacket(p.SequenceNumber, entropy)
+	payload, err := p.PayloadToBuf()
+	if err != nil {
+		return err
+	}
+	header := p.PublicHeaderToBuf()
+	buf := append(header, s.sealers[level].Seal(payload, p.SequenceNumber, header)...)
+	if !mtuProbe && len(buf) > s.mtu.PacketSize() {
+		return qerr(QUIC_PACKET_TOO_LARGE, "packet of %d bytes", len(buf))
+	}
+	sent := &sentPacket{
+		sequenceNumber: p.SequenceNumber,
+		sentTime:       time.Now(),
+		length:         uint64(len(buf)),
+		mtuProbe:       mtuProbe,
+		level:          level,
+	}
+	for _, f := range frames {
+		if frame, ok := f.(FrameStopWaiting); ok {
+			sent.stopWaiting = p.SequenceNumber - frame.LeastUnackedDelta
+		}
+		if isRetransmittable(f) {
+			sent.retransmittable = true
+			sent.frames = append(sent.frames, f)
+		}
+		if isHandshakeFrame(f) {
+			sent.handshake = true
+		}
+	}
+	s.sentPackets[p.SequenceNumber] = sent
+	s.congestion.OnPacketSent(sent.sentTime, s.bytesInFlight, sent.sequenceNumber, sent.length, sent.retransmittable)
+	s.pacer.OnPacketSent(sent.sentTime, s.bytesInFlight, sent.length, sent.retransmittable)
+	if sent.retransmittable {
+		s.bytesInFlight += sent.length
+		s.lastRetransmittableSentTime = sent.sentTime
+		if s.firstSentAfterReceiveTime.Before(s.lastReceivedTime) {
+			s.firstSentAfterReceiveTime = sent.sentTime
+		}
+		s.setRetransmissionAlarm()
+	}
+	s.mtu.OnPacketSent(mtuProbe)
+	s.pathBytesSent += uint64(len(buf))
+	_, err = s.conn.WriteTo(buf, s.addr)
+	if err != nil && mtuProbe {
+		// The local interface can't send packets this large.
+		s.retransmit(sent)
+		return nil
+	}
+	return err
+}
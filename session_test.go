@@ -0,0 +1,344 @@
+package quic
+
+import (
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+// recordingConn is a PacketConn that keeps the packets written to it.
+type recordingConn struct {
+	mu      sync.Mutex
+	packets [][]byte
+}
+
+func (c *recordingConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	return 0, nil, net.ErrClosed
+}
+
+func (c *recordingConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.packets = append(c.packets, append([]byte(nil), b...))
+	return len(b), nil
+}
+
+func (c *recordingConn) Close() error                       { return nil }
+func (c *recordingConn) LocalAddr() net.Addr                { return &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)} }
+func (c *recordingConn) SetDeadline(t time.Time) error      { return nil }
+func (c *recordingConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *recordingConn) SetWriteDeadline(t time.Time) error { return nil }
+
+// newTestSession returns a server session writing to a recordingConn. It is
+// closed when the test ends.
+func newTestSession(t *testing.T) (*Session, *recordingConn) {
+	server, err := newServerCrypto(time.Now, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newTestServerSession(t, server)
+}
+
+// newTestServerSession is like newTestSession, with the listener state in
+// server.
+func newTestServerSession(t *testing.T, server *serverCrypto) (*Session, *recordingConn) {
+	conn := &recordingConn{}
+	addr := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 4433}
+	s := newSession(conn, addr, 1, &Config{}, server, nil)
+	t.Cleanup(func() { s.Close() })
+	return s, conn
+}
+
+// doSync runs f on the session goroutine and waits for it to finish.
+func doSync(s *Session, f func()) {
+	done := make(chan struct{})
+	s.do(func() {
+		defer close(done)
+		f()
+	})
+	<-done
+}
+
+func TestStopWaitingAdvancesWithLoss(t *testing.T) {
+	s, _ := newTestSession(t)
+	peer := newReceivedEntropyManager()
+	const packets = 3000
+
+	var (
+		err         error
+		stopWaiting uint64
+		truncated   bool
+	)
+	doSync(s, func() {
+		for i := 0; i < packets && err == nil; i++ {
+			s.ackPending = true
+			s.queueFrame(FramePing{})
+			var frames []Frame
+			var level encryptionLevel
+			if frames, level, err = s.packPacket(false); err != nil {
+				return
+			}
+			if err = s.writePacket(frames, level, false); err != nil {
+				return
+			}
+			seq := s.sequenceNumber
+			sw := frames[1].(FrameStopWaiting)
+			stopWaiting = seq - sw.LeastUnackedDelta
+			// Every seventh packet is lost and the retransmissions are
+			// never sent, so the peer keeps reporting holes.
+			if seq%7 == 0 {
+				continue
+			}
+			if _, err = peer.RecordPacket(seq, s.sentEntropy.hashes[seq] != 0); err != nil {
+				return
+			}
+			peer.SetCumulativeEntropyUpTo(stopWaiting, sw.SentEntropy)
+			ack := peer.AckFrame(initialPacketSize)
+			truncated = truncated || ack.Truncated
+			if err = s.handleAckFrame(ack); err != nil {
+				return
+			}
+			s.retransmissions = nil
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if truncated {
+		t.Errorf("the peer sent a truncated ACK")
+	}
+	if stopWaiting+2*nackThreshold*7 < packets {
+		t.Errorf("last STOP_WAITING waits for packet %d of %d", stopWaiting, packets)
+	}
+	if n := len(peer.MissingPackets()); n > nackThreshold {
+		t.Errorf("peer still reports %d packets missing", n)
+	}
+}
+
+func TestCloseWithError(t *testing.T) {
+	s, conn := newTestSession(t)
+	reason := strings.Repeat("é", 2*maxReasonLength)
+	if err := s.CloseWithError(QUIC_INTERNAL_ERROR, reason); err != nil {
+		t.Fatalf("CloseWithError() = %v", err)
+	}
+	conn.mu.Lock()
+	last := conn.packets[len(conn.packets)-1]
+	conn.mu.Unlock()
+	if len(last) > initialPacketSize {
+		t.Errorf("CONNECTION_CLOSE packet is %d bytes, want at most %d", len(last), initialPacketSize)
+	}
+	qe, ok := s.Err().(*QuicError)
+	if !ok || qe.ErrorCode != QUIC_INTERNAL_ERROR {
+		t.Fatalf("Err() = %v, want QUIC_INTERNAL_ERROR", s.Err())
+	}
+	if !utf8.ValidString(qe.ErrorMessage) || !strings.HasPrefix(reason, qe.ErrorMessage) || len(qe.ErrorMessage) < initialPacketSize/2 {
+		t.Errorf("reason truncated to %d bytes", len(qe.ErrorMessage))
+	}
+
+	if err := s.CloseWithError(QUIC_NO_ERROR, ""); err != errSessionClosed {
+		t.Errorf("second CloseWithError() = %v, want %v", err, errSessionClosed)
+	}
+}
+
+func TestReasonTooLong(t *testing.T) {
+	reason := strings.Repeat("a", maxReasonLength+1)
+	if _, err := (FrameConnectionClose{Reason: reason}).ToBuf(); !errors.Is(err, QUIC_INVALID_CONNECTION_CLOSE_DATA) {
+		t.Errorf("FrameConnectionClose.ToBuf() with a reason that doesn't fit = %v, want QUIC_INVALID_CONNECTION_CLOSE_DATA", err)
+	}
+	if _, err := (FrameGoAway{Reason: reason}).ToBuf(); !errors.Is(err, QUIC_INVALID_GOAWAY_DATA) {
+		t.Errorf("FrameGoAway.ToBuf() with a reason that doesn't fit = %v, want QUIC_INVALID_GOAWAY_DATA", err)
+	}
+}
+
+func TestGoAway(t *testing.T) {
+	s, _ := newTestSession(t)
+	doSync(s, func() {
+		if _, err := s.getOrOpenPeerStream(5); err != nil {
+			t.Error(err)
+		}
+		s.goAway()
+		s.goAway()
+		var goAways []FrameGoAway
+		for _, f := range s.pendingFrames {
+			if frame, ok := f.(FrameGoAway); ok {
+				goAways = append(goAways, frame)
+			}
+		}
+		if len(goAways) != 1 || goAways[0].LastGoodStreamID != 5 {
+			t.Errorf("queued %+v, want one GOAWAY with last good stream 5", goAways)
+		}
+
+		// Streams the peer opens afterwards are reset.
+		s.pendingFrames = nil
+		stream, err := s.getOrOpenPeerStream(7)
+		if stream != nil || err != nil {
+			t.Errorf("getOrOpenPeerStream() after GOAWAY = %v, %v, want nil, nil", stream, err)
+		}
+		want := []Frame{FrameResetStream{StreamID: 7, ErrorCode: QUIC_STREAM_PEER_GOING_AWAY}}
+		if !reflect.DeepEqual(s.pendingFrames, want) {
+			t.Errorf("queued %+v, want %+v", s.pendingFrames, want)
+		}
+	})
+}
+
+func TestStreamIDs(t *testing.T) {
+	server, _ := newTestSession(t)
+	client := newTestClientSession(t)
+	for _, tt := range []struct {
+		name string
+		s    *Session
+		want []uint64
+	}{
+		{"server", server, []uint64{2, 4}},
+		{"client", client, []uint64{5, 7}},
+	} {
+		for _, want := range tt.want {
+			stream, err := tt.s.OpenStream()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := stream.StreamID(); got != want {
+				t.Errorf("%s opened stream %d, want %d", tt.name, got, want)
+			}
+		}
+	}
+}
+
+func TestOpenStreamLimit(t *testing.T) {
+	s, _ := newTestSession(t)
+	s.setMaxStreams(2)
+	// Streams the peer opens don't count against our limit.
+	doSync(s, func() {
+		if _, err := s.getOrOpenPeerStream(5); err != nil {
+			t.Error(err)
+		}
+	})
+	for i := 0; i < 2; i++ {
+		if _, err := s.OpenStream(); err != nil {
+			t.Fatalf("OpenStream() %d = %v", i, err)
+		}
+	}
+	if _, err := s.OpenStream(); err != errTooManyOpenStreams {
+		t.Errorf("OpenStream() past the limit = %v, want %v", err, errTooManyOpenStreams)
+	}
+	if !errors.Is(errTooManyOpenStreams, QUIC_TOO_MANY_OPEN_STREAMS) {
+		t.Error("errTooManyOpenStreams isn't QUIC_TOO_MANY_OPEN_STREAMS")
+	}
+}
+
+func TestPeerStreamLimit(t *testing.T) {
+	s, _ := newTestSession(t)
+	s.setMaxStreams(2)
+	var got *Stream
+	var opened int
+	var errs []error
+	doSync(s, func() {
+		// Opening stream 7 implicitly opens the skipped stream 5.
+		stream, err := s.getOrOpenPeerStream(7)
+		got = stream
+		errs = append(errs, err)
+		opened = s.numStreams(s.isPeerStream)
+		_, err = s.getOrOpenPeerStream(9)
+		errs = append(errs, err)
+		// Streams the peer already opened are still found.
+		_, err = s.getOrOpenPeerStream(5)
+		errs = append(errs, err)
+	})
+	if errs[0] != nil {
+		t.Fatal(errs[0])
+	}
+	if got == nil || got.StreamID() != 7 || opened != 2 {
+		t.Errorf("getOrOpenPeerStream(7) = %v and opened %d streams, want stream 7 and 2 streams", got, opened)
+	}
+	if !errors.Is(errs[1], QUIC_TOO_MANY_OPEN_STREAMS) {
+		t.Errorf("getOrOpenPeerStream(9) past the limit = %v, want QUIC_TOO_MANY_OPEN_STREAMS", errs[1])
+	}
+	if errs[2] != nil {
+		t.Errorf("getOrOpenPeerStream(5) = %v", errs[2])
+	}
+
+	// Skipping streams counts them against the limit too.
+	s, _ = newTestSession(t)
+	s.setMaxStreams(2)
+	var err error
+	doSync(s, func() { _, err = s.getOrOpenPeerStream(9) })
+	if !errors.Is(err, QUIC_TOO_MANY_OPEN_STREAMS) {
+		t.Errorf("getOrOpenPeerStream(9) skipping streams = %v, want QUIC_TOO_MANY_OPEN_STREAMS", err)
+	}
+}
+
+func TestGetOrOpenPeerStream(t *testing.T) {
+	s, _ := newTestSession(t)
+	own, err := s.OpenStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		id     uint64
+		stream bool
+		code   ErrorCode
+	}{
+		{"our open stream", own.StreamID(), true, 0},
+		{"our unopened stream", own.StreamID() + 2, false, QUIC_INVALID_STREAM_ID},
+		{"stream 0", 0, false, QUIC_INVALID_STREAM_ID},
+		{"headers stream", headersStreamID, true, 0},
+		{"new peer stream", 7, true, 0},
+		{"skipped peer stream", 5, true, 0},
+	}
+	for _, tt := range tests {
+		var stream *Stream
+		doSync(s, func() { stream, err = s.getOrOpenPeerStream(tt.id) })
+		if tt.code != 0 {
+			if !errors.Is(err, tt.code) {
+				t.Errorf("%s: getOrOpenPeerStream(%d) = %v, want %s", tt.name, tt.id, err, tt.code)
+			}
+			continue
+		}
+		if err != nil || (stream != nil) != tt.stream {
+			t.Errorf("%s: getOrOpenPeerStream(%d) = %v, %v", tt.name, tt.id, stream, err)
+		}
+	}
+
+	// Finished streams are ignored rather than reopened.
+	var finished, ownFinished *Stream
+	doSync(s, func() {
+		delete(s.streams, 5)
+		delete(s.streams, own.StreamID())
+		finished, err = s.getOrOpenPeerStream(5)
+		if err == nil {
+			ownFinished, err = s.getOrOpenPeerStream(own.StreamID())
+		}
+	})
+	if finished != nil || ownFinished != nil || err != nil {
+		t.Errorf("getOrOpenPeerStream() on finished streams = %v, %v, %v, want nil", finished, ownFinished, err)
+	}
+}
+
+func TestPeerStreamRefused(t *testing.T) {
+	s, _ := newTestSession(t)
+	var stream *Stream
+	var err error
+	var queued []Frame
+	doSync(s, func() {
+		// Nobody calls AcceptStream.
+		for i := 0; i < maxIncomingStreamQueue; i++ {
+			s.incomingStreams <- nil
+		}
+		s.pendingFrames = nil
+		stream, err = s.getOrOpenPeerStream(5)
+		queued = s.pendingFrames
+	})
+	if stream != nil || err != nil {
+		t.Errorf("getOrOpenPeerStream() with a full accept queue = %v, %v, want nil, nil", stream, err)
+	}
+	want := []Frame{FrameResetStream{StreamID: 5, ErrorCode: QUIC_REFUSED_STREAM}}
+	if !reflect.DeepEqual(queued, want) {
+		t.Errorf("queued %+v, want %+v", queued, want)
+	}
+}
@@ -0,0 +1,153 @@
+package quic
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Source-address token constants
+const (
+	// stkLifetime is how long a source-address token is accepted for.
+	stkLifetime = 24 * time.Hour
+	// stkSecretLifetime is how often the secret tokens are sealed with is
+	// rotated. Tokens sealed with the previous secret are still accepted.
+	stkSecretLifetime = stkLifetime
+	// stkClockSkew is how far in the future a token's timestamp may be.
+	stkClockSkew = time.Minute
+	// stkSecretSize is the size of the AES-256 key tokens are sealed with.
+	stkSecretSize = 32
+)
+
+// stkAdditionalData binds the sealed tokens to their purpose.
+var stkAdditionalData = []byte("QUIC source address token")
+
+var (
+	errSTKInvalid  = errors.New("quic: invalid source-address token")
+	errSTKExpired  = errors.New("quic: source-address token expired")
+	errSTKWrongIP  = errors.New("quic: source-address token for a different address")
+	errSTKNotValid = errors.New("quic: source-address token from the future")
+)
+
+// stkSource mints and validates source-address tokens (STK). A server hands
+// out a token in REJ, and a client that presents it in its next CHLO proves
+// it received packets sent to its address, without the server keeping any
+// state. Tokens hold the client IP and a timestamp, sealed with AES-GCM under
+// a secret that is rotated every stkSecretLifetime.
+type stkSource struct {
+	now func() time.Time
+
+	mu             sync.Mutex
+	current        cipher.AEAD
+	previous       cipher.AEAD
+	secretRotation time.Time
+}
+
+// newSTKSource returns a token source that reads the time from now.
+func newSTKSource(now func() time.Time) *stkSource {
+	return &stkSource{now: now}
+}
+
+// aeads returns the AEADs for the current and previous secret, rotating
+// them if it's time to.
+func (s *stkSource) aeads() (current, previous cipher.AEAD, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	if s.current == nil || !now.Before(s.secretRotation) {
+		aead, err := newSTKAEAD()
+		if err != nil {
+			return nil, nil, err
+		}
+		s.previous = s.current
+		s.current = aead
+		s.secretRotation = now.Add(stkSecretLifetime)
+	}
+	return s.current, s.previous, nil
+}
+
+func newSTKAEAD() (cipher.AEAD, error) {
+	secret := make([]byte, stkSecretSize)
+	if _, err := io.ReadFull(rand.Reader, secret); err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(secret)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// NewToken returns a token for the IP of addr.
+func (s *stkSource) NewToken(addr net.Addr) ([]byte, error) {
+	aead, _, err := s.aeads()
+	if err != nil {
+		return nil, err
+	}
+	plaintext := make([]byte, net.IPv6len+8)
+	copy(plaintext, stkAddress(addr))
+	binary.LittleEndian.PutUint64(plaintext[net.IPv6len:], uint64(s.now().Unix()))
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, stkAdditionalData), nil
+}
+
+// ValidateToken checks that the token was minted by us for the IP of addr
+// and hasn't expired.
+func (s *stkSource) ValidateToken(token []byte, addr net.Addr) error {
+	current, previous, err := s.aeads()
+	if err != nil {
+		return err
+	}
+	plaintext, err := openSTK(current, token)
+	if err != nil && previous != nil {
+		plaintext, err = openSTK(previous, token)
+	}
+	if err != nil || len(plaintext) != net.IPv6len+8 {
+		return errSTKInvalid
+	}
+	if !bytes.Equal(plaintext[:net.IPv6len], stkAddress(addr)) {
+		return errSTKWrongIP
+	}
+	minted := time.Unix(int64(binary.LittleEndian.Uint64(plaintext[net.IPv6len:])), 0)
+	now := s.now()
+	if minted.After(now.Add(stkClockSkew)) {
+		return errSTKNotValid
+	}
+	if now.Sub(minted) > stkLifetime {
+		return errSTKExpired
+	}
+	return nil
+}
+
+func openSTK(aead cipher.AEAD, token []byte) ([]byte, error) {
+	if len(token) < aead.NonceSize() {
+		return nil, errSTKInvalid
+	}
+	nonce := token[:aead.NonceSize()]
+	return aead.Open(nil, nonce, token[aead.NonceSize():], stkAdditionalData)
+}
+
+// stkAddress returns the 16 bytes of addr a token is bound to: the IP of a
+// UDP address, the port isn't included since NATs change it. Other addresses
+// are bound as a whole.
+func stkAddress(addr net.Addr) []byte {
+	if udp, ok := addr.(*net.UDPAddr); ok {
+		if ip := udp.IP.To16(); ip != nil {
+			return ip
+		}
+	}
+	sum := sha256.Sum256([]byte(addr.Network() + " " + addr.String()))
+	return sum[:net.IPv6len]
+}
@@ -0,0 +1,203 @@
+package quic
+
+import (
+	"bytes"
+	"crypto/tls"
+	"net"
+	"testing"
+	"time"
+)
+
+// fakeClock is a clock tests move by hand.
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func TestSTKValidation(t *testing.T) {
+	client := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 4433}
+	tests := []struct {
+		name string
+		// later is how long after minting the token is validated.
+		later time.Duration
+		// edit changes the token before it is validated.
+		edit func(token []byte) []byte
+		addr net.Addr
+		want error
+	}{
+		{name: "valid", addr: client},
+		{name: "port changed", addr: &net.UDPAddr{IP: client.IP, Port: 1}},
+		{name: "replayed a day later", later: stkLifetime, addr: client},
+		{name: "expired", later: stkLifetime + time.Second, addr: client, want: errSTKExpired},
+		{name: "different address", addr: &net.UDPAddr{IP: net.IPv4(192, 0, 2, 2), Port: 4433}, want: errSTKWrongIP},
+		{name: "IPv6 address", addr: &net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 4433}, want: errSTKWrongIP},
+		{
+			name: "flipped bit",
+			edit: func(token []byte) []byte {
+				token[len(token)-1] ^= 1
+				return token
+			},
+			addr: client,
+			want: errSTKInvalid,
+		},
+		{
+			name: "truncated",
+			edit: func(token []byte) []byte { return token[:8] },
+			addr: client,
+			want: errSTKInvalid,
+		},
+		{
+			name: "empty",
+			edit: func(token []byte) []byte { return nil },
+			addr: client,
+			want: errSTKInvalid,
+		},
+		{
+			name: "minted by another server",
+			edit: func([]byte) []byte {
+				token, err := newSTKSource(time.Now).NewToken(client)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return token
+			},
+			addr: client,
+			want: errSTKInvalid,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := &fakeClock{now: time.Unix(1500000000, 0)}
+			s := newSTKSource(clock.Now)
+			token, err := s.NewToken(client)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.edit != nil {
+				token = tt.edit(token)
+			}
+			clock.now = clock.now.Add(tt.later)
+			if err := s.ValidateToken(token, tt.addr); err != tt.want {
+				t.Errorf("ValidateToken() = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestSTKFromTheFuture(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1500000000, 0)}
+	s := newSTKSource(clock.Now)
+	client := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 4433}
+	token, err := s.NewToken(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The clock stepped back, e.g. after an NTP correction.
+	clock.now = clock.now.Add(-stkClockSkew)
+	if err := s.ValidateToken(token, client); err != nil {
+		t.Errorf("ValidateToken() with the clock %s behind = %v, want nil", stkClockSkew, err)
+	}
+	clock.now = clock.now.Add(-time.Second)
+	if err := s.ValidateToken(token, client); err != errSTKNotValid {
+		t.Errorf("ValidateToken() = %v, want %v", err, errSTKNotValid)
+	}
+}
+
+func TestSTKSecretRotation(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1500000000, 0)}
+	s := newSTKSource(clock.Now)
+	client := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 4433}
+	old, err := s.NewToken(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Tokens sealed with the previous secret are still accepted.
+	clock.now = clock.now.Add(stkSecretLifetime - time.Hour)
+	fresh, err := s.NewToken(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock.now = clock.now.Add(time.Hour)
+	if err := s.ValidateToken(old, client); err != nil {
+		t.Errorf("ValidateToken(old) after rotation = %v, want nil", err)
+	}
+	if err := s.ValidateToken(fresh, client); err != nil {
+		t.Errorf("ValidateToken(fresh) after rotation = %v, want nil", err)
+	}
+	if _, err := s.NewToken(client); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ValidateToken(fresh, client); err != nil {
+		t.Errorf("ValidateToken(fresh) with a new token minted = %v, want nil", err)
+	}
+}
+
+func TestAddressValidation(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := NewListener(conn, &Config{TLSConfig: testTLSConfig(t)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			if _, err := l.Accept(); err != nil {
+				return
+			}
+		}
+	}()
+
+	cache := NewLRUClientSessionCache(1)
+	config := &Config{
+		TLSConfig:          &tls.Config{InsecureSkipVerify: true},
+		ClientSessionCache: cache,
+		Disable0RTT:        true,
+	}
+	// dial completes a handshake and returns the number of REJs the server
+	// sent.
+	dial := func() int {
+		s, err := Dial(conn.LocalAddr().String(), config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer s.Close()
+		var rejects int
+		doSync(s, func() { rejects = s.rejects })
+		return rejects
+	}
+
+	// The first CHLO has no token, the server sends one in its REJ.
+	if rejects := dial(); rejects != 1 {
+		t.Errorf("first session got %d REJs, want 1", rejects)
+	}
+	state, ok := cache.Get("127.0.0.1")
+	if !ok || state.SourceAddressToken == nil {
+		t.Fatal("the client didn't cache the source-address token")
+	}
+
+	// A cached token proves the address, so the CHLO is accepted.
+	if rejects := dial(); rejects != 0 {
+		t.Errorf("session with a valid token got %d REJs, want 0", rejects)
+	}
+
+	// A forged token is rejected, and the REJ carries a new one.
+	forged := append([]byte(nil), state.SourceAddressToken...)
+	forged[len(forged)-1] ^= 0xff
+	cache.Put("127.0.0.1", &ClientSessionState{
+		ServerConfig:       state.ServerConfig,
+		SourceAddressToken: forged,
+		Certificates:       state.Certificates,
+	})
+	if rejects := dial(); rejects != 1 {
+		t.Errorf("session with a forged token got %d REJs, want 1", rejects)
+	}
+	if state, _ := cache.Get("127.0.0.1"); bytes.Equal(state.SourceAddressToken, forged) {
+		t.Error("the forged token wasn't replaced")
+	}
+}
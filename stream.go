@@ -0,0 +1,421 @@
+package quic
+
+import (
+	"errors"
+	"io"
+	"sync"
+)
+
+// errStreamClosed is returned when writing to a stream after Close.
+var errStreamClosed = &QuicError{
+	ErrorCode:    QUIC_STREAM_DATA_AFTER_TERMINATION,
+	ErrorMessage: "write on closed stream",
+}
+
+// errReadStopped is returned when reading from a stream after stopReading.
+var errReadStopped = errors.New("quic: read on a stream that stopped reading")
+
+// Stream represents a single QUIC stream within a session
+type Stream struct {
+	id      uint64
+	session *Session
+
+	mu sync.Mutex
+	// err is set when the stream or session is torn down.
+	err            error
+	flowController *flowController
+	priority       uint8
+
+	// Receive side
+	frames      map[uint64]string
+	readOffset  uint64
+	finReceived bool
+	finOffset   uint64
+	readChan    chan struct{}
+	// readStopped is set once we told the peer we won't read the rest of
+	// its data. What it still sends is given back to connection flow
+	// control.
+	readStopped bool
+
+	// Send side
+	dataForWriting []byte
+	writeOffset    uint64
+	finQueued      bool
+	finSent        bool
+	// writeErr is set once the peer asked us to stop sending.
+	writeErr error
+	// resetSent is set once we reset the stream. It is kept until the peer's
+	// final offset arrives, so the data it still sends can be given back to
+	// connection flow control.
+	resetSent bool
+}
+
+func newStream(id uint64, session *Session) *Stream {
+	return &Stream{
+		id:             id,
+		session:        session,
+		frames:         map[uint64]string{},
+		readChan:       make(chan struct{}, 1),
+		flowController: newFlowController(defaultStreamWindow),
+		priority:       defaultPriority,
+	}
+}
+
+// StreamID returns the ID of the stream.
+func (s *Stream) StreamID() uint64 {
+	return s.id
+}
+
+// Priority returns the priority of the stream, from 0 (highest) to 7 (lowest).
+func (s *Stream) Priority() uint8 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.priority
+}
+
+// SetPriority sets the priority of the stream, from 0 (highest) to 7
+// (lowest). Data of higher priority streams is sent first. The default is 3.
+func (s *Stream) SetPriority(priority uint8) error {
+	if priority > lowestPriority {
+		return qerr(QUIC_INVALID_PRIORITY, "invalid priority %d for stream %d", priority, s.id)
+	}
+	s.mu.Lock()
+	s.priority = priority
+	s.mu.Unlock()
+	s.session.scheduleSending()
+	return nil
+}
+
+// Read reads data received on the stream. It returns io.EOF once the peer
+// has finished sending.
+func (s *Stream) Read(p []byte) (int, error) {
+	for {
+		s.mu.Lock()
+		if s.err != nil {
+			s.mu.Unlock()
+			return 0, s.err
+		}
+		if s.readStopped {
+			s.mu.Unlock()
+			return 0, errReadStopped
+		}
+		if data, ok := s.dataAtReadOffset(); ok {
+			n := copy(p, data)
+			s.readOffset += uint64(n)
+			s.mu.Unlock()
+			s.flowController.AddBytesRead(uint64(n))
+			s.session.connFlowController.AddBytesRead(uint64(n))
+			// Let the session send a WINDOW_UPDATE if needed.
+			s.session.scheduleSending()
+			return n, nil
+		}
+		if s.finReceived && s.readOffset >= s.finOffset {
+			s.mu.Unlock()
+			return 0, io.EOF
+		}
+		s.mu.Unlock()
+
+		select {
+		case <-s.readChan:
+		case <-s.session.closeChan:
+			return 0, s.session.closeErr
+		}
+	}
+}
+
+// dataAtReadOffset returns the received data starting at the read offset,
+// dropping frames that have been read already. s.mu must be held.
+func (s *Stream) dataAtReadOffset() (string, bool) {
+	for offset, data := range s.frames {
+		end := offset + uint64(len(data))
+		if end <= s.readOffset {
+			delete(s.frames, offset)
+			continue
+		}
+		if offset <= s.readOffset {
+			return data[s.readOffset-offset:], true
+		}
+	}
+	return "", false
+}
+
+// Write queues data to be sent on the stream.
+func (s *Stream) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	if s.err != nil {
+		s.mu.Unlock()
+		return 0, s.err
+	}
+	if s.writeErr != nil {
+		s.mu.Unlock()
+		return 0, s.writeErr
+	}
+	if s.finQueued {
+		s.mu.Unlock()
+		return 0, errStreamClosed
+	}
+	s.dataForWriting = append(s.dataForWriting, p...)
+	s.mu.Unlock()
+	s.session.scheduleSending()
+	return len(p), nil
+}
+
+// Close finishes the sending side of the stream. Data already written will
+// still be delivered.
+func (s *Stream) Close() error {
+	s.mu.Lock()
+	s.finQueued = true
+	s.mu.Unlock()
+	s.session.scheduleSending()
+	return nil
+}
+
+// addFrame hands data received from the peer to the stream. It returns by
+// how much the highest received offset grew and how many bytes will never be
+// read because the stream was torn down, both of which count against
+// connection flow control.
+func (s *Stream) addFrame(frame FrameStream) (increment, unread uint64, err error) {
+	s.mu.Lock()
+	end := frame.Offset + uint64(len(frame.Data))
+	if s.finReceived && (end > s.finOffset || frame.Fin && end != s.finOffset) {
+		s.mu.Unlock()
+		return 0, 0, qerr(QUIC_STREAM_DATA_AFTER_TERMINATION, "data after the end of stream %d", s.id)
+	}
+	increment, ok := s.flowController.UpdateHighestReceived(end)
+	if !ok {
+		s.mu.Unlock()
+		return 0, 0, qerr(QUIC_FLOW_CONTROL_RECEIVED_TOO_MUCH_DATA, "flow control violation on stream %d", s.id)
+	}
+	if frame.Fin {
+		s.finReceived = true
+		s.finOffset = end
+	}
+	if end > s.readOffset {
+		if s.err != nil || s.readStopped {
+			unread = end - s.readOffset
+			s.readOffset = end
+		} else if len(frame.Data) > 0 {
+			s.frames[frame.Offset] = frame.Data
+		}
+	}
+	s.mu.Unlock()
+	s.signalRead()
+	return increment, unread, nil
+}
+
+// handleResetStream aborts the stream after the peer reset it. It returns by
+// how much the highest received offset grew and how many bytes will never be
+// read, both of which count against connection flow control. A reset with
+// QUIC_STREAM_NO_ERROR only means the peer won't read the rest of our data:
+// we stop sending, and what it sent up to its final offset can still be
+// read.
+func (s *Stream) handleResetStream(frame FrameResetStream) (increment, unread uint64, err error) {
+	s.mu.Lock()
+	defer s.signalRead()
+	defer s.mu.Unlock()
+	if s.finReceived && frame.ByteOffset != s.finOffset {
+		return 0, 0, qerr(QUIC_STREAM_DATA_AFTER_TERMINATION, "stream %d reset at a different final offset", s.id)
+	}
+	if frame.ByteOffset < s.flowController.HighestReceived() {
+		return 0, 0, qerr(QUIC_STREAM_DATA_AFTER_TERMINATION, "stream %d reset below received data", s.id)
+	}
+	increment, ok := s.flowController.UpdateHighestReceived(frame.ByteOffset)
+	if !ok {
+		return 0, 0, qerr(QUIC_FLOW_CONTROL_RECEIVED_TOO_MUCH_DATA, "flow control violation on stream %d", s.id)
+	}
+	s.finReceived = true
+	s.finOffset = frame.ByteOffset
+	if frame.ErrorCode == QUIC_STREAM_NO_ERROR && s.err == nil {
+		s.writeErr = &ApplicationError{StreamID: s.id, ErrorCode: frame.ErrorCode, Remote: true}
+		s.dataForWriting = nil
+		s.finQueued = true
+		s.finSent = true
+		return increment, 0, nil
+	}
+	if s.readOffset < s.finOffset {
+		unread = s.finOffset - s.readOffset
+		s.readOffset = s.finOffset
+	}
+	s.frames = map[uint64]string{}
+	if s.err == nil {
+		s.err = &ApplicationError{StreamID: s.id, ErrorCode: frame.ErrorCode, Remote: true}
+	}
+	return increment, unread, nil
+}
+
+// signalRead wakes up a blocked Read.
+func (s *Stream) signalRead() {
+	select {
+	case s.readChan <- struct{}{}:
+	default:
+	}
+}
+
+// hasDataForWriting reports whether the stream has data or a FIN to send.
+func (s *Stream) hasDataForWriting() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil {
+		return false
+	}
+	if len(s.dataForWriting) > 0 {
+		return s.flowController.SendWindowSize() > 0
+	}
+	return s.finQueued && !s.finSent
+}
+
+// popStreamFrame returns a frame with up to maxBytes of the data waiting to
+// be sent, as far as the stream and connection flow control windows allow.
+func (s *Stream) popStreamFrame(maxBytes int) (FrameStream, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil || len(s.dataForWriting) == 0 && (!s.finQueued || s.finSent) {
+		return FrameStream{}, false
+	}
+	n := uint64(len(s.dataForWriting))
+	if n > uint64(maxBytes) {
+		n = uint64(maxBytes)
+	}
+	if window := s.flowController.SendWindowSize(); n > window {
+		n = window
+	}
+	if window := s.session.connFlowController.SendWindowSize(); n > window {
+		n = window
+	}
+	if n == 0 && len(s.dataForWriting) > 0 {
+		// Blocked by flow control.
+		return FrameStream{}, false
+	}
+	s.flowController.AddBytesSent(n)
+	s.session.connFlowController.AddBytesSent(n)
+	frame := FrameStream{
+		StreamID: s.id,
+		Offset:   s.writeOffset,
+		Data:     string(s.dataForWriting[:n]),
+	}
+	s.dataForWriting = s.dataForWriting[n:]
+	s.writeOffset += n
+	if s.finQueued && len(s.dataForWriting) == 0 {
+		frame.Fin = true
+		s.finSent = true
+		if s.readStopped {
+			// popStreamFrame runs on the session goroutine.
+			s.session.queueFrame(s.stopReadingFrame(s.writeOffset))
+		}
+	}
+	return frame, true
+}
+
+// finished reports whether the stream is done in both directions and can be
+// forgotten by the session.
+func (s *Stream) finished() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.resetSent {
+		return s.finReceived
+	}
+	if s.readStopped {
+		return s.finSent && s.finReceived
+	}
+	if s.err != nil {
+		return true
+	}
+	return s.finSent && s.finReceived && s.readOffset >= s.finOffset
+}
+
+// writeState returns the offset up to which data has been sent and whether
+// the FIN has been sent.
+func (s *Stream) writeState() (uint64, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.writeOffset, s.finSent
+}
+
+// reset aborts the stream with err and sends the peer a RST_STREAM with
+// QUIC_STREAM_CANCELLED. Data received but not read, and any the peer sends
+// before it learns about the reset, is given back to connection flow control.
+func (s *Stream) reset(err error) {
+	s.mu.Lock()
+	if s.err != nil || s.finSent && s.finReceived && s.readOffset >= s.finOffset {
+		// Already torn down or done in both directions.
+		s.mu.Unlock()
+		return
+	}
+	s.err = err
+	s.resetSent = true
+	// The RST_STREAM carries our final offset.
+	s.finSent = true
+	s.dataForWriting = nil
+	offset := s.writeOffset
+	highest := s.flowController.HighestReceived()
+	var unread uint64
+	if highest > s.readOffset {
+		unread = highest - s.readOffset
+		s.readOffset = highest
+	}
+	s.frames = map[uint64]string{}
+	s.mu.Unlock()
+	s.signalRead()
+
+	s.flowController.AddBytesRead(unread)
+	s.session.connFlowController.AddBytesRead(unread)
+	s.session.do(func() {
+		s.session.queueFrame(FrameResetStream{
+			StreamID:   s.id,
+			ErrorCode:  QUIC_STREAM_CANCELLED,
+			ByteOffset: offset,
+		})
+	})
+}
+
+// stopReading tells the peer we won't read the rest of its data, and gives
+// what it sent back to connection flow control. The RST_STREAM with
+// QUIC_STREAM_NO_ERROR goes out once our FIN has, so the peer still gets
+// everything we wrote.
+func (s *Stream) stopReading() {
+	s.mu.Lock()
+	if s.err != nil || s.readStopped || s.finReceived && s.readOffset >= s.finOffset {
+		s.mu.Unlock()
+		return
+	}
+	s.readStopped = true
+	highest := s.flowController.HighestReceived()
+	var unread uint64
+	if highest > s.readOffset {
+		unread = highest - s.readOffset
+		s.readOffset = highest
+	}
+	s.frames = map[uint64]string{}
+	finSent := s.finSent
+	offset := s.writeOffset
+	s.mu.Unlock()
+	s.signalRead()
+
+	s.flowController.AddBytesRead(unread)
+	s.session.connFlowController.AddBytesRead(unread)
+	if finSent {
+		s.session.do(func() {
+			s.session.queueFrame(s.stopReadingFrame(offset))
+		})
+	}
+}
+
+// stopReadingFrame returns the RST_STREAM telling the peer we stopped
+// reading, with our final offset.
+func (s *Stream) stopReadingFrame(offset uint64) FrameResetStream {
+	return FrameResetStream{
+		StreamID:   s.id,
+		ErrorCode:  QUIC_STREAM_NO_ERROR,
+		ByteOffset: offset,
+	}
+}
+
+// cancel tears the stream down with err, unblocking any Read.
+func (s *Stream) cancel(err error) {
+	s.mu.Lock()
+	if s.err == nil {
+		s.err = err
+	}
+	s.mu.Unlock()
+	s.signalRead()
+}
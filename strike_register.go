@@ -0,0 +1,95 @@
+package quic
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Strike register constants
+const (
+	// strikeRegisterWindow is how far a client nonce's time may be from ours.
+	// Nonces are remembered for this long, older ones are rejected.
+	strikeRegisterWindow = 10 * time.Minute
+	// maxStrikeRegisterEntries bounds the memory the register uses.
+	maxStrikeRegisterEntries = 1 << 16
+)
+
+var (
+	errNonceInvalid  = errors.New("quic: client nonce for a different orbit")
+	errNonceStale    = errors.New("quic: client nonce outside the time window")
+	errNonceReplayed = errors.New("quic: client nonce seen before")
+)
+
+// strikeRegister remembers the client nonces of the CHLOs a server accepted,
+// so a recorded CHLO and the 0-RTT data following it can't be replayed. A
+// nonce holds the time it was made at, so only those from the last
+// strikeRegisterWindow need to be kept: anything older is rejected outright.
+// Nonces from before the register was created, or from before it last
+// overflowed, may have been seen and are rejected too. Nonce times are whole
+// seconds, so that horizon is rounded down to one.
+type strikeRegister struct {
+	now   func() time.Time
+	orbit []byte
+
+	mu      sync.Mutex
+	nonces  map[string]time.Time
+	horizon time.Time
+}
+
+// newStrikeRegister returns an empty register for nonces carrying orbit,
+// reading the time from now.
+func newStrikeRegister(now func() time.Time, orbit []byte) *strikeRegister {
+	return &strikeRegister{
+		now:     now,
+		orbit:   orbit,
+		nonces:  map[string]time.Time{},
+		horizon: now().Truncate(time.Second),
+	}
+}
+
+// Insert checks a client nonce and remembers it. It fails if the nonce is
+// for another orbit, is too old or too far in the future, or was inserted
+// before.
+func (r *strikeRegister) Insert(nonce []byte) error {
+	if len(nonce) != clientNonceSize || !bytes.Equal(nonce[4:4+orbitSize], r.orbit) {
+		return errNonceInvalid
+	}
+	nonceTime := time.Unix(int64(binary.BigEndian.Uint32(nonce)), 0)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := r.now()
+	if d := now.Sub(nonceTime); d > strikeRegisterWindow || d < -strikeRegisterWindow {
+		return errNonceStale
+	}
+	if nonceTime.Before(r.horizon) {
+		return errNonceStale
+	}
+	if _, ok := r.nonces[string(nonce)]; ok {
+		return errNonceReplayed
+	}
+	if len(r.nonces) >= maxStrikeRegisterEntries {
+		r.expire(now)
+	}
+	if len(r.nonces) >= maxStrikeRegisterEntries {
+		// Forget everything and reject the nonces we forgot about along
+		// with anything older, up to the end of the current second.
+		r.nonces = map[string]time.Time{}
+		r.horizon = now.Truncate(time.Second).Add(time.Second)
+		return errNonceStale
+	}
+	r.nonces[string(nonce)] = nonceTime
+	return nil
+}
+
+// expire forgets the nonces that are too old to be accepted anyway.
+func (r *strikeRegister) expire(now time.Time) {
+	for nonce, t := range r.nonces {
+		if now.Sub(t) > strikeRegisterWindow {
+			delete(r.nonces, nonce)
+		}
+	}
+}
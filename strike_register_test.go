@@ -0,0 +1,97 @@
+package quic
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStrikeRegister(t *testing.T) {
+	orbit := []byte("orbit123")
+	start := time.Unix(1500000000, 0)
+	tests := []struct {
+		name string
+		// made is when the nonce was made, relative to the register's
+		// creation.
+		made time.Duration
+		// later is how long after the register's creation it is inserted.
+		later time.Duration
+		orbit []byte
+		want  error
+	}{
+		{name: "fresh", orbit: orbit},
+		{name: "client clock ahead", made: strikeRegisterWindow, orbit: orbit},
+		{name: "too far in the future", made: strikeRegisterWindow + time.Second, orbit: orbit, want: errNonceStale},
+		{name: "inserted late", later: strikeRegisterWindow, orbit: orbit},
+		{name: "too old", made: time.Second, later: strikeRegisterWindow + 2*time.Second, orbit: orbit, want: errNonceStale},
+		{name: "before the register", made: -time.Second, orbit: orbit, want: errNonceStale},
+		{name: "other orbit", orbit: []byte("orbit456"), want: errNonceInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := &fakeClock{now: start}
+			r := newStrikeRegister(clock.Now, orbit)
+			nonce, err := newClientNonce(start.Add(tt.made), tt.orbit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			clock.now = clock.now.Add(tt.later)
+			if err := r.Insert(nonce); err != tt.want {
+				t.Fatalf("Insert() = %v, want %v", err, tt.want)
+			}
+			if tt.want == nil {
+				if err := r.Insert(nonce); err != errNonceReplayed {
+					t.Errorf("Insert() of a replayed nonce = %v, want %v", err, errNonceReplayed)
+				}
+			}
+		})
+	}
+}
+
+func TestStrikeRegisterInvalidNonce(t *testing.T) {
+	r := newStrikeRegister(time.Now, []byte("orbit123"))
+	if err := r.Insert(make([]byte, clientNonceSize-1)); err != errNonceInvalid {
+		t.Errorf("Insert() of a short nonce = %v, want %v", err, errNonceInvalid)
+	}
+}
+
+func TestStrikeRegisterFull(t *testing.T) {
+	orbit := []byte("orbit123")
+	clock := &fakeClock{now: time.Unix(1500000000, 0)}
+	r := newStrikeRegister(clock.Now, orbit)
+	insert := func() error {
+		nonce, err := newClientNonce(clock.now, orbit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return r.Insert(nonce)
+	}
+	for i := 0; i < maxStrikeRegisterEntries; i++ {
+		if err := insert(); err != nil {
+			t.Fatalf("Insert() %d = %v", i, err)
+		}
+	}
+
+	// Once the old nonces expire there's room again.
+	clock.now = clock.now.Add(strikeRegisterWindow + time.Second)
+	if err := insert(); err != nil {
+		t.Fatalf("Insert() after the nonces expired = %v", err)
+	}
+
+	// Otherwise everything up to now is forgotten and rejected.
+	clock.now = clock.now.Add(time.Second)
+	for i := 1; i < maxStrikeRegisterEntries; i++ {
+		if err := insert(); err != nil {
+			t.Fatalf("Insert() %d = %v", i, err)
+		}
+	}
+	if err := insert(); err != errNonceStale {
+		t.Fatalf("Insert() into a full register = %v, want %v", err, errNonceStale)
+	}
+	if len(r.nonces) != 0 {
+		t.Errorf("register holds %d nonces after overflowing", len(r.nonces))
+	}
+	clock.now = clock.now.Add(time.Second)
+	if err := insert(); err != nil {
+		t.Errorf("Insert() of a newer nonce after overflowing = %v", err)
+	}
+}
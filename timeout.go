@@ -0,0 +1,77 @@
+package quic
+
+import "time"
+
+// Timeout constants
+const (
+	// defaultIdleTimeout is how long a session may go without network
+	// activity before it is closed.
+	defaultIdleTimeout = 30 * time.Second
+	// maxIdleTimeout is the largest idle timeout that will be negotiated.
+	maxIdleTimeout = 10 * time.Minute
+	// defaultHandshakeTimeout is how long the crypto handshake may take.
+	defaultHandshakeTimeout = 10 * time.Second
+)
+
+// negotiateIdleTimeout returns the idle timeout to use when the peer sent
+// icsl seconds in ICSL and ours is the one we offered: the smaller of the
+// two. A missing or zero ICSL leaves ours, and no value exceeds
+// maxIdleTimeout.
+func negotiateIdleTimeout(icsl uint32, ok bool, ours time.Duration) time.Duration {
+	if ours > maxIdleTimeout {
+		ours = maxIdleTimeout
+	}
+	if !ok || icsl == 0 || uint64(icsl) >= uint64(ours/time.Second) {
+		return ours
+	}
+	return time.Duration(icsl) * time.Second
+}
+
+// lastActivityTime is when the session last saw network activity: the
+// last received packet, or the first packet we sent after it.
+func (s *Session) lastActivityTime() time.Time {
+	if s.firstSentAfterReceiveTime.After(s.lastReceivedTime) {
+		return s.firstSentAfterReceiveTime
+	}
+	return s.lastReceivedTime
+}
+
+// keepAliveDeadline returns when a keepalive PING should be sent to stop a
+// NAT binding from expiring before the idle timeout.
+func (s *Session) keepAliveDeadline() (time.Time, bool) {
+	if !s.config.keepAlive() || s.bytesInFlight > 0 {
+		return time.Time{}, false
+	}
+	return s.lastReceivedTime.Add(s.idleTimeout / 2), true
+}
+
+// setTimeoutAlarm arms the timeout timer for the earliest of the idle
+// timeout, handshake timeout and keepalive.
+func (s *Session) setTimeoutAlarm() {
+	deadline := s.lastActivityTime().Add(s.idleTimeout)
+	if !s.handshakeComplete {
+		if d := s.createdTime.Add(s.config.handshakeTimeout()); d.Before(deadline) {
+			deadline = d
+		}
+	}
+	if d, ok := s.keepAliveDeadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	s.timeoutTimer.Reset(time.Until(deadline))
+}
+
+// onTimeout handles the timeout timer firing.
+func (s *Session) onTimeout() {
+	now := time.Now()
+	if !s.handshakeComplete && now.Sub(s.createdTime) >= s.config.handshakeTimeout() {
+		s.closeWithError(QUIC_CONNECTION_OVERALL_TIMED_OUT, "crypto handshake timed out")
+		return
+	}
+	if now.Sub(s.lastActivityTime()) >= s.idleTimeout {
+		s.closeWithError(QUIC_CONNECTION_TIMED_OUT, "no recent network activity")
+		return
+	}
+	if d, ok := s.keepAliveDeadline(); ok && !now.Before(d) {
+		s.queueFrame(FramePing{})
+	}
+}
@@ -0,0 +1,184 @@
+package quic
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestNegotiateIdleTimeout(t *testing.T) {
+	tests := []struct {
+		name string
+		icsl uint32
+		ok   bool
+		ours time.Duration
+		want time.Duration
+	}{
+		{"missing", 0, false, 30 * time.Second, 30 * time.Second},
+		{"zero", 0, true, 30 * time.Second, 30 * time.Second},
+		{"lower", 5, true, 30 * time.Second, 5 * time.Second},
+		{"equal", 30, true, 30 * time.Second, 30 * time.Second},
+		{"higher", 60, true, 30 * time.Second, 30 * time.Second},
+		{"largest ICSL", 1<<32 - 1, true, 30 * time.Second, 30 * time.Second},
+		{"ours above the maximum", 1<<32 - 1, true, time.Hour, maxIdleTimeout},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := negotiateIdleTimeout(tt.icsl, tt.ok, tt.ours); got != tt.want {
+				t.Errorf("negotiateIdleTimeout(%d, %t, %s) = %s, want %s", tt.icsl, tt.ok, tt.ours, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandleCHLOIdleTimeout(t *testing.T) {
+	tests := []struct {
+		name string
+		icsl uint32
+		want time.Duration
+	}{
+		{"lower", 5, 5 * time.Second},
+		{"higher than the maximum", 1<<32 - 1, defaultIdleTimeout},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _ := newTestSession(t)
+			chlo := fullCHLO(t, s.server, s.RemoteAddr(), time.Now())
+			chlo.SetUint32(TagICSL, tt.icsl)
+			var err error
+			var idleTimeout time.Duration
+			var sent uint32
+			doSync(s, func() {
+				if err = s.handleCHLO(chlo); err != nil {
+					return
+				}
+				idleTimeout = s.idleTimeout
+				if shlo := lastHandshakeMessage(s); shlo != nil {
+					sent, _ = shlo.GetUint32(TagICSL)
+				}
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if idleTimeout != tt.want {
+				t.Errorf("idle timeout = %s, want %s", idleTimeout, tt.want)
+			}
+			if want := uint32(tt.want / time.Second); sent != want {
+				t.Errorf("SHLO has ICSL %d, want %d", sent, want)
+			}
+		})
+	}
+}
+
+func TestHandleSHLOIdleTimeout(t *testing.T) {
+	tests := []struct {
+		name string
+		icsl uint32
+		want time.Duration
+	}{
+		{"lower", 5, 5 * time.Second},
+		{"higher than we asked for", 60, 20 * time.Second},
+		{"higher than the maximum", 1<<32 - 1, 20 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := &recordingConn{}
+			addr := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 4433}
+			s := newSession(conn, addr, 1, &Config{IdleTimeout: 20 * time.Second}, nil, nil)
+			t.Cleanup(func() { s.Close() })
+
+			config, err := newServerConfig(time.Now().Add(time.Hour), make([]byte, orbitSize))
+			if err != nil {
+				t.Fatal(err)
+			}
+			clientKey, err := ecdh.X25519().GenerateKey(rand.Reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			serverKey, err := ecdh.X25519().GenerateKey(rand.Reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			shlo := NewHandshakeMessage(TagSHLO)
+			shlo.SetUint32(TagICSL, tt.icsl)
+			shlo.Values[TagPUBS] = serverKey.PublicKey().Bytes()
+
+			var idleTimeout time.Duration
+			doSync(s, func() {
+				if s.serverConfig, err = parseServerConfig(config.serialized); err != nil {
+					return
+				}
+				s.clientKey = clientKey
+				s.clientNonce = make([]byte, clientNonceSize)
+				s.clientHello = []byte("CHLO")
+				s.receivedLevel = encryptionInitial
+				if err = s.handleSHLO(shlo); err != nil {
+					return
+				}
+				idleTimeout = s.idleTimeout
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if idleTimeout != tt.want {
+				t.Errorf("idle timeout = %s, want %s", idleTimeout, tt.want)
+			}
+		})
+	}
+}
+
+func TestIdleTimeout(t *testing.T) {
+	s, _ := newTestSession(t)
+	doSync(s, func() {
+		s.idleTimeout = 50 * time.Millisecond
+		s.setTimeoutAlarm()
+	})
+	select {
+	case <-s.closeChan:
+	case <-time.After(2 * time.Second):
+		t.Fatal("idle session wasn't closed")
+	}
+	if err := s.Err(); !errors.Is(err, QUIC_CONNECTION_TIMED_OUT) {
+		t.Errorf("Err() = %v, want QUIC_CONNECTION_TIMED_OUT", err)
+	}
+}
+
+func TestIdleTimeoutActivity(t *testing.T) {
+	s, _ := newTestSession(t)
+	doSync(s, func() {
+		s.idleTimeout = 200 * time.Millisecond
+		s.setTimeoutAlarm()
+	})
+	// Packets from the peer keep the session alive past the idle timeout.
+	for i := 0; i < 4; i++ {
+		time.Sleep(100 * time.Millisecond)
+		doSync(s, func() {
+			s.lastReceivedTime = time.Now()
+			s.setTimeoutAlarm()
+		})
+	}
+	if err := s.Err(); err != nil {
+		t.Fatalf("session with recent activity closed: %v", err)
+	}
+}
+
+func TestHandshakeTimeout(t *testing.T) {
+	// A server that never answers.
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	start := time.Now()
+	_, err = Dial(conn.LocalAddr().String(), &Config{HandshakeTimeout: 200 * time.Millisecond})
+	if !errors.Is(err, QUIC_CONNECTION_OVERALL_TIMED_OUT) {
+		t.Fatalf("Dial() = %v, want QUIC_CONNECTION_OVERALL_TIMED_OUT", err)
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("handshake timed out after %s", d)
+	}
+}